@@ -11,17 +11,19 @@ import (
 )
 
 type acceptor struct {
-	sid        int64
-	gateClient clusterpb.MemberClient
-	session    *session.Session
-	lastMid    uint64
-	rpcHandler rpcHandler
-	gateAddr   string
+	sid         int64
+	transport   *transport
+	session     *session.Session
+	lastMid     uint64
+	rpcHandler  rpcHandler
+	callHandler callHandler
+	gateAddr    string
+	synced      syncState // session data synchronized with gate
+	affinity    affinity  // members which the messages of the session have been forwarded to
 }
 
 // Push implements the session.NetworkEntity interface
 func (a *acceptor) Push(route string, v interface{}) error {
-	// TODO: buffer
 	data, err := message.Serialize(v)
 	if err != nil {
 		return err
@@ -31,26 +33,30 @@ func (a *acceptor) Push(route string, v interface{}) error {
 		Route:     route,
 		Data:      data,
 	}
-	_, err = a.gateClient.HandlePush(context.Background(), request)
-	return err
+	return a.send(&clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Push{Push: request}})
 }
 
 // RPC implements the session.NetworkEntity interface
 func (a *acceptor) RPC(route string, v interface{}) error {
+	return a.RPCContext(context.Background(), route, v)
+}
+
+// RPCContext implements the session.NetworkEntity interface
+func (a *acceptor) RPCContext(ctx context.Context, route string, v interface{}) error {
 	// TODO: buffer
-	data, err := message.Serialize(v)
+	msg, err := rpcMessage(ctx, route, v)
 	if err != nil {
 		return err
 	}
-	msg := &message.Message{
-		Type:  message.Notify,
-		Route: route,
-		Data:  data,
-	}
 	a.rpcHandler(a.session, msg, true)
 	return nil
 }
 
+// Call implements the session.NetworkEntity interface
+func (a *acceptor) Call(ctx context.Context, route string, v interface{}, reply interface{}) error {
+	return a.callHandler(ctx, a.session, route, v, reply)
+}
+
 // LastMid implements the session.NetworkEntity interface
 func (a *acceptor) LastMid() uint64 {
 	return a.lastMid
@@ -63,7 +69,6 @@ func (a *acceptor) Response(v interface{}) error {
 
 // ResponseMid implements the session.NetworkEntity interface
 func (a *acceptor) ResponseMid(mid uint64, v interface{}) error {
-	// TODO: buffer
 	data, err := message.Serialize(v)
 	if err != nil {
 		return err
@@ -73,21 +78,42 @@ func (a *acceptor) ResponseMid(mid uint64, v interface{}) error {
 		Id:        mid,
 		Data:      data,
 	}
-	_, err = a.gateClient.HandleResponse(context.Background(), request)
-	return err
+	return a.send(&clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Response{Response: request}})
 }
 
 // Close implements the session.NetworkEntity interface
 func (a *acceptor) Close() error {
-	// TODO: buffer
 	request := &clusterpb.CloseSessionRequest{
 		SessionId: a.sid,
 	}
-	_, err := a.gateClient.CloseSession(context.Background(), request)
-	return err
+	return a.send(&clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_CloseSession{CloseSession: request}})
+}
+
+// send sends the message to gate, the messages sent through the member stream are
+// delivered in order, and the error of handling will not be returned
+func (a *acceptor) send(m *clusterpb.StreamMessage) error {
+	return a.transport.send(context.Background(), a.gateAddr, m)
 }
 
 // RemoteAddr implements the session.NetworkEntity interface
 func (*acceptor) RemoteAddr() net.Addr {
 	return mock.NetAddr{}
 }
+
+// rpcMessage returns the notify message carries the metadata and deadline of ctx
+func rpcMessage(ctx context.Context, route string, v interface{}) (*message.Message, error) {
+	data, err := message.Serialize(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := &message.Message{
+		Type:     message.Notify,
+		Route:    route,
+		Data:     data,
+		Metadata: contextMetadata(ctx),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Deadline = deadline
+	}
+	return msg, nil
+}
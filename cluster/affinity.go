@@ -0,0 +1,121 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/session"
+)
+
+// affinity records the members which have received the messages of a session, the
+// close of the session will be notified to them only
+type affinity struct {
+	mu      sync.Mutex
+	members map[string]struct{}
+}
+
+func (a *affinity) add(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.members == nil {
+		a.members = map[string]struct{}{}
+	}
+	a.members[addr] = struct{}{}
+}
+
+func (a *affinity) remove(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.members, addr)
+}
+
+// touchSession records that the member of addr has received the messages of session
+func touchSession(s *session.Session, addr string) {
+	switch e := s.NetworkEntity().(type) {
+	case *agent:
+		e.affinity.add(addr)
+	case *acceptor:
+		e.affinity.add(addr)
+	}
+}
+
+// forgetSessionMember removes the member of addr from the members which have received
+// the messages of session, it will be called if the member of addr left cluster, so the
+// close of session will not be sent to the address left
+func forgetSessionMember(s *session.Session, addr string) {
+	switch e := s.NetworkEntity().(type) {
+	case *agent:
+		e.affinity.remove(addr)
+	case *acceptor:
+		e.affinity.remove(addr)
+	}
+}
+
+// sessionMembers returns the members which have received the messages of session,
+// including the members bound in the router of session
+func sessionMembers(s *session.Session) map[string]struct{} {
+	var a *affinity
+	switch e := s.NetworkEntity().(type) {
+	case *agent:
+		a = &e.affinity
+	case *acceptor:
+		a = &e.affinity
+	}
+
+	members := map[string]struct{}{}
+	if a != nil {
+		a.mu.Lock()
+		for addr := range a.members {
+			members[addr] = struct{}{}
+		}
+		a.mu.Unlock()
+	}
+	s.Router().Range(func(_, addr string) bool {
+		members[addr] = struct{}{}
+		return true
+	})
+	return members
+}
+
+// notifySessionClosed notifies the members which have received the messages of the
+// closed session asynchronously, the notifications to the same member are batched
+// by the member stream, and sent after the messages of the session.
+func (n *Node) notifySessionClosed(s *session.Session, request *clusterpb.SessionClosedRequest) {
+	members := sessionMembers(s)
+	delete(members, n.ServiceAddr)
+	delete(members, request.GateAddr)
+	if len(members) == 0 || n.transport == nil {
+		return
+	}
+
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_SessionClosed{SessionClosed: request}}
+	go func() {
+		for addr := range members {
+			if err := n.transport.send(context.Background(), addr, m); err != nil {
+				logger.Logger.Tracef("Notify member[%s] session[%d] closed error: %v", addr, request.SessionId, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+func TestSessionClosedAffinity(t *testing.T) {
+	go scheduler.Sched()
+
+	gate := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "affinity.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	startMember := func(addr string, comps *component.Components) *Node {
+		member := &Node{
+			Options:     Options{AdvertiseAddr: gate.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := member.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+	comps := &component.Components{}
+	comps.Register(&AccountComponent{})
+	account := startMember("affinity.account", comps)
+	defer account.Shutdown()
+	forwarded := startMember("affinity.forwarded", &component.Components{})
+	defer forwarded.Shutdown()
+	left := startMember("affinity.left", &component.Components{})
+
+	conn, peer := net.Pipe()
+	go gate.handler.handle(conn, "", "")
+	var s *session.Session
+	waitFor(t, func() bool {
+		gate.mu.RLock()
+		defer gate.mu.RUnlock()
+		for _, v := range gate.sessions {
+			s = v
+		}
+		return s != nil
+	})
+
+	pong := &testdata.Pong{}
+	if err := gate.handler.call(context.Background(), s, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	members := sessionMembers(s)
+	if _, found := members[account.ServiceAddr]; !found || len(members) != 1 {
+		t.Fatalf("expect only account member received the session, got: %v", members)
+	}
+
+	// the member forwarded the session to another member
+	backend := account.findSession(s.ID())
+	if backend == nil {
+		t.Fatal("expect session created in account member")
+	}
+	if _, err := forwarded.findOrCreateSession(s.ID(), gate.ServiceAddr); err != nil {
+		t.Fatal(err)
+	}
+	touchSession(backend, forwarded.ServiceAddr)
+
+	// the member left cluster is forgotten, the close will not be sent to its address
+	touchSession(s, left.ServiceAddr)
+	left.Shutdown()
+	waitFor(t, func() bool {
+		_, found := sessionMembers(s)[left.ServiceAddr]
+		return !found
+	})
+
+	// the close is notified to the members which received the session only
+	peer.Close()
+	waitFor(t, func() bool { return account.findSession(s.ID()) == nil })
+	waitFor(t, func() bool { return forwarded.findSession(s.ID()) == nil })
+}
@@ -22,6 +22,7 @@ package cluster
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -65,8 +66,14 @@ type (
 		decoder  *codec.Decoder      // binary decoder
 		pipeline pipeline.Pipeline
 
-		rpcHandler rpcHandler
-		srv        reflect.Value // cached session reflect.Value
+		rpcHandler  rpcHandler
+		callHandler callHandler
+		srv         reflect.Value   // cached session reflect.Value
+		synced      syncState       // session data synchronized to backends
+		affinity    affinity        // members which have received messages of the session
+		holds       sessionHolds    // messages held while the services of session are being migrated
+		requests    pendingRequests // requests forwarded to backends and not responded yet
+		idleTimeout time.Duration   // close the session without any packet for the duration
 	}
 
 	pendingMessage struct {
@@ -74,20 +81,22 @@ type (
 		route   string       // message route(push)
 		mid     uint64       // response message id(response)
 		payload interface{}  // payload
+		closing bool         // close the session after the messages queued before written
 	}
 )
 
 // Create new agent instance
-func newAgent(conn net.Conn, ip, userAgent string, pipeline pipeline.Pipeline, rpcHandler rpcHandler) *agent {
+func newAgent(conn net.Conn, ip, userAgent string, pipeline pipeline.Pipeline, rpcHandler rpcHandler, callHandler callHandler) *agent {
 	a := &agent{
-		conn:       conn,
-		state:      statusStart,
-		chDie:      make(chan struct{}),
-		lastAt:     time.Now().Unix(),
-		chSend:     make(chan pendingMessage, agentWriteBacklog),
-		decoder:    codec.NewDecoder(),
-		pipeline:   pipeline,
-		rpcHandler: rpcHandler,
+		conn:        conn,
+		state:       statusStart,
+		chDie:       make(chan struct{}),
+		lastAt:      time.Now().Unix(),
+		chSend:      make(chan pendingMessage, agentWriteBacklog),
+		decoder:     codec.NewDecoder(),
+		pipeline:    pipeline,
+		rpcHandler:  rpcHandler,
+		callHandler: callHandler,
 	}
 
 	// binding session
@@ -100,6 +109,14 @@ func newAgent(conn net.Conn, ip, userAgent string, pipeline pipeline.Pipeline, r
 	return a
 }
 
+// idle returns the duration after which the session without any packet is closed
+func (a *agent) idle() time.Duration {
+	if a.idleTimeout > 0 {
+		return a.idleTimeout
+	}
+	return 2 * env.Heartbeat
+}
+
 func (a *agent) send(m pendingMessage) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -141,24 +158,32 @@ func (a *agent) Push(route string, v interface{}) error {
 
 // RPC, implementation for session.NetworkEntity interface
 func (a *agent) RPC(route string, v interface{}) error {
+	return a.RPCContext(context.Background(), route, v)
+}
+
+// RPCContext, implementation for session.NetworkEntity interface
+func (a *agent) RPCContext(ctx context.Context, route string, v interface{}) error {
 	if a.status() == statusClosed {
 		return ErrBrokenPipe
 	}
 
 	// TODO: buffer
-	data, err := message.Serialize(v)
+	msg, err := rpcMessage(ctx, route, v)
 	if err != nil {
 		return err
 	}
-	msg := &message.Message{
-		Type:  message.Notify,
-		Route: route,
-		Data:  data,
-	}
 	a.rpcHandler(a.session, msg, true)
 	return nil
 }
 
+// Call, implementation for session.NetworkEntity interface
+func (a *agent) Call(ctx context.Context, route string, v interface{}, reply interface{}) error {
+	if a.status() == statusClosed {
+		return ErrBrokenPipe
+	}
+	return a.callHandler(ctx, a.session, route, v, reply)
+}
+
 // Response, implementation for session.NetworkEntity interface
 // Response message to session
 func (a *agent) Response(v interface{}) error {
@@ -172,6 +197,12 @@ func (a *agent) ResponseMid(mid uint64, v interface{}) error {
 		return ErrBrokenPipe
 	}
 
+	// The timeout error has been replied to client
+	if !a.requests.finish(mid, nil) {
+		logger.Logger.Tracef(fmt.Sprintf("Drop the late response of request %d, SessionID=%d", mid, a.session.ID()))
+		return nil
+	}
+
 	//if mid <= 0 {
 	//	return ErrSessionOnNotify
 	//}
@@ -202,6 +233,7 @@ func (a *agent) Close() error {
 		return ErrCloseClosedSession
 	}
 	a.setStatus(statusClosed)
+	a.requests.clear()
 
 	if env.Debug {
 		logger.Logger.Tracef(fmt.Sprintf("Session closed, ID=%d, UID=%d, IP=%s",
@@ -220,6 +252,19 @@ func (a *agent) Close() error {
 	return a.conn.Close()
 }
 
+// closeAfterFlush closes the session after the messages queued before have been written,
+// e.g. the reason pushed before the session is kicked, the session is closed immediately
+// if the queue is full
+func (a *agent) closeAfterFlush() error {
+	if a.status() == statusClosed {
+		return ErrCloseClosedSession
+	}
+	if len(a.chSend) >= agentWriteBacklog {
+		return a.Close()
+	}
+	return a.send(pendingMessage{closing: true})
+}
+
 // RemoteAddr, implementation for session.NetworkEntity interface
 // returns the remote network address.
 func (a *agent) RemoteAddr() net.Addr {
@@ -256,7 +301,7 @@ func (a *agent) write() {
 	for {
 		select {
 		case <-ticker.C:
-			deadline := time.Now().Add(-2 * env.Heartbeat).Unix()
+			deadline := time.Now().Add(-a.idle()).Unix()
 			if atomic.LoadInt64(&a.lastAt) < deadline {
 				logger.Logger.Tracef(fmt.Sprintf("Session heartbeat timeout, LastTime=%d, Deadline=%d", atomic.LoadInt64(&a.lastAt), deadline))
 				return
@@ -271,6 +316,16 @@ func (a *agent) write() {
 			}
 
 		case data := <-a.chSend:
+			// The messages queued before have been moved to chWrite in order
+			if data.closing {
+				for len(chWrite) > 0 {
+					if _, err := a.conn.Write(<-chWrite); err != nil {
+						logger.Logger.Tracef(err.Error())
+						break
+					}
+				}
+				return
+			}
 			//payload, err := message.Serialize(data.payload)
 			//if err != nil {
 			//	switch data.typ {
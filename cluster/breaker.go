@@ -0,0 +1,321 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+const (
+	breakerWindow             = 10 * time.Second // the calls are counted in the window
+	breakerMinCalls           = 10               // min calls in window before the error rate is checked
+	defaultBreakerOpenTimeout = 5 * time.Second
+)
+
+// States of the circuit breaker
+const (
+	breakerClosed int32 = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStates = map[int32]string{
+	breakerClosed:   "closed",
+	breakerOpen:     "open",
+	breakerHalfOpen: "half-open",
+}
+
+type (
+	// circuitBreaker stops the calls to a member once the error rate of the calls exceeds
+	// the threshold, the calls slower than the slow call duration are counted as errors.
+	// The breaker turns half-open after the open timeout, which allows a single trial
+	// call, the breaker is closed if the trial succeeds, and opened again otherwise.
+	circuitBreaker struct {
+		errorRate   float64
+		slowCall    time.Duration
+		openTimeout time.Duration
+
+		mu          sync.Mutex
+		state       int32
+		windowStart time.Time
+		calls       int
+		failures    int
+		openedAt    time.Time
+		trial       bool // a trial call is in progress in half-open state
+	}
+
+	breakerSet struct {
+		mu       sync.Mutex
+		breakers map[string]*circuitBreaker
+	}
+
+	// breakerCall is a call allowed by the circuit breaker, whose result is recorded
+	// once the call completes
+	breakerCall struct {
+		breaker *circuitBreaker
+		start   time.Time
+	}
+)
+
+// allow reports whether the call can be sent to the member
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// record records the result of the call allowed by the breaker
+func (b *circuitBreaker) record(now time.Time, elapsed time.Duration, err error) {
+	failed := err != nil || (b.slowCall > 0 && elapsed > b.slowCall)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		b.trial = false
+		if failed {
+			b.open(now)
+		} else {
+			b.state = breakerClosed
+			b.reset(now)
+		}
+	case breakerClosed:
+		if now.Sub(b.windowStart) > breakerWindow {
+			b.reset(now)
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= breakerMinCalls && float64(b.failures)/float64(b.calls) >= b.errorRate {
+			b.open(now)
+		}
+	}
+}
+
+// release gives up the trial call in half-open state without recording its result,
+// so the next call will be allowed as the trial
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+}
+
+func (b *circuitBreaker) reset(now time.Time) {
+	b.windowStart = now
+	b.calls = 0
+	b.failures = 0
+}
+
+// rejects reports whether the breaker rejects the calls at the moment, the member is
+// available again once the breaker can turn half-open
+func (b *circuitBreaker) rejects(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return now.Sub(b.openedAt) < b.openTimeout
+	case breakerHalfOpen:
+		return b.trial
+	}
+	return false
+}
+
+// breaker returns the circuit breaker of member addr, nil will be returned if the
+// circuit breakers are disabled
+func (n *Node) breaker(addr string) *circuitBreaker {
+	if n.BreakerErrorRate <= 0 {
+		return nil
+	}
+	n.breakers.mu.Lock()
+	defer n.breakers.mu.Unlock()
+	b, found := n.breakers.breakers[addr]
+	if !found {
+		if n.breakers.breakers == nil {
+			n.breakers.breakers = map[string]*circuitBreaker{}
+		}
+		b = &circuitBreaker{
+			errorRate:   n.BreakerErrorRate,
+			slowCall:    n.BreakerSlowCall,
+			openTimeout: n.BreakerOpenTimeout,
+			windowStart: time.Now(),
+		}
+		if b.openTimeout <= 0 {
+			b.openTimeout = defaultBreakerOpenTimeout
+		}
+		n.breakers.breakers[addr] = b
+	}
+	return b
+}
+
+// forgetBreaker removes the circuit breaker of the member left cluster
+func (n *Node) forgetBreaker(addr string) {
+	n.breakers.mu.Lock()
+	delete(n.breakers.breakers, addr)
+	n.breakers.mu.Unlock()
+}
+
+// guard sends the call to member addr through the circuit breaker of the member,
+// ErrCircuitOpen will be returned without calling if the breaker rejects the call
+func (n *Node) guard(addr string, call func() error) error {
+	c, err := n.admit(addr)
+	if err != nil {
+		return err
+	}
+	err = call()
+	c.done(err)
+	return err
+}
+
+// admit starts a call to member addr through the circuit breaker of the member, the
+// result should be recorded by done of the returned call, which is nil if the circuit
+// breakers are disabled. ErrCircuitOpen will be returned if the breaker rejects the call.
+func (n *Node) admit(addr string) (*breakerCall, error) {
+	b := n.breaker(addr)
+	if b == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	if !b.allow(now) {
+		return nil, ErrCircuitOpen
+	}
+	return &breakerCall{breaker: b, start: now}, nil
+}
+
+// done records the result of the call
+func (c *breakerCall) done(err error) {
+	if c == nil {
+		return
+	}
+	c.breaker.record(time.Now(), time.Since(c.start), err)
+}
+
+// cancel gives up the call without recording its result
+func (c *breakerCall) cancel() {
+	if c == nil {
+		return
+	}
+	c.breaker.release()
+}
+
+// circuitOpen reports whether the circuit breaker of member addr rejects the calls
+func (n *Node) circuitOpen(addr string) bool {
+	if n.BreakerErrorRate <= 0 {
+		return false
+	}
+	n.breakers.mu.Lock()
+	b := n.breakers.breakers[addr]
+	n.breakers.mu.Unlock()
+	return b != nil && b.rejects(time.Now())
+}
+
+// CircuitBreakers returns the state of the circuit breakers of the members which
+// current node has called, the state is one of closed, open and half-open
+func (n *Node) CircuitBreakers() map[string]string {
+	n.breakers.mu.Lock()
+	defer n.breakers.mu.Unlock()
+	states := make(map[string]string, len(n.breakers.breakers))
+	for addr, b := range n.breakers.breakers {
+		b.mu.Lock()
+		states[addr] = breakerStates[b.state]
+		b.mu.Unlock()
+	}
+	return states
+}
+
+// availableMembers filters out the members whose circuit breakers are open, all members
+// are returned if the breakers of all members are open
+func (n *Node) availableMembers(members []*clusterpb.MemberInfo) []*clusterpb.MemberInfo {
+	if n.BreakerErrorRate <= 0 {
+		return members
+	}
+	available := make([]*clusterpb.MemberInfo, 0, len(members))
+	for _, m := range members {
+		if !n.circuitOpen(m.ServiceAddr) {
+			available = append(available, m)
+		}
+	}
+	if len(available) == 0 {
+		return members
+	}
+	return available
+}
+
+// reroute rebinds the service of session bound to the member whose circuit breaker is
+// open to an alternative member, the pinned services are never rerouted. It returns
+// the original address if no alternative member is available.
+func (h *LocalHandler) reroute(service string, s *session.Session, addr string, members []*clusterpb.MemberInfo) string {
+	n := h.currentNode
+	if !n.circuitOpen(addr) || slices.Contains(n.PinnedServices, service) {
+		return addr
+	}
+	var alternatives []*clusterpb.MemberInfo
+	for _, m := range n.availableMembers(n.cluster.aliveMembers(routableMembers(members))) {
+		if m.ServiceAddr != addr && !n.circuitOpen(m.ServiceAddr) {
+			alternatives = append(alternatives, m)
+		}
+	}
+	if len(alternatives) == 0 {
+		return addr
+	}
+	member := h.routeStrategy(service)(service, s, alternatives)
+	if member == nil {
+		return addr
+	}
+
+	s.Router().Bind(service, member.ServiceAddr)
+	logger.Logger.Tracef(fmt.Sprintf("Reroute session[%d] service[%s] from [%s] to [%s] for open circuit",
+		s.ID(), service, addr, member.ServiceAddr))
+	if handler := n.RebindHandlers[service]; handler != nil {
+		newAddr := member.ServiceAddr
+		scheduler.PushTask(func() { handler(s, addr, newAddr) })
+	}
+	return member.ServiceAddr
+}
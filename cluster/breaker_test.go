@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/mock"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := &circuitBreaker{errorRate: 0.5, slowCall: 100 * time.Millisecond, openTimeout: time.Second, windowStart: now}
+	failure := errors.New("failure")
+
+	// the breaker opens once the error rate exceeds the threshold
+	for i := 0; i < breakerMinCalls-1; i++ {
+		if !b.allow(now) {
+			t.Fatalf("expect call %d allowed", i)
+		}
+		if i%2 == 0 {
+			b.record(now, time.Millisecond, nil)
+		} else {
+			b.record(now, time.Millisecond, failure)
+		}
+	}
+	if b.rejects(now) {
+		t.Fatal("expect breaker closed before min calls")
+	}
+	b.record(now, time.Second, nil) // slow call
+	if !b.rejects(now) || b.allow(now) {
+		t.Fatal("expect breaker open")
+	}
+
+	// the breaker allows a single trial after open timeout
+	now = now.Add(time.Second)
+	if b.rejects(now) || !b.allow(now) {
+		t.Fatal("expect trial allowed")
+	}
+	if b.allow(now) {
+		t.Fatal("expect only one trial allowed")
+	}
+	b.record(now, time.Millisecond, failure)
+	if b.allow(now) {
+		t.Fatal("expect breaker opened again after failed trial")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow(now) {
+		t.Fatal("expect trial allowed")
+	}
+	b.record(now, time.Millisecond, nil)
+	if b.rejects(now) || !b.allow(now) || !b.allow(now) {
+		t.Fatal("expect breaker closed after successful trial")
+	}
+
+	// the calls are counted in window
+	b.record(now, time.Millisecond, failure)
+	now = now.Add(breakerWindow + time.Second)
+	for i := 0; i < breakerMinCalls; i++ {
+		b.record(now, time.Millisecond, nil)
+	}
+	if b.calls != breakerMinCalls || b.failures != 0 {
+		t.Fatalf("expect counts reset in new window, got %d calls %d failures", b.calls, b.failures)
+	}
+}
+
+func TestCircuitBreakerRequests(t *testing.T) {
+	b := &circuitBreaker{errorRate: 0.5, openTimeout: time.Millisecond, windowStart: time.Now()}
+	requests := &pendingRequests{}
+	send := func(mid uint64) {
+		t.Helper()
+		requests.track(mid, time.Hour, func() {})
+		if !b.allow(time.Now()) {
+			t.Fatalf("expect request %d allowed", mid)
+		}
+		if !requests.observe(mid, &breakerCall{breaker: b, start: time.Now()}) {
+			t.Fatalf("expect request %d tracked", mid)
+		}
+	}
+
+	// the requests queued are recorded once they timeout instead of once sent
+	for mid := uint64(1); mid <= breakerMinCalls; mid++ {
+		send(mid)
+	}
+	if b.rejects(time.Now()) {
+		t.Fatal("expect breaker closed before the requests completed")
+	}
+	for mid := uint64(1); mid <= breakerMinCalls; mid++ {
+		requests.expire(mid)
+		if requests.finish(mid, nil) {
+			t.Fatalf("expect late response of request %d dropped", mid)
+		}
+	}
+	if !b.rejects(time.Now()) {
+		t.Fatal("expect breaker open after the requests timeout")
+	}
+
+	// the trial of closed session is released, and the responded trial closes the breaker
+	waitFor(t, func() bool { return !b.rejects(time.Now()) })
+	send(100)
+	requests.clear()
+	send(101)
+	requests.finish(101, nil)
+	if states := breakerStates[b.state]; states != "closed" {
+		t.Fatalf("expect breaker closed after responded trial, got: %s", states)
+	}
+	if requests.observe(102, &breakerCall{breaker: b, start: time.Now()}) {
+		t.Fatal("expect untracked request not observed")
+	}
+}
+
+func TestCircuitBreakerReroute(t *testing.T) {
+	go scheduler.Sched()
+
+	gate := &Node{
+		Options: Options{
+			IsMaster:           true,
+			Components:         &component.Components{},
+			BreakerErrorRate:   0.5,
+			BreakerOpenTimeout: time.Minute,
+			PinnedServices:     []string{"PinnedComponent"},
+			Listener:           memListen,
+			Dialer:             memDial,
+		},
+		ServiceAddr: "breaker.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	var addrs []string
+	for _, addr := range []string{"breaker.account1", "breaker.account2"} {
+		comps := &component.Components{}
+		comps.Register(&AccountComponent{})
+		member := &Node{
+			Options:     Options{AdvertiseAddr: gate.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := member.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		defer member.Shutdown()
+		addrs = append(addrs, addr)
+	}
+	members := gate.handler.findMembers("AccountComponent")
+	if len(members) != 2 {
+		t.Fatalf("expect 2 members, got: %v", members)
+	}
+
+	s := session.New(mock.NewNetworkEntity())
+	gate.storeSession(s)
+	s.Router().Bind("AccountComponent", addrs[0])
+	s.Router().Bind("PinnedComponent", addrs[0])
+	pong := &testdata.Pong{}
+	if err := gate.handler.call(context.Background(), s, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if states := gate.CircuitBreakers(); states[addrs[0]] != "closed" {
+		t.Fatalf("expect breaker closed, got: %v", states)
+	}
+
+	// trip the breaker of the bound member
+	b := gate.breaker(addrs[0])
+	for i := 0; i < breakerMinCalls; i++ {
+		b.record(time.Now(), time.Millisecond, errors.New("failure"))
+	}
+	if states := gate.CircuitBreakers(); states[addrs[0]] != "open" {
+		t.Fatalf("expect breaker open, got: %v", states)
+	}
+
+	// the session is rerouted unless the service is pinned
+	if addr, _ := gate.handler.remoteAddr("AccountComponent", s, members); addr != addrs[1] {
+		t.Fatalf("expect rerouted to %s, got: %s", addrs[1], addr)
+	}
+	if addr, _ := s.Router().Find("AccountComponent"); addr != addrs[1] {
+		t.Fatalf("expect router rebound to %s, got: %s", addrs[1], addr)
+	}
+	if err := gate.handler.call(context.Background(), s, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := gate.handler.remoteAddr("PinnedComponent", s, members); addr != addrs[0] {
+		t.Fatalf("expect pinned service kept on %s, got: %s", addrs[0], addr)
+	}
+	if err := gate.handler.callMember(context.Background(), addrs[0], nil, pong); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect call rejected by open circuit, got: %v", err)
+	}
+
+	// the new sessions skip the member with open circuit
+	for i := 0; i < 10; i++ {
+		other := session.New(mock.NewNetworkEntity())
+		if addr, _ := gate.handler.remoteAddr("AccountComponent", other, members); addr != addrs[1] {
+			t.Fatalf("expect new session routed to %s, got: %s", addrs[1], addr)
+		}
+	}
+}
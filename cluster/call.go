@@ -0,0 +1,260 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+	"google.golang.org/grpc/status"
+)
+
+// defaultNode is the node started most recently in current process
+var defaultNode atomic.Pointer[Node]
+
+// Call sends request to the service of route without a session and waits for the reply,
+// the request is sent by the node started most recently in current process.
+// See Node.Call for more details.
+func Call(ctx context.Context, route string, v interface{}, reply interface{}) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.Call(ctx, route, v, reply)
+}
+
+// Call sends request to the service of route without a session and waits for the reply.
+// The handler of route will receive a nil session, the reply should be returned as the
+// first result of handler, and reply will be left untouched if the handler returns error
+// only. The handler registered in current node will be dispatched to its scheduler like
+// the requests from members, or invoked directly if ctx is the context received by a
+// handler running in the same scheduler, otherwise the request will be sent to a member
+// provides the service randomly.
+func (n *Node) Call(ctx context.Context, route string, v interface{}, reply interface{}) error {
+	return n.handler.call(ctx, nil, route, v, reply)
+}
+
+// HandleCall implements the MemberServer interface
+func (n *Node) HandleCall(ctx context.Context, req *clusterpb.CallRequest) (*clusterpb.CallResponse, error) {
+	handler, found := n.handler.localHandler(req.Route)
+	if !found {
+		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
+	}
+	var s *session.Session
+	if req.GateAddr != "" {
+		// The service of session has been migrated to another member
+		if target, ok := n.migratedTarget(ctx, req.SessionId, req.Route); ok {
+			client, err := n.memberClient(target)
+			if err != nil {
+				return nil, err
+			}
+			return client.HandleCall(ctx, req)
+		}
+		var err error
+		s, err = n.findOrCreateSession(req.SessionId, req.GateAddr)
+		if err != nil {
+			return nil, err
+		}
+		n.applySessionData(s, req.Session)
+	}
+
+	ctx = session.WithMetadata(ctx, req.Metadata)
+	data, handleErr, err := n.handler.dispatch(ctx, req.Route, handler, s, req.Data)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, err
+	}
+	resp := &clusterpb.CallResponse{Data: data}
+	if handleErr != nil {
+		resp.Error = handleErr.Error()
+	}
+	return resp, nil
+}
+
+// dispatch invokes the handler of route by the scheduler of route and waits for the
+// reply, the error returned by the handler is returned as handleErr. The handler is
+// invoked in current goroutine if ctx is the context of a task running in the same
+// scheduler, which cannot run the handler while the task is waiting for it.
+func (h *LocalHandler) dispatch(ctx context.Context, route string, handler *component.Handler, s *session.Session, payload []byte) (data []byte, handleErr error, err error) {
+	sched, err := h.localScheduler(route, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if scheduler.Scheduling(ctx, sched) {
+		data, handleErr = h.invoke(ctx, handler, s, payload)
+		return data, handleErr, nil
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	task := func(ctx context.Context) {
+		data, err := h.invoke(ctx, handler, s, payload)
+		done <- result{data: data, err: err}
+	}
+	if err := h.scheduleHandler(ctx, route, s, task); err != nil {
+		return nil, nil, err
+	}
+
+	select {
+	case r := <-done:
+		return r.data, r.err, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (h *LocalHandler) call(ctx context.Context, session *session.Session, route string, v interface{}, reply interface{}) error {
+	index := strings.LastIndex(route, ".")
+	if index < 0 {
+		return fmt.Errorf("nano/handler: invalid route %s", route)
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	if handler, found := h.localHandler(route); found {
+		data, handleErr, err := h.dispatch(ctx, route, handler, session, data)
+		if err != nil {
+			return err
+		}
+		if handleErr != nil {
+			return handleErr
+		}
+		return decodeReply(data, reply)
+	}
+
+	service := route[:index]
+	members := h.findMembers(service)
+	if len(members) == 0 {
+		return fmt.Errorf("nano/handler: %s not found(forgot registered?)", route)
+	}
+
+	request := &clusterpb.CallRequest{Route: route, Data: data, Metadata: contextMetadata(ctx)}
+	var remoteAddr string
+	if session != nil {
+		addr, found := h.remoteAddr(service, session, members)
+		if !found {
+			return fmt.Errorf("customize remoteServiceRoute handler: %s is not found", route)
+		}
+		remoteAddr = addr
+		request.GateAddr, request.SessionId = h.sessionOrigin(session)
+		touchSession(session, remoteAddr)
+	} else {
+		member := defaultRoute(service, nil, h.currentNode.availableMembers(routableMembers(members)))
+		if member == nil {
+			return fmt.Errorf("nano/handler: %s has no available member", route)
+		}
+		remoteAddr = member.ServiceAddr
+	}
+
+	if session != nil {
+		request.Session = h.sessionData(session, remoteAddr)
+	}
+	err = h.callMember(ctx, remoteAddr, request, reply)
+	var remoteErr *RemoteError
+	if err != nil && request.Session != nil && !errors.As(err, &remoteErr) {
+		forgetSessionData(session, remoteAddr)
+	}
+	return err
+}
+
+// callMember sends the call request to the member of addr and decodes the reply
+func (h *LocalHandler) callMember(ctx context.Context, addr string, request *clusterpb.CallRequest, reply interface{}) error {
+	pool, err := h.currentNode.rpcClient.getConnPool(addr)
+	if err != nil {
+		return err
+	}
+	var resp *clusterpb.CallResponse
+	err = h.currentNode.guard(addr, func() error {
+		resp, err = clusterpb.NewMemberClient(pool.Get()).HandleCall(ctx, request)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return &RemoteError{Route: request.Route, Message: resp.Error}
+	}
+	return decodeReply(resp.Data, reply)
+}
+
+// invoke calls the handler with the serialized argument and returns the serialized reply,
+// the panic of handler is recovered and returned as error
+func (h *LocalHandler) invoke(ctx context.Context, handler *component.Handler, session *session.Session, payload []byte) (_ []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Logger.Tracef(fmt.Sprintf("Handle call panic: %+v\n%s", e, debug.Stack()))
+			err = fmt.Errorf("nano/handler: %s panic: %v", handler.Method.Name, e)
+		}
+	}()
+
+	data, err := decodeArg(handler, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := handler.Method.Func.Call(handlerArgs(handler, ctx, session, data))
+	if err := result[len(result)-1].Interface(); err != nil {
+		return nil, err.(error)
+	}
+	if !handler.HasReply || result[0].IsNil() {
+		return nil, nil
+	}
+	return message.Serialize(result[0].Interface())
+}
+
+// contextMetadata returns the metadata carried by ctx
+func contextMetadata(ctx context.Context) map[string]string {
+	return session.Metadata(ctx)
+}
+
+// decodeReply deserializes the reply data, the raw data will be assigned
+// to reply directly if reply is a *[]byte
+func decodeReply(data []byte, reply interface{}) error {
+	switch r := reply.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*r = data
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	return env.Serializer.Unmarshal(data, reply)
+}
@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	CallerComponent  struct{ component.Base }
+	AccountComponent struct {
+		component.Base
+		release chan struct{} // releases the slow calls
+	}
+)
+
+func (c *CallerComponent) Echo(_ *session.Session, ping *testdata.Ping) (*testdata.Pong, error) {
+	return &testdata.Pong{Content: "caller " + ping.Content}, nil
+}
+
+func (c *AccountComponent) Query(s *session.Session, ping *testdata.Ping) (*testdata.Pong, error) {
+	if s != nil {
+		return &testdata.Pong{Content: "session " + ping.Content}, nil
+	}
+	return &testdata.Pong{Content: "account " + ping.Content}, nil
+}
+
+func (c *AccountComponent) Fail(_ *session.Session, _ *testdata.Ping) error {
+	return errors.New("account failed")
+}
+
+func (c *AccountComponent) Panic(_ *session.Session, _ *testdata.Ping) (*testdata.Pong, error) {
+	panic("account panic")
+}
+
+func (c *AccountComponent) Slow(_ *session.Session, _ *testdata.Ping) (*testdata.Pong, error) {
+	<-c.release
+	return &testdata.Pong{}, nil
+}
+
+func TestCall(t *testing.T) {
+	go scheduler.Sched()
+
+	masterComps := &component.Components{}
+	masterComps.Register(&CallerComponent{})
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: masterComps, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "call.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	memberComps := &component.Components{}
+	account := &AccountComponent{release: make(chan struct{})}
+	memberComps.Register(account)
+	member := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: memberComps, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "call.member",
+	}
+	if err := member.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer member.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// local target
+	pong := &testdata.Pong{}
+	if err := master.Call(ctx, "CallerComponent.Echo", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "caller ping" {
+		t.Fatalf("unexpected local reply: %s", pong.Content)
+	}
+
+	// remote target without session
+	pong = &testdata.Pong{}
+	if err := master.Call(ctx, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "account ping" {
+		t.Fatalf("unexpected remote reply: %s", pong.Content)
+	}
+
+	// remote target with session
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	a := newAgent(conn, "", "", nil, master.handler.remoteProcess, master.handler.call)
+	pong = &testdata.Pong{}
+	if err := a.session.Call(ctx, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "session ping" {
+		t.Fatalf("unexpected session reply: %s", pong.Content)
+	}
+	if addr, _ := a.session.Router().Find("AccountComponent"); addr != member.ServiceAddr {
+		t.Fatalf("expect session bound to %s, got: %s", member.ServiceAddr, addr)
+	}
+
+	// remote handler error
+	err := master.Call(ctx, "AccountComponent.Fail", &testdata.Ping{}, nil)
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Message != "account failed" {
+		t.Fatalf("unexpected remote error: %v", err)
+	}
+
+	// handler panic is replied as error even if the caller has no deadline
+	err = master.Call(context.Background(), "AccountComponent.Panic", &testdata.Ping{}, &testdata.Pong{})
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("expect remote error, got: %v", err)
+	}
+	if err := member.Call(ctx, "AccountComponent.Panic", &testdata.Ping{}, &testdata.Pong{}); err == nil {
+		t.Fatal("expect local panic returned as error")
+	}
+
+	// local target called in scheduler runs inline instead of waiting for scheduler
+	done := make(chan error, 1)
+	scheduler.PushTask(func() {
+		ctx, finish := scheduler.WithScheduling(context.Background(), nil)
+		defer finish()
+		done <- master.Call(ctx, "CallerComponent.Echo", &testdata.Ping{Content: "ping"}, &testdata.Pong{})
+	})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("local call in scheduler blocked")
+	}
+
+	// deadline
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer shortCancel()
+	err = master.Call(shortCtx, "AccountComponent.Slow", &testdata.Ping{}, &testdata.Pong{})
+	close(account.release)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got: %v", err)
+	}
+}
+
+func TestNotifyNotResponded(t *testing.T) {
+	go scheduler.Sched()
+
+	comps := &component.Components{}
+	comps.Register(&CallerComponent{})
+	node := &Node{
+		Options:     Options{IsMaster: true, Components: comps, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "call.notify",
+	}
+	if err := node.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	a := newAgent(conn, "", "", nil, node.handler.remoteProcess, node.handler.call)
+	go a.write()
+	defer a.Close()
+
+	handler, _ := node.handler.localHandler("CallerComponent.Echo")
+	process := func(typ message.Type, content string) {
+		data, err := message.Serialize(&testdata.Ping{Content: content})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &message.Message{Type: typ, Route: "CallerComponent.Echo", Data: data}
+		node.handler.localProcess(handler, 0, a.session, msg)
+	}
+
+	// the handlers are run in order, so the first reply belongs to the request
+	process(message.Notify, "notify")
+	process(message.Request, "request")
+	buf := make([]byte, 1024)
+	peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := peer.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := message.Serialize(&testdata.Pong{Content: "caller request"})
+	if string(buf[:n]) != string(expect) {
+		t.Fatalf("expect reply of request, got: %q", buf[:n])
+	}
+}
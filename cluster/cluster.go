@@ -26,7 +26,11 @@ import (
 	"github.com/acoderup/core/logger"
 	"github.com/acoderup/nano/cluster/clusterpb"
 	"github.com/acoderup/nano/internal/env"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -40,10 +44,37 @@ type cluster struct {
 
 	mu      sync.RWMutex
 	members []*Member
+
+	directory *directory          // UID directory maintained by master
+	owners    map[string]string   // singleton service -> owner elected by master, protected by mu
+	epoch     atomic.Uint64       // membership epoch, increased by master on each change
+	version   uint64              // version of member information, increased by master on each change, protected by mu
+	observed  atomic.Uint64       // version of member information observed by member
+	master    atomic.Uint64       // incarnation of master observed by member
+	fenced    map[string][]uint64 // service address -> latest stale incarnations, protected by mu
+	save      chan struct{}       // signal of persisting the member table
+
+	startedAt   time.Time
+	leaseUntil  time.Time   // singleton owners are not elected before it, protected by mu
+	unconfirmed atomic.Bool // member view has not been synchronized since master restarted
+
+	retryMu sync.Mutex
+	retries map[string]*retryQueue // member address -> notifications waiting for retry
+
+	once sync.Once
+	die  chan struct{}
 }
 
 func newCluster(currentNode *Node) *cluster {
-	c := &cluster{currentNode: currentNode}
+	c := &cluster{
+		currentNode: currentNode,
+		directory:   newDirectory(),
+		fenced:      map[string][]uint64{},
+		retries:     map[string]*retryQueue{},
+		save:        make(chan struct{}, 1),
+		die:         make(chan struct{}),
+		startedAt:   time.Now(),
+	}
 	if currentNode.IsMaster {
 		c.checkMemberHeartbeat()
 	}
@@ -55,47 +86,72 @@ func (c *cluster) Register(_ context.Context, req *clusterpb.RegisterRequest) (*
 	if req.MemberInfo == nil {
 		return nil, ErrInvalidRegisterReq
 	}
+	if req.Cluster != c.currentNode.ClusterName {
+		logger.Logger.Tracef("Reject peer [%s] of cluster [%s]", req.MemberInfo.ServiceAddr, req.Cluster)
+		return nil, status.Error(codes.PermissionDenied, ErrClusterMismatch.Error())
+	}
 	resp := &clusterpb.RegisterResponse{}
+	var old *clusterpb.MemberInfo
+	var peers []string
+	member := &Member{isMaster: false, memberInfo: req.MemberInfo, lastHeartbeatAt: time.Now()}
 	c.mu.Lock()
-	for k, m := range c.members {
-		if m.memberInfo.ServiceAddr == req.MemberInfo.ServiceAddr {
-			// 节点异常崩溃，不会执行unregister，此时再次启动该节点，由于已存在注册信息，将再也无法成功注册，这里做个修改，先移除后重新注册
-			if k >= len(c.members)-1 {
-				c.members = c.members[:k]
-			} else {
-				c.members = append(c.members[:k], c.members[k+1:]...)
-			}
-			break
-			//return nil, fmt.Errorf("address %s has registered", req.MemberInfo.ServiceAddr)
-		}
+	if c.stale(req.MemberInfo.ServiceAddr, req.MemberInfo.Incarnation) {
+		c.mu.Unlock()
+		logger.Logger.Tracef("Reject stale incarnation [%d] of peer [%s]", req.MemberInfo.Incarnation, req.MemberInfo.ServiceAddr)
+		return nil, status.Error(codes.FailedPrecondition, ErrStaleIncarnation.Error())
 	}
-	c.mu.Unlock()
-
-	// Notify registered node to update remote services
-	newMember := &clusterpb.NewMemberRequest{MemberInfo: req.MemberInfo}
 	for _, m := range c.members {
-		resp.Members = append(resp.Members, m.memberInfo)
-		if m.isMaster {
+		if m.memberInfo.ServiceAddr == req.MemberInfo.ServiceAddr {
+			old = m.memberInfo
 			continue
 		}
-		pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
-		if err != nil {
-			return nil, err
+		resp.Members = append(resp.Members, m.memberInfo)
+		if !m.isMaster {
+			peers = append(peers, m.memberInfo.ServiceAddr)
 		}
-		client := clusterpb.NewMemberClient(pool.Get())
-		_, err = client.NewMember(context.Background(), newMember)
-		if err != nil {
-			return nil, err
+	}
+	// The crashed member registers again without unregistering, its previous
+	// information is replaced
+	if old != nil {
+		if old.Incarnation != req.MemberInfo.Incarnation {
+			c.fence(old.ServiceAddr, old.Incarnation)
 		}
+		for k, m := range c.members {
+			if m.memberInfo.ServiceAddr == req.MemberInfo.ServiceAddr {
+				c.members[k] = member
+				break
+			}
+		}
+	} else {
+		c.members = append(c.members, member)
 	}
+	resp.Epoch = c.epoch.Add(1)
+	resp.Owners = c.assignOwners()
+	resp.Master = c.currentNode.Incarnation()
+	// The events are emitted under the lock, so they are delivered in the same order
+	// as the membership changes
+	if old == nil {
+		c.currentNode.events.emit(MemberJoined{Member: req.MemberInfo})
+	} else if memberChanged(old, req.MemberInfo) {
+		c.currentNode.events.emit(MemberUpdated{Old: old, New: req.MemberInfo})
+	}
+	c.mu.Unlock()
 
 	logger.Logger.Tracef("New peer register to cluster[%v]", req.MemberInfo.ServiceAddr)
 
 	// Register services to current node
-	c.currentNode.handler.addRemoteService(req.MemberInfo)
-	c.mu.Lock()
-	c.members = append(c.members, &Member{isMaster: false, memberInfo: req.MemberInfo, lastHeartbeatAt: time.Now()})
-	c.mu.Unlock()
+	if old == nil {
+		c.currentNode.handler.addRemoteService(req.MemberInfo)
+	} else {
+		c.currentNode.handler.replaceMember(old, req.MemberInfo)
+	}
+	c.persist()
+	c.currentNode.applyOwners(resp.Owners)
+
+	// Notify registered nodes to update remote services, the unreachable members
+	// will be notified by retries or catch up by the membership epoch
+	c.dropRetries(req.MemberInfo.ServiceAddr)
+	c.broadcast(peers, newMemberNotification(&clusterpb.NewMemberRequest{MemberInfo: req.MemberInfo, Epoch: resp.Epoch}))
 	return resp, nil
 }
 
@@ -105,68 +161,108 @@ func (c *cluster) Unregister(_ context.Context, req *clusterpb.UnregisterRequest
 		return nil, ErrInvalidRegisterReq
 	}
 
-	var index = -1
 	resp := &clusterpb.UnregisterResponse{}
+	var unregistered *Member
+	var peers []string
+	c.mu.Lock()
 	for i, m := range c.members {
-		if m.memberInfo.ServiceAddr == req.ServiceAddr {
-			index = i
-			break
+		if m.memberInfo.ServiceAddr != req.ServiceAddr {
+			continue
 		}
+		// The stale incarnation cannot unregister the current incarnation
+		if req.Incarnation != 0 && req.Incarnation != m.memberInfo.Incarnation {
+			c.mu.Unlock()
+			return nil, status.Error(codes.FailedPrecondition, ErrStaleIncarnation.Error())
+		}
+		unregistered = m
+		c.members = append(c.members[:i], c.members[i+1:]...)
+		break
 	}
-	if index < 0 {
+	if unregistered == nil {
+		c.mu.Unlock()
 		return nil, fmt.Errorf("address %s has not registered", req.ServiceAddr)
 	}
-
-	// Notify registered node to update remote services
-	delMember := &clusterpb.DelMemberRequest{ServiceAddr: req.ServiceAddr}
-	for i, m := range c.members {
-		if i == index {
-			// this node is down.
-			continue
-		}
-
-		if m.MemberInfo().ServiceAddr == c.currentNode.ServiceAddr {
-			continue
-		}
-		pool, err := c.rpcClient.getConnPool(m.memberInfo.ServiceAddr)
-		if err != nil {
-			return nil, err
-		}
-		client := clusterpb.NewMemberClient(pool.Get())
-		_, err = client.DelMember(context.Background(), delMember)
-		if err != nil {
-			return nil, err
+	for _, m := range c.members {
+		if !m.isMaster {
+			peers = append(peers, m.memberInfo.ServiceAddr)
 		}
 	}
+	epoch := c.epoch.Add(1)
+	c.currentNode.events.emit(MemberLeft{Member: unregistered.memberInfo})
+	c.mu.Unlock()
 
 	logger.Logger.Tracef("Exists peer unregister to cluster[%v]", req.ServiceAddr)
 
 	if c.currentNode.UnregisterCallback != nil {
-		c.currentNode.UnregisterCallback(*c.members[index])
+		c.currentNode.UnregisterCallback(*unregistered)
 	}
 
-	// Register services to current node
+	// Unregister services from current node
 	c.currentNode.handler.delMember(req.ServiceAddr)
-	c.mu.Lock()
-	if index >= len(c.members)-1 {
-		c.members = c.members[:index]
-	} else {
-		c.members = append(c.members[:index], c.members[index+1:]...)
-	}
-	c.mu.Unlock()
+	c.directory.removeGate(req.ServiceAddr)
+	c.currentNode.transport.closeStream(req.ServiceAddr)
+	c.currentNode.rpcClient.closeConnPool(req.ServiceAddr)
+	c.currentNode.rebindSessions(req.ServiceAddr)
+	leaveGroupsOfGate(req.ServiceAddr)
+	c.reassignOwners()
+	c.persist()
 
+	// Notify registered nodes to update remote services, the unreachable members
+	// will be notified by retries or catch up by the membership epoch
+	c.dropRetries(req.ServiceAddr)
+	c.broadcast(peers, delMemberNotification(&clusterpb.DelMemberRequest{
+		ServiceAddr: req.ServiceAddr,
+		Epoch:       epoch,
+		Incarnation: unregistered.memberInfo.Incarnation,
+	}))
 	return resp, nil
 }
 
 func (c *cluster) Heartbeat(_ context.Context, req *clusterpb.HeartbeatRequest) (*clusterpb.HeartbeatResponse, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	// The delayed heartbeat of a crashed process cannot resurrect it, and the process
+	// restarted registers before sending heartbeat, so the other incarnations are stale
+	addr, incarnation := req.MemberInfo.ServiceAddr, req.MemberInfo.Incarnation
+	if c.stale(addr, incarnation) || c.superseded(addr, incarnation) {
+		c.fence(addr, incarnation)
+		c.mu.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, ErrStaleIncarnation.Error())
+	}
 
 	isHit := false
+	var changed bool
+	var peers []string
 	for i, m := range c.members {
 		if m.MemberInfo().GetServiceAddr() == req.GetMemberInfo().GetServiceAddr() {
 			c.members[i].lastHeartbeatAt = time.Now()
+			if c.members[i].heartbeats == nil {
+				c.members[i].heartbeats = &heartbeatHistory{}
+			}
+			c.members[i].heartbeats.record(c.members[i].lastHeartbeatAt)
+			if c.members[i].suspect {
+				c.members[i].suspect = false
+				logger.Logger.Tracef("Suspect member confirmed [%s]", req.MemberInfo.ServiceAddr)
+			}
+			// The draining flag marked by master is kept until the member registers again
+			if c.members[i].memberInfo.Draining && !req.MemberInfo.Draining {
+				req.MemberInfo.Draining = true
+			}
+			old := c.members[i].memberInfo
+			if old.Incarnation != req.MemberInfo.Incarnation {
+				c.fence(old.ServiceAddr, old.Incarnation)
+			}
+			if memberChanged(old, req.MemberInfo) {
+				c.currentNode.events.emit(MemberUpdated{Old: old, New: req.MemberInfo})
+				changed = true
+			}
+			// The unchanged member information is kept, so it is not responded to peers again
+			if !proto.Equal(old, req.MemberInfo) {
+				c.members[i].memberInfo = req.MemberInfo
+				c.currentNode.handler.replaceMember(old, req.MemberInfo)
+			}
 			isHit = true
+		} else if !m.isMaster {
+			peers = append(peers, m.memberInfo.ServiceAddr)
 		}
 	}
 	if !isHit {
@@ -179,44 +275,109 @@ func (c *cluster) Heartbeat(_ context.Context, req *clusterpb.HeartbeatRequest)
 		}
 		c.members = append(c.members, m)
 		c.currentNode.handler.addRemoteService(req.MemberInfo)
+		c.currentNode.events.emit(MemberJoined{Member: req.MemberInfo})
 		logger.Logger.Tracef("Heartbeat peer register to cluster[%v]", req.MemberInfo.ServiceAddr)
+		c.holdOwners()
+	}
+	if changed || !isHit {
+		c.epoch.Add(1)
+	}
+
+	// Respond the member information changed since the version observed by the member,
+	// so the members can refresh the load of peers, the member observing a different
+	// epoch receives all members to synchronize its member view
+	for _, m := range c.members {
+		if m.isMaster {
+			if info := c.currentNode.memberInfo(); !proto.Equal(info, m.memberInfo) {
+				m.memberInfo = info
+			}
+		}
+		if m.memberInfo != m.versioned {
+			c.version++
+			m.version, m.versioned = c.version, m.memberInfo
+		}
 	}
-	return &clusterpb.HeartbeatResponse{}, nil
+	full := req.Epoch != c.epoch.Load() || req.Version == 0 || req.Version > c.version
+	resp := &clusterpb.HeartbeatResponse{Version: c.version, Full: full}
+	for _, m := range c.members {
+		if full || m.version > req.Version {
+			resp.Members = append(resp.Members, m.memberInfo)
+		}
+	}
+	resp.Owners = c.assignOwners()
+	resp.Epoch = c.epoch.Load()
+	resp.Master = c.currentNode.Incarnation()
+	resp.Confirmed = c.confirmed()
+	c.mu.Unlock()
+	c.currentNode.applyOwners(resp.Owners)
+	if changed || !isHit {
+		c.persist()
+	}
+
+	// Propagate the changes immediately instead of waiting for the heartbeat of peers
+	if changed {
+		c.notifyMember(req.MemberInfo, peers)
+	}
+	return resp, nil
+}
+
+// notifyMember notifies the members of addrs that the information of member has been
+// changed, the failures will be retried in background
+func (c *cluster) notifyMember(info *clusterpb.MemberInfo, addrs []string) {
+	c.broadcast(addrs, newMemberNotification(&clusterpb.NewMemberRequest{MemberInfo: info, Epoch: c.epoch.Load()}))
 }
 
 func (c *cluster) checkMemberHeartbeat() {
+	interval := env.Heartbeat
+	suspectTimeout, deadTimeout := c.currentNode.suspectTimeout(), c.currentNode.deadTimeout()
 	check := func() {
-		unregisterMembers := make([]*Member, 0)
-		// check heartbeat time
+		var dead []*clusterpb.MemberInfo
+		now := time.Now()
+		c.mu.Lock()
 		for _, m := range c.members {
-			if time.Now().Sub(m.lastHeartbeatAt) > 4*env.Heartbeat && !m.isMaster {
-				unregisterMembers = append(unregisterMembers, m)
+			if m.isMaster {
+				continue
+			}
+			if now.Sub(m.lastHeartbeatAt) > deadTimeout {
+				dead = append(dead, m.memberInfo)
+			} else if !m.suspect && c.suspicious(m, now, suspectTimeout) {
+				m.suspect = true
+				logger.Logger.Tracef("Member [%s] suspected: missed heartbeat", m.memberInfo.ServiceAddr)
 			}
 		}
+		c.mu.Unlock()
 
-		for _, m := range unregisterMembers {
+		for _, info := range dead {
 			if _, err := c.Unregister(context.Background(), &clusterpb.UnregisterRequest{
-				ServiceAddr: m.MemberInfo().ServiceAddr,
+				ServiceAddr: info.ServiceAddr,
+				Incarnation: info.Incarnation,
 			}); err != nil {
 				logger.Logger.Tracef("Heartbeat unregister error[%v]", err)
 			}
 		}
 	}
+	ticker := time.NewTicker(interval)
 	go func() {
-		ticker := time.NewTicker(env.Heartbeat)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				if !c.currentNode.IsMaster {
-					ticker.Stop()
 					return
 				}
 				check()
+			case <-c.die:
+				return
 			}
 		}
 	}()
 }
 
+// close stops checking the heartbeat of members
+func (c *cluster) close() {
+	c.once.Do(func() { close(c.die) })
+}
+
 func (c *cluster) setRpcClient(client *rpcClient) {
 	c.rpcClient = client
 }
@@ -231,32 +392,80 @@ func (c *cluster) remoteAddrs() []string {
 	return addrs
 }
 
+// memberInfos returns the information of all known members
+func (c *cluster) memberInfos() []*clusterpb.MemberInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	infos := make([]*clusterpb.MemberInfo, 0, len(c.members))
+	for _, m := range c.members {
+		infos = append(infos, m.memberInfo)
+	}
+	return infos
+}
+
 func (c *cluster) initMembers(members []*clusterpb.MemberInfo) {
 	c.mu.Lock()
 	for _, info := range members {
 		c.members = append(c.members, &Member{
 			memberInfo: info,
 		})
+		c.currentNode.events.emit(MemberJoined{Member: info})
 	}
 	c.mu.Unlock()
 }
 
-func (c *cluster) addMember(info *clusterpb.MemberInfo) {
+// addMember adds the member or replaces the information of the known member, and
+// returns the previous information of the known member. The member of stale
+// incarnation will be ignored and false will be returned.
+func (c *cluster) addMember(info *clusterpb.MemberInfo) (*clusterpb.MemberInfo, bool) {
 	c.mu.Lock()
-	var found bool
+	defer c.mu.Unlock()
+	if c.stale(info.ServiceAddr, info.Incarnation) {
+		return nil, false
+	}
 	for _, member := range c.members {
 		if member.memberInfo.ServiceAddr == info.ServiceAddr {
+			old := member.memberInfo
+			if old.Incarnation != info.Incarnation {
+				c.fence(old.ServiceAddr, old.Incarnation)
+				member.suspect = false
+			}
+			if memberChanged(old, info) {
+				c.currentNode.events.emit(MemberUpdated{Old: old, New: info})
+			}
 			member.memberInfo = info
-			found = true
-			break
+			return old, true
 		}
 	}
-	if !found {
-		c.members = append(c.members, &Member{
-			memberInfo: info,
-		})
+	c.members = append(c.members, &Member{
+		memberInfo: info,
+	})
+	c.currentNode.events.emit(MemberJoined{Member: info})
+	return nil, true
+}
+
+// refreshMembers replaces the information of known members with the latest information
+// responded by master, unknown members will be ignored and added by NewMember
+func (c *cluster) refreshMembers(members []*clusterpb.MemberInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, info := range members {
+		// The member view responded before the member restarted is ignored
+		if info.ServiceAddr == c.currentNode.ServiceAddr || c.stale(info.ServiceAddr, info.Incarnation) {
+			continue
+		}
+		for _, member := range c.members {
+			if member.memberInfo.ServiceAddr == info.ServiceAddr {
+				if memberChanged(member.memberInfo, info) {
+					c.currentNode.events.emit(MemberUpdated{Old: member.memberInfo, New: info})
+				}
+				c.currentNode.handler.replaceMember(member.memberInfo, info)
+				member.memberInfo = info
+				break
+			}
+		}
 	}
-	c.mu.Unlock()
 }
 
 func (c *cluster) delMember(addr string) {
@@ -269,6 +478,7 @@ func (c *cluster) delMember(addr string) {
 		}
 	}
 	if index != -1 {
+		c.currentNode.events.emit(MemberLeft{Member: c.members[index].memberInfo})
 		if index >= len(c.members)-1 {
 			c.members = c.members[:index]
 		} else {
@@ -25,6 +25,10 @@ type MasterClient interface {
 	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
 	Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*UnregisterResponse, error)
 	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	BindUID(ctx context.Context, in *BindUIDRequest, opts ...grpc.CallOption) (*BindUIDResponse, error)
+	UnbindSession(ctx context.Context, in *UnbindSessionRequest, opts ...grpc.CallOption) (*UnbindSessionResponse, error)
+	LookupUID(ctx context.Context, in *LookupUIDRequest, opts ...grpc.CallOption) (*LookupUIDResponse, error)
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
 }
 
 type masterClient struct {
@@ -62,6 +66,42 @@ func (c *masterClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts
 	return out, nil
 }
 
+func (c *masterClient) BindUID(ctx context.Context, in *BindUIDRequest, opts ...grpc.CallOption) (*BindUIDResponse, error) {
+	out := new(BindUIDResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Master/BindUID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) UnbindSession(ctx context.Context, in *UnbindSessionRequest, opts ...grpc.CallOption) (*UnbindSessionResponse, error) {
+	out := new(UnbindSessionResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Master/UnbindSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) LookupUID(ctx context.Context, in *LookupUIDRequest, opts ...grpc.CallOption) (*LookupUIDResponse, error) {
+	out := new(LookupUIDResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Master/LookupUID", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	out := new(DrainResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Master/Drain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MasterServer is the server API for Master service.
 // All implementations should embed UnimplementedMasterServer
 // for forward compatibility
@@ -69,6 +109,10 @@ type MasterServer interface {
 	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
 	Unregister(context.Context, *UnregisterRequest) (*UnregisterResponse, error)
 	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	BindUID(context.Context, *BindUIDRequest) (*BindUIDResponse, error)
+	UnbindSession(context.Context, *UnbindSessionRequest) (*UnbindSessionResponse, error)
+	LookupUID(context.Context, *LookupUIDRequest) (*LookupUIDResponse, error)
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
 }
 
 // UnimplementedMasterServer should be embedded to have forward compatible implementations.
@@ -84,6 +128,18 @@ func (UnimplementedMasterServer) Unregister(context.Context, *UnregisterRequest)
 func (UnimplementedMasterServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
 }
+func (UnimplementedMasterServer) BindUID(context.Context, *BindUIDRequest) (*BindUIDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BindUID not implemented")
+}
+func (UnimplementedMasterServer) UnbindSession(context.Context, *UnbindSessionRequest) (*UnbindSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnbindSession not implemented")
+}
+func (UnimplementedMasterServer) LookupUID(context.Context, *LookupUIDRequest) (*LookupUIDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupUID not implemented")
+}
+func (UnimplementedMasterServer) Drain(context.Context, *DrainRequest) (*DrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
 
 // UnsafeMasterServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to MasterServer will
@@ -150,6 +206,78 @@ func _Master_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Master_BindUID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BindUIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).BindUID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/BindUID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).BindUID(ctx, req.(*BindUIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_UnbindSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnbindSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).UnbindSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/UnbindSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).UnbindSession(ctx, req.(*UnbindSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_LookupUID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupUIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).LookupUID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/LookupUID",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).LookupUID(ctx, req.(*LookupUIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Master/Drain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Master_ServiceDesc is the grpc.ServiceDesc for Master service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -169,6 +297,22 @@ var Master_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Heartbeat",
 			Handler:    _Master_Heartbeat_Handler,
 		},
+		{
+			MethodName: "BindUID",
+			Handler:    _Master_BindUID_Handler,
+		},
+		{
+			MethodName: "UnbindSession",
+			Handler:    _Master_UnbindSession_Handler,
+		},
+		{
+			MethodName: "LookupUID",
+			Handler:    _Master_LookupUID_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _Master_Drain_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "cluster.proto",
@@ -182,10 +326,21 @@ type MemberClient interface {
 	HandleNotify(ctx context.Context, in *NotifyMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
 	HandlePush(ctx context.Context, in *PushMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
 	HandleResponse(ctx context.Context, in *ResponseMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	HandleCall(ctx context.Context, in *CallRequest, opts ...grpc.CallOption) (*CallResponse, error)
+	HandleSessionSync(ctx context.Context, in *SessionSyncMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	HandleGroupPush(ctx context.Context, in *GroupPushMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	HandleSystem(ctx context.Context, in *SystemMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	HandlePublish(ctx context.Context, in *PublishMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	Replay(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (*ReplayResponse, error)
+	Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeResponse, error)
+	Stream(ctx context.Context, opts ...grpc.CallOption) (Member_StreamClient, error)
 	NewMember(ctx context.Context, in *NewMemberRequest, opts ...grpc.CallOption) (*NewMemberResponse, error)
 	DelMember(ctx context.Context, in *DelMemberRequest, opts ...grpc.CallOption) (*DelMemberResponse, error)
 	SessionClosed(ctx context.Context, in *SessionClosedRequest, opts ...grpc.CallOption) (*SessionClosedResponse, error)
 	CloseSession(ctx context.Context, in *CloseSessionRequest, opts ...grpc.CallOption) (*CloseSessionResponse, error)
+	HoldSession(ctx context.Context, in *HoldSessionRequest, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	ReleaseSession(ctx context.Context, in *ReleaseSessionRequest, opts ...grpc.CallOption) (*MemberHandleResponse, error)
+	RestoreSession(ctx context.Context, in *SessionState, opts ...grpc.CallOption) (*MemberHandleResponse, error)
 }
 
 type memberClient struct {
@@ -232,6 +387,100 @@ func (c *memberClient) HandleResponse(ctx context.Context, in *ResponseMessage,
 	return out, nil
 }
 
+func (c *memberClient) HandleCall(ctx context.Context, in *CallRequest, opts ...grpc.CallOption) (*CallResponse, error) {
+	out := new(CallResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HandleCall", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) HandleSessionSync(ctx context.Context, in *SessionSyncMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HandleSessionSync", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) HandleGroupPush(ctx context.Context, in *GroupPushMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HandleGroupPush", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) HandleSystem(ctx context.Context, in *SystemMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HandleSystem", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) HandlePublish(ctx context.Context, in *PublishMessage, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HandlePublish", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) Replay(ctx context.Context, in *ReplayRequest, opts ...grpc.CallOption) (*ReplayResponse, error) {
+	out := new(ReplayResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/Replay", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeResponse, error) {
+	out := new(ProbeResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/Probe", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) Stream(ctx context.Context, opts ...grpc.CallOption) (Member_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Member_ServiceDesc.Streams[0], "/clusterpb.Member/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &memberStreamClient{stream}
+	return x, nil
+}
+
+type Member_StreamClient interface {
+	Send(*StreamBatch) error
+	Recv() (*StreamBatch, error)
+	grpc.ClientStream
+}
+
+type memberStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *memberStreamClient) Send(m *StreamBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *memberStreamClient) Recv() (*StreamBatch, error) {
+	m := new(StreamBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *memberClient) NewMember(ctx context.Context, in *NewMemberRequest, opts ...grpc.CallOption) (*NewMemberResponse, error) {
 	out := new(NewMemberResponse)
 	err := c.cc.Invoke(ctx, "/clusterpb.Member/NewMember", in, out, opts...)
@@ -268,6 +517,33 @@ func (c *memberClient) CloseSession(ctx context.Context, in *CloseSessionRequest
 	return out, nil
 }
 
+func (c *memberClient) HoldSession(ctx context.Context, in *HoldSessionRequest, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/HoldSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) ReleaseSession(ctx context.Context, in *ReleaseSessionRequest, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/ReleaseSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *memberClient) RestoreSession(ctx context.Context, in *SessionState, opts ...grpc.CallOption) (*MemberHandleResponse, error) {
+	out := new(MemberHandleResponse)
+	err := c.cc.Invoke(ctx, "/clusterpb.Member/RestoreSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MemberServer is the server API for Member service.
 // All implementations should embed UnimplementedMemberServer
 // for forward compatibility
@@ -276,10 +552,21 @@ type MemberServer interface {
 	HandleNotify(context.Context, *NotifyMessage) (*MemberHandleResponse, error)
 	HandlePush(context.Context, *PushMessage) (*MemberHandleResponse, error)
 	HandleResponse(context.Context, *ResponseMessage) (*MemberHandleResponse, error)
+	HandleCall(context.Context, *CallRequest) (*CallResponse, error)
+	HandleSessionSync(context.Context, *SessionSyncMessage) (*MemberHandleResponse, error)
+	HandleGroupPush(context.Context, *GroupPushMessage) (*MemberHandleResponse, error)
+	HandleSystem(context.Context, *SystemMessage) (*MemberHandleResponse, error)
+	HandlePublish(context.Context, *PublishMessage) (*MemberHandleResponse, error)
+	Replay(context.Context, *ReplayRequest) (*ReplayResponse, error)
+	Probe(context.Context, *ProbeRequest) (*ProbeResponse, error)
+	Stream(Member_StreamServer) error
 	NewMember(context.Context, *NewMemberRequest) (*NewMemberResponse, error)
 	DelMember(context.Context, *DelMemberRequest) (*DelMemberResponse, error)
 	SessionClosed(context.Context, *SessionClosedRequest) (*SessionClosedResponse, error)
 	CloseSession(context.Context, *CloseSessionRequest) (*CloseSessionResponse, error)
+	HoldSession(context.Context, *HoldSessionRequest) (*MemberHandleResponse, error)
+	ReleaseSession(context.Context, *ReleaseSessionRequest) (*MemberHandleResponse, error)
+	RestoreSession(context.Context, *SessionState) (*MemberHandleResponse, error)
 }
 
 // UnimplementedMemberServer should be embedded to have forward compatible implementations.
@@ -298,6 +585,30 @@ func (UnimplementedMemberServer) HandlePush(context.Context, *PushMessage) (*Mem
 func (UnimplementedMemberServer) HandleResponse(context.Context, *ResponseMessage) (*MemberHandleResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HandleResponse not implemented")
 }
+func (UnimplementedMemberServer) HandleCall(context.Context, *CallRequest) (*CallResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleCall not implemented")
+}
+func (UnimplementedMemberServer) HandleSessionSync(context.Context, *SessionSyncMessage) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleSessionSync not implemented")
+}
+func (UnimplementedMemberServer) HandleGroupPush(context.Context, *GroupPushMessage) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleGroupPush not implemented")
+}
+func (UnimplementedMemberServer) HandleSystem(context.Context, *SystemMessage) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleSystem not implemented")
+}
+func (UnimplementedMemberServer) HandlePublish(context.Context, *PublishMessage) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandlePublish not implemented")
+}
+func (UnimplementedMemberServer) Replay(context.Context, *ReplayRequest) (*ReplayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Replay not implemented")
+}
+func (UnimplementedMemberServer) Probe(context.Context, *ProbeRequest) (*ProbeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Probe not implemented")
+}
+func (UnimplementedMemberServer) Stream(Member_StreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
 func (UnimplementedMemberServer) NewMember(context.Context, *NewMemberRequest) (*NewMemberResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NewMember not implemented")
 }
@@ -310,6 +621,15 @@ func (UnimplementedMemberServer) SessionClosed(context.Context, *SessionClosedRe
 func (UnimplementedMemberServer) CloseSession(context.Context, *CloseSessionRequest) (*CloseSessionResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CloseSession not implemented")
 }
+func (UnimplementedMemberServer) HoldSession(context.Context, *HoldSessionRequest) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HoldSession not implemented")
+}
+func (UnimplementedMemberServer) ReleaseSession(context.Context, *ReleaseSessionRequest) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseSession not implemented")
+}
+func (UnimplementedMemberServer) RestoreSession(context.Context, *SessionState) (*MemberHandleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreSession not implemented")
+}
 
 // UnsafeMemberServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to MemberServer will
@@ -394,6 +714,158 @@ func _Member_HandleResponse_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Member_HandleCall_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CallRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HandleCall(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HandleCall",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HandleCall(ctx, req.(*CallRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_HandleSessionSync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionSyncMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HandleSessionSync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HandleSessionSync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HandleSessionSync(ctx, req.(*SessionSyncMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_HandleGroupPush_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GroupPushMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HandleGroupPush(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HandleGroupPush",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HandleGroupPush(ctx, req.(*GroupPushMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_HandleSystem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SystemMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HandleSystem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HandleSystem",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HandleSystem(ctx, req.(*SystemMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_HandlePublish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HandlePublish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HandlePublish",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HandlePublish(ctx, req.(*PublishMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_Replay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).Replay(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/Replay",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).Replay(ctx, req.(*ReplayRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_Probe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProbeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).Probe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/Probe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).Probe(ctx, req.(*ProbeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MemberServer).Stream(&memberStreamServer{stream})
+}
+
+type Member_StreamServer interface {
+	Send(*StreamBatch) error
+	Recv() (*StreamBatch, error)
+	grpc.ServerStream
+}
+
+type memberStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *memberStreamServer) Send(m *StreamBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *memberStreamServer) Recv() (*StreamBatch, error) {
+	m := new(StreamBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Member_NewMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(NewMemberRequest)
 	if err := dec(in); err != nil {
@@ -466,6 +938,60 @@ func _Member_CloseSession_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Member_HoldSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HoldSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).HoldSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/HoldSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).HoldSession(ctx, req.(*HoldSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_ReleaseSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).ReleaseSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/ReleaseSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).ReleaseSession(ctx, req.(*ReleaseSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Member_RestoreSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionState)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MemberServer).RestoreSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/clusterpb.Member/RestoreSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MemberServer).RestoreSession(ctx, req.(*SessionState))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Member_ServiceDesc is the grpc.ServiceDesc for Member service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -489,6 +1015,34 @@ var Member_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "HandleResponse",
 			Handler:    _Member_HandleResponse_Handler,
 		},
+		{
+			MethodName: "HandleCall",
+			Handler:    _Member_HandleCall_Handler,
+		},
+		{
+			MethodName: "HandleSessionSync",
+			Handler:    _Member_HandleSessionSync_Handler,
+		},
+		{
+			MethodName: "HandleGroupPush",
+			Handler:    _Member_HandleGroupPush_Handler,
+		},
+		{
+			MethodName: "HandleSystem",
+			Handler:    _Member_HandleSystem_Handler,
+		},
+		{
+			MethodName: "HandlePublish",
+			Handler:    _Member_HandlePublish_Handler,
+		},
+		{
+			MethodName: "Replay",
+			Handler:    _Member_Replay_Handler,
+		},
+		{
+			MethodName: "Probe",
+			Handler:    _Member_Probe_Handler,
+		},
 		{
 			MethodName: "NewMember",
 			Handler:    _Member_NewMember_Handler,
@@ -505,7 +1059,26 @@ var Member_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CloseSession",
 			Handler:    _Member_CloseSession_Handler,
 		},
+		{
+			MethodName: "HoldSession",
+			Handler:    _Member_HoldSession_Handler,
+		},
+		{
+			MethodName: "ReleaseSession",
+			Handler:    _Member_ReleaseSession_Handler,
+		},
+		{
+			MethodName: "RestoreSession",
+			Handler:    _Member_RestoreSession_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Member_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "cluster.proto",
 }
@@ -23,43 +23,83 @@ package cluster
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/acoderup/core/logger"
 	"github.com/acoderup/nano/internal/env"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
 )
 
+const (
+	defaultConnPoolSize   = 10
+	defaultConnMaxBackoff = 5 * time.Second
+)
+
+// ConnPoolStats represents the statistics of the connection pool of a peer member
+type ConnPoolStats struct {
+	Addr             string    // service address of the peer
+	Size             int       // connections count of the pool
+	Ready            int       // connections in ready state
+	Connecting       int       // connections in connecting state
+	Idle             int       // connections in idle state
+	TransientFailure int       // connections in transient failure state
+	Failures         uint64    // times of connections entering transient failure
+	LastUsed         time.Time // last time the pool was used
+}
+
+// Degraded reports whether some connections of the pool are failed
+func (s ConnPoolStats) Degraded() bool {
+	return s.TransientFailure > 0
+}
+
 type connPool struct {
-	index uint32
-	v     []*grpc.ClientConn
+	addr     string
+	index    uint32
+	v        []*grpc.ClientConn
+	lastUsed int64 // unix nano
+	failures uint64
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 type rpcClient struct {
 	sync.RWMutex
-	isClosed bool
-	pools    map[string]*connPool
+	isClosed    bool
+	pools       map[string]*connPool
+	size        int
+	idleTimeout time.Duration
+	dialOptions []grpc.DialOption
+	die         chan struct{}
 }
 
-func newConnArray(maxSize uint, addr string) (*connPool, error) {
+func newConnArray(maxSize uint, addr string, opts []grpc.DialOption) (*connPool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	a := &connPool{
-		index: 0,
-		v:     make([]*grpc.ClientConn, maxSize),
+		addr:     addr,
+		index:    0,
+		v:        make([]*grpc.ClientConn, maxSize),
+		lastUsed: time.Now().UnixNano(),
+		ctx:      ctx,
+		cancel:   cancel,
 	}
-	if err := a.init(addr); err != nil {
+	if err := a.init(addr, opts); err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-func (a *connPool) init(addr string) error {
+func (a *connPool) init(addr string, opts []grpc.DialOption) error {
 	for i := range a.v {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		conn, err := grpc.DialContext(
 			ctx,
 			addr,
-			env.GrpcOptions...,
+			opts...,
 		)
 		cancel()
 		if err != nil {
@@ -68,32 +108,118 @@ func (a *connPool) init(addr string) error {
 			return err
 		}
 		a.v[i] = conn
-
+		go a.monitor(conn)
 	}
 	return nil
 }
 
+// monitor watches the connectivity state of the connection, the connection will
+// be reconnected with backoff by gRPC after transient failure, and the idle
+// connection will be reconnected immediately to keep the pool warm. The initial
+// state is handled as well, the dialing may have failed before monitoring.
+func (a *connPool) monitor(conn *grpc.ClientConn) {
+	for state := conn.GetState(); ; state = conn.GetState() {
+		switch state {
+		case connectivity.TransientFailure:
+			atomic.AddUint64(&a.failures, 1)
+			logger.Logger.Tracef("Connection to [%s] failed, will reconnect with backoff", a.addr)
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.Shutdown:
+			return
+		}
+		if !conn.WaitForStateChange(a.ctx, state) {
+			return
+		}
+	}
+}
+
+// Get returns a healthy connection in round-robin, the connections in transient
+// failure will be skipped unless all connections are failed
 func (a *connPool) Get() *grpc.ClientConn {
-	next := atomic.AddUint32(&a.index, 1) % uint32(len(a.v))
-	return a.v[next]
+	a.touch()
+	size := uint32(len(a.v))
+	next := atomic.AddUint32(&a.index, 1)
+	for i := uint32(0); i < size; i++ {
+		conn := a.v[(next+i)%size]
+		if state := conn.GetState(); state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			return conn
+		}
+	}
+	return a.v[next%size]
+}
+
+// touch marks the connection pool used, so it will not be reaped in idle timeout
+func (a *connPool) touch() {
+	atomic.StoreInt64(&a.lastUsed, time.Now().UnixNano())
 }
 
 func (a *connPool) Close() {
-	for i, c := range a.v {
+	a.cancel()
+	for _, c := range a.v {
 		if c != nil {
-			err := c.Close()
-			if err != nil {
-				// TODO: error handling
+			if err := c.Close(); err != nil {
+				logger.Logger.Tracef("Close connection to [%s] error: %v", a.addr, err)
 			}
-			a.v[i] = nil
 		}
 	}
 }
 
-func newRPCClient() *rpcClient {
-	return &rpcClient{
-		pools: make(map[string]*connPool),
+func (a *connPool) stats() ConnPoolStats {
+	stats := ConnPoolStats{
+		Addr:     a.addr,
+		Size:     len(a.v),
+		Failures: atomic.LoadUint64(&a.failures),
+		LastUsed: time.Unix(0, atomic.LoadInt64(&a.lastUsed)),
+	}
+	for _, c := range a.v {
+		switch c.GetState() {
+		case connectivity.Ready:
+			stats.Ready++
+		case connectivity.Connecting:
+			stats.Connecting++
+		case connectivity.Idle:
+			stats.Idle++
+		case connectivity.TransientFailure:
+			stats.TransientFailure++
+		}
+	}
+	return stats
+}
+
+// newRPCClient returns the client with the configurations of options, the dialOptions
+// will be appended to the user specified gRPC options to secure the connections
+func newRPCClient(opts Options, dialOptions []grpc.DialOption) *rpcClient {
+	size := opts.ConnPoolSize
+	if size <= 0 {
+		size = defaultConnPoolSize
+	}
+	maxBackoff := opts.ConnMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultConnMaxBackoff
+	}
+	config := backoff.DefaultConfig
+	config.MaxDelay = maxBackoff
+	if config.BaseDelay > maxBackoff {
+		config.BaseDelay = maxBackoff
+	}
+
+	// The options specified by user take precedence over the backoff config
+	options := []grpc.DialOption{grpc.WithConnectParams(grpc.ConnectParams{Backoff: config})}
+	options = append(options, env.GrpcOptions...)
+	options = append(options, dialOptions...)
+
+	c := &rpcClient{
+		pools:       make(map[string]*connPool),
+		size:        size,
+		idleTimeout: opts.ConnIdleTimeout,
+		dialOptions: options,
+		die:         make(chan struct{}),
 	}
+	if c.idleTimeout > 0 {
+		go c.reap()
+	}
+	return c
 }
 
 func (c *rpcClient) getConnPool(addr string) (*connPool, error) {
@@ -117,11 +243,13 @@ func (c *rpcClient) getConnPool(addr string) (*connPool, error) {
 func (c *rpcClient) createConnPool(addr string) (*connPool, error) {
 	c.Lock()
 	defer c.Unlock()
+	if c.isClosed {
+		return nil, errors.New("rpc client is closed")
+	}
 	array, ok := c.pools[addr]
 	if !ok {
 		var err error
-		// TODO: make conn count configurable
-		array, err = newConnArray(10, addr)
+		array, err = newConnArray(uint(c.size), addr, c.dialOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -130,14 +258,73 @@ func (c *rpcClient) createConnPool(addr string) (*connPool, error) {
 	return array, nil
 }
 
+// closeConnPool closes the connection pool of addr, it will be called when the
+// member left cluster
+func (c *rpcClient) closeConnPool(addr string) {
+	c.Lock()
+	array, ok := c.pools[addr]
+	delete(c.pools, addr)
+	c.Unlock()
+	if ok {
+		logger.Logger.Tracef("Close connection pool of [%s]", addr)
+		array.Close()
+	}
+}
+
+// reap closes the connection pools which are not used in idle timeout periodically
+func (c *rpcClient) reap() {
+	ticker := time.NewTicker(c.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.reapIdle(now)
+		case <-c.die:
+			return
+		}
+	}
+}
+
+// reapIdle closes the connection pools which are not used in idle timeout before now
+func (c *rpcClient) reapIdle(now time.Time) {
+	deadline := now.Add(-c.idleTimeout).UnixNano()
+	c.Lock()
+	var idle []*connPool
+	for addr, array := range c.pools {
+		if atomic.LoadInt64(&array.lastUsed) < deadline {
+			idle = append(idle, array)
+			delete(c.pools, addr)
+		}
+	}
+	c.Unlock()
+	for _, array := range idle {
+		logger.Logger.Tracef("Reap idle connection pool of [%s]", array.addr)
+		array.Close()
+	}
+}
+
+// stats returns the statistics of all connection pools sorted by address
+func (c *rpcClient) stats() []ConnPoolStats {
+	c.RLock()
+	result := make([]ConnPoolStats, 0, len(c.pools))
+	for _, array := range c.pools {
+		result = append(result, array.stats())
+	}
+	c.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Addr < result[j].Addr })
+	return result
+}
+
 func (c *rpcClient) closePool() {
 	c.Lock()
 	if !c.isClosed {
 		c.isClosed = true
+		close(c.die)
 		// close all connections
 		for _, array := range c.pools {
 			array.Close()
 		}
+		c.pools = map[string]*connPool{}
 	}
 	c.Unlock()
 }
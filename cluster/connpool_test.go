@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not satisfied in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnPool(t *testing.T) {
+	listener, err := memListen("connpool.ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c := newRPCClient(Options{ConnPoolSize: 2, ConnMaxBackoff: 100 * time.Millisecond}, memDialOptions)
+	defer c.closePool()
+
+	pool, err := c.getConnPool("connpool.ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.v) != 2 {
+		t.Fatalf("expect 2 connections, got: %d", len(pool.v))
+	}
+	waitFor(t, func() bool {
+		stats := c.stats()
+		return len(stats) == 1 && stats[0].Ready == 2
+	})
+
+	// failed connections are reported and skipped
+	failed, err := c.getConnPool("connpool.unreachable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		stats := c.stats()
+		return len(stats) == 2 && stats[1].Degraded() && stats[1].Failures > 0
+	})
+	if stats := c.stats(); stats[0].Degraded() {
+		t.Fatalf("unexpected degraded pool: %+v", stats[0])
+	}
+	server.Stop()
+	waitFor(t, func() bool { return pool.Get().GetState() != connectivity.Ready })
+
+	// evicted pool is closed
+	c.closeConnPool("connpool.unreachable")
+	if stats := c.stats(); len(stats) != 1 || stats[0].Addr != "connpool.ready" {
+		t.Fatalf("unexpected pools: %+v", stats)
+	}
+	if state := failed.v[0].GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expect connection shutdown, got: %v", state)
+	}
+}
+
+func TestConnPoolReap(t *testing.T) {
+	c := newRPCClient(Options{ConnPoolSize: 1, ConnIdleTimeout: 100 * time.Millisecond}, memDialOptions)
+	defer c.closePool()
+
+	pool, err := c.getConnPool("connpool.reap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(c.stats()) == 0 })
+	if state := pool.v[0].GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expect connection shutdown, got: %v", state)
+	}
+}
+
+// discardStream discards the batches sent and never receives any batch
+type discardStream struct{ closed chan struct{} }
+
+func (s *discardStream) Send(*clusterpb.StreamBatch) error { return nil }
+
+func (s *discardStream) Recv() (*clusterpb.StreamBatch, error) {
+	<-s.closed
+	return nil, io.EOF
+}
+
+func TestConnPoolReapStream(t *testing.T) {
+	// the pools are reaped by reapIdle only
+	c := newRPCClient(Options{ConnPoolSize: 1, ConnIdleTimeout: time.Hour}, memDialOptions)
+	defer c.closePool()
+
+	pool, err := c.getConnPool("connpool.stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &discardStream{closed: make(chan struct{})}
+	defer close(stream.closed)
+	s := newMemberStream(pool.addr, stream, nil, pool)
+	s.start(func(*clusterpb.StreamMessage) {})
+	defer s.close()
+
+	// the pool carrying the stream in use is kept without getting connections
+	atomic.StoreInt64(&pool.lastUsed, 0)
+	if err := s.send(context.Background(), &clusterpb.StreamMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	c.reapIdle(time.Now())
+	if stats := c.stats(); len(stats) != 1 {
+		t.Fatalf("expect pool kept, got: %+v", stats)
+	}
+
+	// the pool is reaped once the stream has been idle for the timeout
+	c.reapIdle(time.Now().Add(2 * time.Hour))
+	if stats := c.stats(); len(stats) != 0 {
+		t.Fatalf("expect pool reaped, got: %+v", stats)
+	}
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/pipeline"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+type TraceComponent struct {
+	component.Base
+	notified chan context.Context
+}
+
+func (c *TraceComponent) Notify(ctx context.Context, _ *session.Session, _ *testdata.Ping) error {
+	c.notified <- ctx
+	return nil
+}
+
+func (c *TraceComponent) Query(ctx context.Context, _ *session.Session, _ *testdata.Ping) (*testdata.Pong, error) {
+	return &testdata.Pong{Content: session.MetadataValue(ctx, "trace-id")}, nil
+}
+
+func TestContextPropagation(t *testing.T) {
+	go scheduler.Sched()
+
+	pipe := pipeline.New()
+	pipe.Inbound().PushBack(func(_ *session.Session, msg *message.Message) error {
+		msg.SetMetadata("trace-id", "from-pipeline")
+		return nil
+	})
+	// the inbound pipeline runs where the message is handled, never on forwarding
+	var inbound atomic.Int32
+	local := pipeline.New()
+	local.Inbound().PushBack(func(*session.Session, *message.Message) error {
+		inbound.Add(1)
+		return nil
+	})
+	gate := &Node{
+		Options:     Options{IsMaster: true, Pipeline: local, GatePipeline: pipe, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "context.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	trace := &TraceComponent{notified: make(chan context.Context, 1)}
+	comps := &component.Components{}
+	comps.Register(trace)
+	backend := &Node{
+		Options:     Options{AdvertiseAddr: gate.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "context.backend",
+	}
+	if err := backend.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Shutdown()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	a := newAgent(conn, "", "", pipe, gate.handler.remoteProcess, gate.handler.call)
+
+	receive := func() context.Context {
+		select {
+		case ctx := <-trace.notified:
+			return ctx
+		case <-time.After(3 * time.Second):
+			t.Fatal("notify handler not called")
+		}
+		return nil
+	}
+
+	// metadata set by gate inbound pipeline
+	data, _ := message.Serialize(&testdata.Ping{})
+	gate.handler.processMessage(a, &message.Message{Type: message.Notify, Route: "TraceComponent.Notify", Data: data})
+	if v := session.MetadataValue(receive(), "trace-id"); v != "from-pipeline" {
+		t.Fatalf("unexpected trace id: %s", v)
+	}
+	if n := inbound.Load(); n != 0 {
+		t.Fatalf("expect inbound pipeline not run by gate, got: %d", n)
+	}
+
+	// metadata and deadline through RPC chains
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	ctx = session.WithMetadata(ctx, map[string]string{"trace-id": "from-rpc", "locale": "en"})
+	if err := a.session.RPCContext(ctx, "TraceComponent.Notify", &testdata.Ping{}); err != nil {
+		t.Fatal(err)
+	}
+	received := receive()
+	if session.MetadataValue(received, "trace-id") != "from-rpc" || session.MetadataValue(received, "locale") != "en" {
+		t.Fatalf("unexpected metadata: %v", session.Metadata(received))
+	}
+	if d, ok := received.Deadline(); !ok || !d.Equal(deadline) {
+		t.Fatalf("expect deadline %v, got: %v", deadline, d)
+	}
+
+	// metadata through Call
+	pong := &testdata.Pong{}
+	if err := a.session.Call(ctx, "TraceComponent.Query", &testdata.Ping{}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "from-rpc" {
+		t.Fatalf("unexpected trace id: %s", pong.Content)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/env"
+)
+
+const (
+	// heartbeatWindow is the count of recent heartbeat intervals kept by phi accrual detector
+	heartbeatWindow = 100
+	// minHeartbeatSamples is the count of intervals required before phi is trusted, the
+	// suspect timeout will be used until enough heartbeats have been received
+	minHeartbeatSamples = 3
+)
+
+// heartbeatHistory records the recent intervals between heartbeats of a member, and
+// estimates the suspicion level phi of the phi accrual failure detector
+type heartbeatHistory struct {
+	last      time.Time
+	intervals []float64 // milliseconds
+	next      int
+}
+
+func (h *heartbeatHistory) record(now time.Time) {
+	if !h.last.IsZero() {
+		interval := float64(now.Sub(h.last)) / float64(time.Millisecond)
+		if len(h.intervals) < heartbeatWindow {
+			h.intervals = append(h.intervals, interval)
+		} else {
+			h.intervals[h.next] = interval
+			h.next = (h.next + 1) % heartbeatWindow
+		}
+	}
+	h.last = now
+}
+
+// phi returns -log10 of the probability that a heartbeat arrives later than now,
+// assuming the intervals are normally distributed
+func (h *heartbeatHistory) phi(now time.Time) float64 {
+	if len(h.intervals) == 0 {
+		return 0
+	}
+	var mean, variance float64
+	for _, v := range h.intervals {
+		mean += v
+	}
+	mean /= float64(len(h.intervals))
+	for _, v := range h.intervals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(h.intervals))
+
+	// The deviation of a steady heartbeat is near zero, which makes phi jump to
+	// infinity on a tiny delay, so it is bounded below by a quarter of the mean
+	stddev := math.Max(math.Sqrt(variance), mean/4)
+	elapsed := float64(now.Sub(h.last)) / float64(time.Millisecond)
+
+	// Logistic approximation of the cumulative normal distribution
+	y := (elapsed - mean) / stddev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+func (n *Node) suspectTimeout() time.Duration {
+	if n.SuspectTimeout > 0 {
+		return n.SuspectTimeout
+	}
+	return 2 * env.Heartbeat
+}
+
+func (n *Node) deadTimeout() time.Duration {
+	if n.DeadTimeout > 0 {
+		return n.DeadTimeout
+	}
+	return 4 * env.Heartbeat
+}
+
+// suspicious reports whether the member should be suspected by master, the phi accrual
+// detector is used if it is enabled and enough heartbeats have been received, otherwise
+// the member is suspected without heartbeat for timeout. It should be called with mu held.
+func (c *cluster) suspicious(m *Member, now time.Time, timeout time.Duration) bool {
+	threshold := c.currentNode.PhiThreshold
+	if threshold > 0 && m.heartbeats != nil && len(m.heartbeats.intervals) >= minHeartbeatSamples {
+		return m.heartbeats.phi(now) > threshold
+	}
+	return now.Sub(m.lastHeartbeatAt) > timeout
+}
+
+// suspect marks or clears the suspicion of the member in current node, the routes of
+// new sessions avoid the suspected members
+func (c *cluster) suspect(addr string, suspected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.members {
+		if m.memberInfo.ServiceAddr == addr && m.suspect != suspected {
+			m.suspect = suspected
+			logger.Logger.Tracef("Member [%s] suspected: %t", addr, suspected)
+		}
+	}
+}
+
+// aliveMembers excludes the members suspected by current node, all members will be
+// returned if every member is suspected
+func (c *cluster) aliveMembers(members []*clusterpb.MemberInfo) []*clusterpb.MemberInfo {
+	var suspects map[string]bool
+	c.mu.RLock()
+	for _, m := range c.members {
+		if m.suspect {
+			if suspects == nil {
+				suspects = map[string]bool{}
+			}
+			suspects[m.memberInfo.ServiceAddr] = true
+		}
+	}
+	c.mu.RUnlock()
+	if len(suspects) == 0 {
+		return members
+	}
+
+	alive := make([]*clusterpb.MemberInfo, 0, len(members))
+	for _, m := range members {
+		if !suspects[m.ServiceAddr] {
+			alive = append(alive, m)
+		}
+	}
+	if len(alive) == 0 {
+		return members
+	}
+	return alive
+}
+
+// probeMembers probes the connections to all members in each probe interval, the
+// unreachable members will be suspected until they respond again. The master is
+// not probed since it is watched by the heartbeat.
+func (c *cluster) probeMembers() {
+	interval := c.currentNode.ProbeInterval
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				var wg sync.WaitGroup
+				for _, info := range c.memberInfos() {
+					if info.ServiceAddr == c.currentNode.ServiceAddr || info.ServiceAddr == c.currentNode.AdvertiseAddr {
+						continue
+					}
+					wg.Add(1)
+					go func(info *clusterpb.MemberInfo) {
+						defer wg.Done()
+						c.suspect(info.ServiceAddr, !c.probe(info, interval))
+					}(info)
+				}
+				wg.Wait()
+			case <-c.die:
+				return
+			}
+		}
+	}()
+}
+
+// probe reports whether the member responds in time, the member restarted with
+// a different incarnation is considered unreachable until master announces it
+func (c *cluster) probe(info *clusterpb.MemberInfo, timeout time.Duration) bool {
+	pool, err := c.currentNode.rpcClient.getConnPool(info.ServiceAddr)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := clusterpb.NewMemberClient(pool.Get()).Probe(ctx, &clusterpb.ProbeRequest{})
+	if err != nil {
+		logger.Logger.Tracef("Probe member [%s] failed: %v", info.ServiceAddr, err)
+		return false
+	}
+	return info.Incarnation == 0 || resp.Incarnation == info.Incarnation
+}
+
+// Probe implements the MemberServer interface
+func (n *Node) Probe(context.Context, *clusterpb.ProbeRequest) (*clusterpb.ProbeResponse, error) {
+	return &clusterpb.ProbeResponse{Incarnation: n.Incarnation()}, nil
+}
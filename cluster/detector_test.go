@@ -0,0 +1,22 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhiAccrual(t *testing.T) {
+	h := &heartbeatHistory{}
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		h.record(now)
+		now = now.Add(100 * time.Millisecond)
+	}
+	last := now.Add(-100 * time.Millisecond)
+	if phi := h.phi(last.Add(100 * time.Millisecond)); phi > 1 {
+		t.Fatalf("expect low phi on time, got: %f", phi)
+	}
+	if phi := h.phi(last.Add(500 * time.Millisecond)); phi < 8 {
+		t.Fatalf("expect high phi after missed heartbeats, got: %f", phi)
+	}
+}
@@ -0,0 +1,403 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/session"
+)
+
+const (
+	// directoryTimeout is the timeout of the calls to the UID directory in master
+	directoryTimeout = 3 * time.Second
+	// maxPendingDirectoryUpdates bounds the updates waiting to be sent to the UID
+	// directory, the oldest one is dropped if the queue is full
+	maxPendingDirectoryUpdates = 1 << 14
+)
+
+// KickRoute is the route of the message carrying the reason pushed to the session
+// before it is kicked by KickUID
+const KickRoute = "onKick"
+
+type sessionKey struct {
+	gateAddr string
+	sid      int64
+}
+
+// directory maps the UID to the session which the UID bound to, it is maintained by
+// master. A UID is bound to one session at most, and the latest binding wins.
+type directory struct {
+	mu       sync.RWMutex
+	uids     map[int64]*clusterpb.UIDEntry
+	sessions map[sessionKey]int64
+}
+
+func newDirectory() *directory {
+	return &directory{
+		uids:     map[int64]*clusterpb.UIDEntry{},
+		sessions: map[sessionKey]int64{},
+	}
+}
+
+func (d *directory) bind(entry *clusterpb.UIDEntry) {
+	key := sessionKey{gateAddr: entry.GateAddr, sid: entry.SessionId}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The session has been bound to another UID
+	if uid, found := d.sessions[key]; found && uid != entry.Uid {
+		delete(d.uids, uid)
+	}
+	// The UID has been bound to another session, e.g. login again from another gate
+	if old, found := d.uids[entry.Uid]; found {
+		delete(d.sessions, sessionKey{gateAddr: old.GateAddr, sid: old.SessionId})
+	}
+	d.uids[entry.Uid] = entry
+	d.sessions[key] = entry.Uid
+}
+
+func (d *directory) unbind(gateAddr string, sid int64) {
+	key := sessionKey{gateAddr: gateAddr, sid: sid}
+	d.mu.Lock()
+	if uid, found := d.sessions[key]; found {
+		delete(d.sessions, key)
+		delete(d.uids, uid)
+	}
+	d.mu.Unlock()
+}
+
+// removeGate removes all sessions of the gate, it will be called when the gate left
+func (d *directory) removeGate(addr string) {
+	d.mu.Lock()
+	for uid, entry := range d.uids {
+		if entry.GateAddr == addr {
+			delete(d.uids, uid)
+			delete(d.sessions, sessionKey{gateAddr: entry.GateAddr, sid: entry.SessionId})
+		}
+	}
+	d.mu.Unlock()
+}
+
+func (d *directory) lookup(uids []int64) []*clusterpb.UIDEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entries := make([]*clusterpb.UIDEntry, 0, len(uids))
+	for _, uid := range uids {
+		if entry, found := d.uids[uid]; found {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// BindUID implements the MasterServer gRPC service
+func (c *cluster) BindUID(_ context.Context, req *clusterpb.BindUIDRequest) (*clusterpb.BindUIDResponse, error) {
+	if req.Entry != nil && req.Entry.Uid > 0 {
+		c.directory.bind(req.Entry)
+	}
+	return &clusterpb.BindUIDResponse{}, nil
+}
+
+// UnbindSession implements the MasterServer gRPC service
+func (c *cluster) UnbindSession(_ context.Context, req *clusterpb.UnbindSessionRequest) (*clusterpb.UnbindSessionResponse, error) {
+	c.directory.unbind(req.GateAddr, req.SessionId)
+	return &clusterpb.UnbindSessionResponse{}, nil
+}
+
+// LookupUID implements the MasterServer gRPC service
+func (c *cluster) LookupUID(_ context.Context, req *clusterpb.LookupUIDRequest) (*clusterpb.LookupUIDResponse, error) {
+	return &clusterpb.LookupUIDResponse{Entries: c.directory.lookup(req.Uids)}, nil
+}
+
+// localDirectory reports whether the UID directory is maintained by current node,
+// which is master or running in singleton mode
+func (n *Node) localDirectory() bool {
+	return n.IsMaster || n.AdvertiseAddr == ""
+}
+
+func (n *Node) masterClient() (clusterpb.MasterClient, error) {
+	pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
+	if err != nil {
+		return nil, err
+	}
+	return clusterpb.NewMasterClient(pool.Get()), nil
+}
+
+// directoryUpdate is a binding or an unbinding waiting to be sent to the UID directory
+type directoryUpdate struct {
+	bind   *clusterpb.UIDEntry             // binds the UID to session if not nil
+	unbind *clusterpb.UnbindSessionRequest // unbinds the session otherwise
+}
+
+// directoryQueue holds the updates of the UID directory in master, which are sent in
+// order by a background goroutine, so binding a UID never blocks the handler
+type directoryQueue struct {
+	mu      sync.Mutex
+	items   []directoryUpdate
+	sending bool
+}
+
+func (n *Node) bindUID(gateAddr string, sid, uid int64) {
+	entry := &clusterpb.UIDEntry{Uid: uid, GateAddr: gateAddr, SessionId: sid}
+	if n.localDirectory() {
+		n.cluster.directory.bind(entry)
+		return
+	}
+	n.updateDirectory(directoryUpdate{bind: entry})
+}
+
+func (n *Node) unbindSession(gateAddr string, sid int64) {
+	if n.localDirectory() {
+		n.cluster.directory.unbind(gateAddr, sid)
+		return
+	}
+	n.updateDirectory(directoryUpdate{unbind: &clusterpb.UnbindSessionRequest{GateAddr: gateAddr, SessionId: sid}})
+}
+
+// updateDirectory queues the update of the UID directory in master and starts the
+// sending goroutine if it is not running
+func (n *Node) updateDirectory(u directoryUpdate) {
+	q := &n.directoryUpdates
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// The head is being sent, so the oldest one after it is dropped
+	if len(q.items) >= maxPendingDirectoryUpdates {
+		logger.Logger.Tracef("Too many pending directory updates, drop the oldest one")
+		q.items = slices.Delete(q.items, 1, 2)
+	}
+	q.items = append(q.items, u)
+	if !q.sending {
+		q.sending = true
+		go n.sendDirectoryUpdates()
+	}
+}
+
+// sendDirectoryUpdates sends the queued updates to master until the queue is empty,
+// the failed update is retried with backoff until current node is closed
+func (n *Node) sendDirectoryUpdates() {
+	q := &n.directoryUpdates
+	backoff := notifyRetryInterval
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		head := q.items[0]
+		q.mu.Unlock()
+
+		if err := n.sendDirectoryUpdate(head); err != nil {
+			logger.Logger.Tracef("Update UID directory failed and will retry: %v", err)
+			select {
+			case <-time.After(backoff):
+			case <-n.cluster.die:
+				return
+			}
+			backoff = min(backoff*2, notifyMaxBackoff)
+			continue
+		}
+		backoff = notifyRetryInterval
+
+		q.mu.Lock()
+		q.items = q.items[1:]
+		q.mu.Unlock()
+	}
+}
+
+func (n *Node) sendDirectoryUpdate(u directoryUpdate) error {
+	client, err := n.masterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	if u.bind != nil {
+		_, err = client.BindUID(ctx, &clusterpb.BindUIDRequest{Entry: u.bind})
+	} else {
+		_, err = client.UnbindSession(ctx, u.unbind)
+	}
+	return err
+}
+
+func (n *Node) lookupUIDs(uids []int64) ([]*clusterpb.UIDEntry, error) {
+	if n.localDirectory() {
+		return n.cluster.directory.lookup(uids), nil
+	}
+	client, err := n.masterClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	resp, err := client.LookupUID(ctx, &clusterpb.LookupUIDRequest{Uids: uids})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// trackSession records the UID bound to the gate session in directory
+func (n *Node) trackSession(s *session.Session) {
+	if !n.UIDDirectory {
+		return
+	}
+	s.SetChangeHook(func(key string) {
+		if key == "" {
+			n.bindUID(n.ServiceAddr, s.ID(), s.UID())
+		}
+	})
+}
+
+// untrackSession removes the closed gate session from directory
+func (n *Node) untrackSession(s *session.Session) {
+	if n.UIDDirectory {
+		n.unbindSession(n.ServiceAddr, s.ID())
+	}
+}
+
+// directoryHook returns the change hook of the backend session, which records the
+// UID bound in backend in directory, and then calls next
+func (n *Node) directoryHook(ac *acceptor, next func(key string)) func(key string) {
+	if !n.UIDDirectory {
+		return next
+	}
+	return func(key string) {
+		if key == "" {
+			n.bindUID(ac.gateAddr, ac.sid, ac.session.UID())
+		}
+		if next != nil {
+			next(key)
+		}
+	}
+}
+
+// PushToUID pushes message to the session which uid bound to, the message is sent by
+// the node started most recently in current process. See Node.PushToUID for more details.
+func PushToUID(uid int64, route string, v interface{}) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.PushToUID(uid, route, v)
+}
+
+// KickUID kicks the session which uid bound to, the message is sent by the node started
+// most recently in current process. See Node.KickUID for more details.
+func KickUID(uid int64, reason string) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.KickUID(uid, reason)
+}
+
+// Multicast pushes message to the sessions which uids bound to, the message is sent by
+// the node started most recently in current process. See Node.Multicast for more details.
+func Multicast(uids []int64, route string, v interface{}) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.Multicast(uids, route, v)
+}
+
+// PushToUID pushes message to the session which uid bound to, the session will be
+// looked up in the UID directory, so it can be located in any gate of cluster. The
+// directory is maintained by the nodes enabled Options.UIDDirectory.
+func (n *Node) PushToUID(uid int64, route string, v interface{}) error {
+	entries, err := n.lookupUIDs([]int64{uid})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return ErrUIDNotFound
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+	return n.pushEntry(entries[0], route, data)
+}
+
+// Multicast pushes message to the sessions which uids bound to, the message will be
+// serialized once, and the UIDs not bound to any session will be ignored. The first
+// error of pushing will be returned after the message has been pushed to all sessions.
+func (n *Node) Multicast(uids []int64, route string, v interface{}) error {
+	entries, err := n.lookupUIDs(uids)
+	if err != nil {
+		return err
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, entry := range entries {
+		if err := n.pushEntry(entry, route, data); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// KickUID closes the session which uid bound to, the reason will be pushed to the
+// session with route KickRoute before closing if it is not empty.
+func (n *Node) KickUID(uid int64, reason string) error {
+	entries, err := n.lookupUIDs([]int64{uid})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return ErrUIDNotFound
+	}
+	entry := entries[0]
+	if reason != "" {
+		if err := n.pushEntry(entry, KickRoute, []byte(reason)); err != nil {
+			return err
+		}
+	}
+	request := &clusterpb.CloseSessionRequest{SessionId: entry.SessionId}
+	if entry.GateAddr == n.ServiceAddr {
+		_, err := n.CloseSession(context.Background(), request)
+		return err
+	}
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_CloseSession{CloseSession: request}}
+	return n.transport.send(context.Background(), entry.GateAddr, m)
+}
+
+func (n *Node) pushEntry(entry *clusterpb.UIDEntry, route string, data []byte) error {
+	request := &clusterpb.PushMessage{SessionId: entry.SessionId, Route: route, Data: data}
+	if entry.GateAddr == n.ServiceAddr {
+		_, err := n.HandlePush(context.Background(), request)
+		return err
+	}
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Push{Push: request}}
+	return n.transport.send(context.Background(), entry.GateAddr, m)
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/mock"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+// routeRecorder records the routes of messages pushed to session and closing
+type routeRecorder struct {
+	*mock.NetworkEntity
+	routes chan string
+}
+
+func (r *routeRecorder) Push(route string, _ interface{}) error {
+	r.routes <- route
+	return nil
+}
+
+func (r *routeRecorder) Close() error {
+	r.routes <- "closed"
+	return nil
+}
+
+func newRecordedSession(n *Node) (*session.Session, chan string) {
+	recorder := &routeRecorder{NetworkEntity: mock.NewNetworkEntity(), routes: make(chan string, 16)}
+	s := session.New(recorder)
+	n.storeSession(s)
+	n.trackSession(s)
+	return s, recorder.routes
+}
+
+func expectRoute(t *testing.T, routes chan string, expect string) {
+	select {
+	case route := <-routes:
+		if route != expect {
+			t.Fatalf("expect %s, got: %s", expect, route)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s not received", expect)
+	}
+}
+
+func TestUIDDirectory(t *testing.T) {
+	go scheduler.Sched()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "directory.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	gate := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "directory.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "directory.backend",
+	}
+	if err := backend.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Shutdown()
+
+	// the bindings are sent to master asynchronously
+	bound := func(uid int64) bool {
+		entries, err := backend.lookupUIDs([]int64{uid})
+		return err == nil && len(entries) == 1
+	}
+	remote, remoteRoutes := newRecordedSession(gate)
+	remote.Bind(7)
+	local, localRoutes := newRecordedSession(master)
+	local.Bind(8)
+	waitFor(t, func() bool { return bound(7) })
+
+	pong := &testdata.Pong{Content: "hello"}
+	if err := backend.PushToUID(7, "onHello", pong); err != nil {
+		t.Fatal(err)
+	}
+	expectRoute(t, remoteRoutes, "onHello")
+	if err := backend.Multicast([]int64{7, 8, 9}, "onNews", pong); err != nil {
+		t.Fatal(err)
+	}
+	expectRoute(t, remoteRoutes, "onNews")
+	expectRoute(t, localRoutes, "onNews")
+	if err := backend.PushToUID(9, "onHello", pong); err != ErrUIDNotFound {
+		t.Fatalf("expect uid not found, got: %v", err)
+	}
+
+	// binding another UID in backend replaces the previous one
+	s, err := backend.findOrCreateSession(remote.ID(), gate.ServiceAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Bind(10)
+	waitFor(t, func() bool { return !bound(7) && bound(10) })
+	if err := backend.KickUID(10, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	expectRoute(t, remoteRoutes, KickRoute)
+	expectRoute(t, remoteRoutes, "closed")
+
+	// closed session and left gate are removed
+	master.untrackSession(local)
+	if err := backend.PushToUID(8, "onHello", pong); err != ErrUIDNotFound {
+		t.Fatalf("expect uid not found, got: %v", err)
+	}
+	gate.Shutdown()
+	if err := backend.PushToUID(10, "onHello", pong); err != ErrUIDNotFound {
+		t.Fatalf("expect uid not found, got: %v", err)
+	}
+}
+
+func TestKickUIDFlush(t *testing.T) {
+	go scheduler.Sched()
+
+	gate := &Node{
+		Options:     Options{IsMaster: true, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "directory.kick",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	a := newAgent(conn, "", "", nil, gate.handler.remoteProcess, gate.handler.call)
+	go a.write()
+	gate.storeSession(a.session)
+	gate.trackSession(a.session)
+	a.session.Bind(11)
+
+	// the reason is written to client before the session closed
+	if err := gate.KickUID(11, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bye" {
+		t.Fatalf("expect kick reason, got: %q", data)
+	}
+}
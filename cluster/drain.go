@@ -0,0 +1,159 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// drainCheckInterval is the interval of checking the bound sessions while draining
+const drainCheckInterval = time.Second
+
+// Drain implements the MasterServer gRPC service, it marks the member as draining and
+// notifies all members, the draining flag is kept until the member registers again
+func (c *cluster) Drain(_ context.Context, req *clusterpb.DrainRequest) (*clusterpb.DrainResponse, error) {
+	if req.ServiceAddr == c.currentNode.ServiceAddr {
+		c.currentNode.draining.Store(true)
+	}
+
+	var info *clusterpb.MemberInfo
+	var peers []string
+	c.mu.Lock()
+	for _, m := range c.members {
+		if m.memberInfo.ServiceAddr == req.ServiceAddr {
+			old := m.memberInfo
+			info = proto.Clone(old).(*clusterpb.MemberInfo)
+			info.Draining = true
+			m.memberInfo = info
+			if !old.Draining {
+				c.currentNode.events.emit(MemberUpdated{Old: old, New: info})
+				c.epoch.Add(1)
+			}
+		}
+		if !m.isMaster {
+			peers = append(peers, m.memberInfo.ServiceAddr)
+		}
+	}
+	c.mu.Unlock()
+	if info == nil {
+		return nil, fmt.Errorf("address %s has not registered", req.ServiceAddr)
+	}
+	c.currentNode.handler.updateMember(info)
+	c.persist()
+
+	// The draining member is notified as well, so it keeps reporting draining in heartbeat
+	c.notifyMember(info, peers)
+	logger.Logger.Tracef("Member draining [%s]", req.ServiceAddr)
+	return &clusterpb.DrainResponse{}, nil
+}
+
+// Drain marks current node as draining, the route strategies of other members will skip
+// it for new sessions, and the sessions bound to it keep working. Call WaitDrained to
+// wait for the bound sessions closed before shutdown.
+func (n *Node) Drain() error {
+	n.draining.Store(true)
+	if n.IsMaster {
+		_, err := n.cluster.Drain(context.Background(), &clusterpb.DrainRequest{ServiceAddr: n.ServiceAddr})
+		return err
+	}
+	if n.AdvertiseAddr == "" {
+		return nil
+	}
+	client, err := n.masterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	_, err = client.Drain(ctx, &clusterpb.DrainRequest{ServiceAddr: n.ServiceAddr})
+	return err
+}
+
+// Draining reports whether current node is draining
+func (n *Node) Draining() bool {
+	return n.draining.Load()
+}
+
+// BoundSessions returns the count of the sessions bound to current node
+func (n *Node) BoundSessions() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.sessions)
+}
+
+// WaitDrained waits until no session bound to current node, progress will be called
+// with the count of bound sessions on each check if it is not nil. ErrDrainTimeout will
+// be returned if some sessions are still bound after timeout.
+func (n *Node) WaitDrained(timeout time.Duration, progress func(bound int)) error {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for {
+		bound := n.BoundSessions()
+		if progress != nil {
+			progress(bound)
+		}
+		if bound == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrDrainTimeout
+		}
+		<-ticker.C
+	}
+}
+
+// DrainAndShutdown drains current node, and shuts down it once all bound sessions
+// closed or timeout, the shutdown is forced and ErrDrainTimeout will be returned if
+// some sessions are still bound after timeout.
+func (n *Node) DrainAndShutdown(timeout time.Duration, progress func(bound int)) error {
+	if err := n.Drain(); err != nil {
+		logger.Logger.Tracef("Drain current node failed: %v", err)
+	}
+	err := n.WaitDrained(timeout, progress)
+	n.Shutdown()
+	return err
+}
+
+// routableMembers excludes the draining members, which should not be selected for
+// new sessions
+func routableMembers(members []*clusterpb.MemberInfo) []*clusterpb.MemberInfo {
+	for i, m := range members {
+		if !m.Draining {
+			continue
+		}
+		routable := make([]*clusterpb.MemberInfo, i, len(members)-1)
+		copy(routable, members[:i])
+		for _, m := range members[i+1:] {
+			if !m.Draining {
+				routable = append(routable, m)
+			}
+		}
+		return routable
+	}
+	return members
+}
@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/mock"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+func TestDrain(t *testing.T) {
+	go scheduler.Sched()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "drain.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	var backends []*Node
+	for i := 0; i < 2; i++ {
+		comps := &component.Components{}
+		comps.Register(&AccountComponent{})
+		backend := &Node{
+			Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: fmt.Sprintf("drain.backend%d", i),
+		}
+		if err := backend.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Shutdown()
+		backends = append(backends, backend)
+	}
+	draining, available := backends[0], backends[1]
+
+	bound := session.New(mock.NewNetworkEntity())
+	bound.Router().Bind("AccountComponent", draining.ServiceAddr)
+	if _, err := draining.findOrCreateSession(bound.ID(), master.ServiceAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := draining.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if !draining.Draining() {
+		t.Fatal("expect current node draining")
+	}
+	waitFor(t, func() bool {
+		for _, m := range available.handler.findMembers("AccountComponent") {
+			if m.ServiceAddr == draining.ServiceAddr {
+				return m.Draining
+			}
+		}
+		return false
+	})
+
+	// new sessions skip the draining member, and bound sessions keep working
+	for i := 0; i < 20; i++ {
+		s := session.New(mock.NewNetworkEntity())
+		addr, found := master.handler.remoteAddr("AccountComponent", s, master.handler.findMembers("AccountComponent"))
+		if !found || addr != available.ServiceAddr {
+			t.Fatalf("expect %s selected, got: %s", available.ServiceAddr, addr)
+		}
+	}
+	addr, _ := master.handler.remoteAddr("AccountComponent", bound, master.handler.findMembers("AccountComponent"))
+	if addr != draining.ServiceAddr {
+		t.Fatalf("expect bound session kept, got: %s", addr)
+	}
+
+	// draining flag is kept although the heartbeat has not reported it yet
+	info := draining.memberInfo()
+	info.Draining = false
+	if _, err := master.cluster.Heartbeat(context.Background(), &clusterpb.HeartbeatRequest{MemberInfo: info}); err != nil {
+		t.Fatal(err)
+	}
+	if members := routableMembers(master.handler.findMembers("AccountComponent")); len(members) != 1 {
+		t.Fatalf("expect 1 routable member, got: %d", len(members))
+	}
+
+	if err := draining.WaitDrained(0, nil); err != ErrDrainTimeout {
+		t.Fatalf("expect drain timeout, got: %v", err)
+	}
+	var reported []int
+	progress := func(count int) {
+		reported = append(reported, count)
+		// the bound session is closed after the first check
+		if count == 1 {
+			draining.SessionClosed(context.Background(), &clusterpb.SessionClosedRequest{SessionId: bound.ID()})
+		}
+	}
+	if err := draining.WaitDrained(3*time.Second, progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 2 || reported[0] != 1 || reported[1] != 0 {
+		t.Fatalf("unexpected progress: %v", reported)
+	}
+
+	// master marks the member as draining
+	if _, err := master.cluster.Drain(context.Background(), &clusterpb.DrainRequest{ServiceAddr: available.ServiceAddr}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, available.Draining)
+	s := session.New(mock.NewNetworkEntity())
+	if _, found := master.handler.remoteAddr("AccountComponent", s, master.handler.findMembers("AccountComponent")); found {
+		t.Fatal("expect no member available")
+	}
+}
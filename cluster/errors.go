@@ -20,11 +20,38 @@
 
 package cluster
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Errors that could be occurred during message handling.
 var (
 	ErrSessionOnNotify    = errors.New("current session working on notify mode")
 	ErrCloseClosedSession = errors.New("close closed session")
 	ErrInvalidRegisterReq = errors.New("invalid register request")
+	ErrNodeNotStarted     = errors.New("current node has not started")
+	ErrClusterMismatch    = errors.New("member does not belong to current cluster")
+	ErrUIDNotFound        = errors.New("uid has not been bound to any session")
+	ErrClosedGroup        = errors.New("group closed")
+	ErrCloseClosedGroup   = errors.New("close closed group")
+	ErrMemberDuplication  = errors.New("member has existed in the group")
+	ErrDrainTimeout       = errors.New("sessions still bound after drain timeout")
+	ErrNoMember           = errors.New("no member available for the target")
+	ErrMultipleTargets    = errors.New("request requires a single target member")
+	ErrInvalidTopic       = errors.New("invalid topic")
+	ErrStaleIncarnation   = errors.New("stale member incarnation")
+	ErrNotForwarded       = errors.New("session is not forwarded by gate")
+	ErrSessionMigrating   = errors.New("session service is being migrated")
+	ErrCircuitOpen        = errors.New("circuit breaker of member is open")
 )
+
+// RemoteError represents the error returned by the handler of remote member
+type RemoteError struct {
+	Route   string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote handler %s error: %s", e.Route, e.Message)
+}
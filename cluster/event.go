@@ -0,0 +1,163 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/scheduler"
+)
+
+type (
+	// MembershipEvent represents a change of cluster membership observed by current node,
+	// it will be one of MemberJoined, MemberLeft, MemberUpdated, MasterLost and MasterRecovered
+	MembershipEvent interface {
+		membershipEvent()
+	}
+
+	// MembershipListener represents a callback which receives the membership events
+	MembershipListener func(event MembershipEvent)
+
+	// MemberJoined represents a member has joined cluster
+	MemberJoined struct {
+		Member *clusterpb.MemberInfo
+	}
+
+	// MemberLeft represents a member has left cluster
+	MemberLeft struct {
+		Member *clusterpb.MemberInfo
+	}
+
+	// MemberUpdated represents the label, services, draining state or metadata of a
+	// member have been changed
+	MemberUpdated struct {
+		Old *clusterpb.MemberInfo
+		New *clusterpb.MemberInfo
+	}
+
+	// MasterLost represents current node cannot send heartbeat to master
+	MasterLost struct {
+		Addr  string
+		Error error
+	}
+
+	// MasterRecovered represents current node sends heartbeat to master successfully
+	// after the master was lost
+	MasterRecovered struct {
+		Addr string
+	}
+)
+
+func (MemberJoined) membershipEvent()    {}
+func (MemberLeft) membershipEvent()      {}
+func (MemberUpdated) membershipEvent()   {}
+func (MasterLost) membershipEvent()      {}
+func (MasterRecovered) membershipEvent() {}
+
+// eventDispatcher delivers the membership events to listeners in a single goroutine,
+// so the events are received in the order they occurred. The events will be queued
+// without blocking the membership changes.
+type eventDispatcher struct {
+	listeners []MembershipListener
+	scheduled bool // deliver events in the scheduler goroutine
+
+	mu     sync.Mutex
+	queue  []MembershipEvent
+	notify chan struct{}
+	die    chan struct{}
+	once   sync.Once
+}
+
+func newEventDispatcher(listeners []MembershipListener, scheduled bool) *eventDispatcher {
+	d := &eventDispatcher{
+		listeners: listeners,
+		scheduled: scheduled,
+		notify:    make(chan struct{}, 1),
+		die:       make(chan struct{}),
+	}
+	if len(listeners) > 0 {
+		go d.run()
+	}
+	return d
+}
+
+func (d *eventDispatcher) emit(event MembershipEvent) {
+	if d == nil || len(d.listeners) == 0 {
+		return
+	}
+	d.mu.Lock()
+	d.queue = append(d.queue, event)
+	d.mu.Unlock()
+
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *eventDispatcher) run() {
+	for {
+		select {
+		case <-d.notify:
+			d.mu.Lock()
+			events := d.queue
+			d.queue = nil
+			d.mu.Unlock()
+			for _, event := range events {
+				d.deliver(event)
+			}
+		case <-d.die:
+			return
+		}
+	}
+}
+
+func (d *eventDispatcher) deliver(event MembershipEvent) {
+	if !d.scheduled {
+		for _, listener := range d.listeners {
+			listener(event)
+		}
+		return
+	}
+	// The tasks of scheduler are executed in pushing order
+	scheduler.PushTask(func() {
+		for _, listener := range d.listeners {
+			listener(event)
+		}
+	})
+}
+
+func (d *eventDispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.once.Do(func() { close(d.die) })
+}
+
+// memberChanged reports whether the label, services, draining state or metadata of
+// member have been changed, the dynamic load is not considered
+func memberChanged(old, new *clusterpb.MemberInfo) bool {
+	return old.Label != new.Label || old.Draining != new.Draining ||
+		!slices.Equal(old.Services, new.Services) || !maps.Equal(old.Metadata, new.Metadata)
+}
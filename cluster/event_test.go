@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+)
+
+func nextEvent(t *testing.T, events chan MembershipEvent) MembershipEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("membership event not received")
+	}
+	return nil
+}
+
+func TestMembershipEventsOrder(t *testing.T) {
+	events := make(chan MembershipEvent, 1024)
+	master := &Node{
+		Options: Options{
+			IsMaster:            true,
+			Components:          &component.Components{},
+			MembershipListeners: []MembershipListener{func(e MembershipEvent) { events <- e }},
+			Listener:            memListen,
+		},
+		ServiceAddr: "events.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	// the member leaves concurrently with joining, the events are delivered in the
+	// order of membership changes
+	const rounds = 100
+	ctx := context.Background()
+	info := &clusterpb.MemberInfo{ServiceAddr: "member", Incarnation: 1}
+	for i := 0; i < rounds; i++ {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := master.cluster.Register(ctx, &clusterpb.RegisterRequest{MemberInfo: info}); err != nil {
+				t.Error(err)
+			}
+		}()
+		_, err := master.cluster.Unregister(ctx, &clusterpb.UnregisterRequest{ServiceAddr: info.ServiceAddr})
+		wg.Wait()
+		if err != nil {
+			if _, err := master.cluster.Unregister(ctx, &clusterpb.UnregisterRequest{ServiceAddr: info.ServiceAddr}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < rounds*2; i++ {
+		switch e := nextEvent(t, events).(type) {
+		case MemberJoined:
+			if i%2 != 0 {
+				t.Fatalf("event %d: unexpected joined", i)
+			}
+		case MemberLeft:
+			if i%2 != 1 {
+				t.Fatalf("event %d: unexpected left", i)
+			}
+		default:
+			t.Fatalf("event %d: unexpected event %#v", i, e)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package cluster_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano"
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/nanotest"
+)
+
+func TestFailureDetection(t *testing.T) {
+	// the crashed member is suspected quickly and removed long after
+	c := nanotest.New(t, nano.WithFailureDetector(2*nanotest.DefaultHeartbeat, 3*time.Second))
+	alive := c.AddBackend("alive", echoComponents("alive"))
+	crashed := c.AddBackend("crashed", echoComponents("crashed"))
+	gate := c.AddGate("gate", nano.WithPeerProbe(nanotest.DefaultHeartbeat))
+	c.WaitConverged()
+
+	// route the session of a new client, returns the node replied
+	route := func() string {
+		client := c.Connect(gate)
+		defer client.Close()
+		if err := client.Send([]byte("ping")); err != nil {
+			return ""
+		}
+		data, err := client.Receive(5 * nanotest.DefaultHeartbeat)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	known := func(n *cluster.Node, addr string) bool {
+		return slices.ContainsFunc(n.Members(), func(m *clusterpb.MemberInfo) bool { return m.ServiceAddr == addr })
+	}
+
+	// new sessions avoid the crashed member once it is suspected, before it is removed
+	c.Kill(crashed)
+	c.WaitFor(func() bool {
+		for i := 0; i < 10; i++ {
+			if route() != alive.ServiceAddr {
+				return false
+			}
+		}
+		return true
+	})
+	if !known(gate, crashed.ServiceAddr) || !known(c.Master(), crashed.ServiceAddr) {
+		t.Fatal("expect suspected member not removed yet")
+	}
+
+	// the suspected member is removed after the dead timeout
+	c.WaitConverged()
+}
@@ -0,0 +1,203 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+)
+
+const (
+	// notifyTimeout is the timeout of each membership notification sent by master
+	notifyTimeout = 3 * time.Second
+	// notifyRetryInterval is the initial delay of retrying the failed notifications,
+	// the delay doubles on each failure up to notifyMaxBackoff
+	notifyRetryInterval = 100 * time.Millisecond
+	notifyMaxBackoff    = 5 * time.Second
+	// maxPendingNotifications bounds the notifications queued for a member, the oldest
+	// one is dropped on overflow and the member will catch up by the membership epoch
+	maxPendingNotifications = 256
+)
+
+// notification is a membership change which master sends to a member
+type notification func(ctx context.Context, client clusterpb.MemberClient) error
+
+func newMemberNotification(req *clusterpb.NewMemberRequest) notification {
+	return func(ctx context.Context, client clusterpb.MemberClient) error {
+		_, err := client.NewMember(ctx, req)
+		return err
+	}
+}
+
+func delMemberNotification(req *clusterpb.DelMemberRequest) notification {
+	return func(ctx context.Context, client clusterpb.MemberClient) error {
+		_, err := client.DelMember(ctx, req)
+		return err
+	}
+}
+
+// retryQueue holds the failed notifications of a member, which are retried in order
+type retryQueue struct {
+	items []notification
+}
+
+// broadcast sends the notification to the members of addrs concurrently and waits
+// for the first attempts, which are bounded by notifyTimeout. The failed notifications
+// are queued and retried in background, so an unreachable member never blocks the
+// membership changes of others.
+func (c *cluster) broadcast(addrs []string, n notification) {
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		// Keep the order of notifications if some of them are waiting for retry
+		if c.enqueueIfPending(addr, n) {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := c.notify(addr, n); err != nil {
+				logger.Logger.Tracef("Notify member[%s] failed and will retry: %v", addr, err)
+				c.enqueue(addr, n)
+			}
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (c *cluster) notify(addr string, n notification) error {
+	pool, err := c.rpcClient.getConnPool(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	return c.currentNode.guard(addr, func() error {
+		return n(ctx, clusterpb.NewMemberClient(pool.Get()))
+	})
+}
+
+func (c *cluster) enqueueIfPending(addr string, n notification) bool {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	q, found := c.retries[addr]
+	if found {
+		q.push(n)
+	}
+	return found
+}
+
+// enqueue queues the notification for retry, and starts retrying if the member
+// has no pending notifications before
+func (c *cluster) enqueue(addr string, n notification) {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	if q, found := c.retries[addr]; found {
+		q.push(n)
+		return
+	}
+	q := &retryQueue{items: []notification{n}}
+	c.retries[addr] = q
+	go c.retry(addr, q)
+}
+
+func (q *retryQueue) push(n notification) {
+	if len(q.items) >= maxPendingNotifications {
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, n)
+}
+
+// retry sends the queued notifications to the member until all of them succeed, the
+// queue will be dropped once the member left cluster
+func (c *cluster) retry(addr string, q *retryQueue) {
+	backoff := notifyRetryInterval
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-c.die:
+			return
+		}
+
+		c.retryMu.Lock()
+		if c.retries[addr] != q {
+			c.retryMu.Unlock()
+			return
+		}
+		head := q.items[0]
+		c.retryMu.Unlock()
+
+		if !c.hasMember(addr) {
+			c.dropRetries(addr)
+			return
+		}
+		if err := c.notify(addr, head); err != nil {
+			logger.Logger.Tracef("Retry notifying member[%s] failed: %v", addr, err)
+			backoff = min(backoff*2, notifyMaxBackoff)
+			continue
+		}
+		backoff = notifyRetryInterval
+
+		c.retryMu.Lock()
+		if c.retries[addr] != q {
+			c.retryMu.Unlock()
+			return
+		}
+		q.items = q.items[1:]
+		if len(q.items) == 0 {
+			delete(c.retries, addr)
+			c.retryMu.Unlock()
+			return
+		}
+		c.retryMu.Unlock()
+	}
+}
+
+// dropRetries drops the notifications queued for the member
+func (c *cluster) dropRetries(addr string) {
+	c.retryMu.Lock()
+	delete(c.retries, addr)
+	c.retryMu.Unlock()
+}
+
+// pendingNotifications returns the count of notifications waiting for retry
+func (c *cluster) pendingNotifications(addr string) int {
+	c.retryMu.Lock()
+	defer c.retryMu.Unlock()
+	if q, found := c.retries[addr]; found {
+		return len(q.items)
+	}
+	return 0
+}
+
+func (c *cluster) hasMember(addr string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, m := range c.members {
+		if m.memberInfo.ServiceAddr == addr {
+			return true
+		}
+	}
+	return false
+}
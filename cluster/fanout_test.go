@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/scheduler"
+	"google.golang.org/grpc"
+)
+
+// recordingMember records the membership notifications received in order
+type recordingMember struct {
+	clusterpb.UnimplementedMemberServer
+
+	mu      sync.Mutex
+	changes []string
+}
+
+func (m *recordingMember) NewMember(_ context.Context, req *clusterpb.NewMemberRequest) (*clusterpb.NewMemberResponse, error) {
+	m.mu.Lock()
+	m.changes = append(m.changes, "new "+req.MemberInfo.ServiceAddr)
+	m.mu.Unlock()
+	return &clusterpb.NewMemberResponse{}, nil
+}
+
+func (m *recordingMember) DelMember(_ context.Context, req *clusterpb.DelMemberRequest) (*clusterpb.DelMemberResponse, error) {
+	m.mu.Lock()
+	m.changes = append(m.changes, "del "+req.ServiceAddr)
+	m.mu.Unlock()
+	return &clusterpb.DelMemberResponse{}, nil
+}
+
+func TestMembershipFanout(t *testing.T) {
+	go scheduler.Sched()
+
+	heartbeat := env.Heartbeat
+	env.Heartbeat = 50 * time.Millisecond
+	defer func() { env.Heartbeat = heartbeat }()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, DeadTimeout: time.Minute, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "fanout.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	// an unreachable member does not block the registration of others
+	ctx := context.Background()
+	unreachable := "fanout.unreachable"
+	if _, err := master.cluster.Register(ctx, &clusterpb.RegisterRequest{MemberInfo: &clusterpb.MemberInfo{ServiceAddr: unreachable}}); err != nil {
+		t.Fatal(err)
+	}
+	member := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "fanout.member",
+	}
+	if err := member.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	member.Shutdown()
+	if n := master.cluster.pendingNotifications(unreachable); n != 2 {
+		t.Fatalf("expect 2 pending notifications, got: %d", n)
+	}
+
+	// the pending notifications are delivered in order once the member is reachable
+	listener, err := memListen(unreachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingMember{}
+	server := grpc.NewServer()
+	clusterpb.RegisterMemberServer(server, recorder)
+	go server.Serve(listener)
+	defer server.Stop()
+	waitFor(t, func() bool { return master.cluster.pendingNotifications(unreachable) == 0 })
+	recorder.mu.Lock()
+	changes := fmt.Sprint(recorder.changes)
+	recorder.mu.Unlock()
+	if want := fmt.Sprintf("[new %s del %s]", member.ServiceAddr, member.ServiceAddr); changes != want {
+		t.Fatalf("expect %s, got: %s", want, changes)
+	}
+
+	// concurrent membership changes with unreachable members
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info := &clusterpb.MemberInfo{ServiceAddr: fmt.Sprintf("fanout.unreachable%d", i), Incarnation: uint64(i + 1)}
+			if _, err := master.cluster.Register(ctx, &clusterpb.RegisterRequest{MemberInfo: info}); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := master.cluster.Heartbeat(ctx, &clusterpb.HeartbeatRequest{MemberInfo: info}); err != nil {
+				t.Error(err)
+				return
+			}
+			req := &clusterpb.UnregisterRequest{ServiceAddr: info.ServiceAddr, Incarnation: info.Incarnation}
+			if _, err := master.cluster.Unregister(ctx, req); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if infos := master.cluster.memberInfos(); len(infos) != 2 {
+		t.Fatalf("expect master and recorder left, got: %v", infos)
+	}
+	waitFor(t, func() bool {
+		for i := 0; i < 8; i++ {
+			if master.cluster.pendingNotifications(fmt.Sprintf("fanout.unreachable%d", i)) > 0 {
+				return false
+			}
+		}
+		return master.cluster.pendingNotifications(unreachable) == 0
+	})
+}
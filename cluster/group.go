@@ -0,0 +1,316 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/session"
+)
+
+// groups contains all working groups in current process, the members of them will be
+// removed once the sessions closed or the gates left
+var groups sync.Map // *Group -> struct{}
+
+// Group represents a session group spanning multiple gates. The members are tracked by
+// the gate address and session ID, or by the UID which will be located in the UID
+// directory when broadcasting. A message sent to the group is serialized once, and
+// pushed to each gate in one batch, then the gate pushes it to the member sessions.
+type Group struct {
+	name string
+	node *Node // nil means the node started most recently
+
+	mu       sync.RWMutex
+	closed   bool
+	sessions map[sessionKey]int64 // gate session -> UID bound when added
+	uids     map[int64]struct{}
+}
+
+// NewGroup returns a new group which sends messages by the node started most recently
+// in current process
+func NewGroup(name string) *Group {
+	return newGroup(name, nil)
+}
+
+// NewGroup returns a new group which sends messages by current node
+func (n *Node) NewGroup(name string) *Group {
+	return newGroup(name, n)
+}
+
+func newGroup(name string, n *Node) *Group {
+	g := &Group{
+		name:     name,
+		node:     n,
+		sessions: map[sessionKey]int64{},
+		uids:     map[int64]struct{}{},
+	}
+	groups.Store(g, struct{}{})
+	return g
+}
+
+// Name returns the group name
+func (g *Group) Name() string {
+	return g.name
+}
+
+func (g *Group) currentNode() (*Node, error) {
+	if g.node != nil {
+		return g.node, nil
+	}
+	if n := defaultNode.Load(); n != nil {
+		return n, nil
+	}
+	return nil, ErrNodeNotStarted
+}
+
+// Add adds the session to group, the session can be a gate session or a backend
+// session created for the session in gate
+func (g *Group) Add(s *session.Session) error {
+	n, err := g.currentNode()
+	if err != nil {
+		return err
+	}
+	gateAddr, sid := n.handler.sessionOrigin(s)
+	key := sessionKey{gateAddr: gateAddr, sid: sid}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosedGroup
+	}
+	if _, found := g.sessions[key]; found {
+		return ErrMemberDuplication
+	}
+	g.sessions[key] = s.UID()
+	return nil
+}
+
+// AddUID adds the UID to group, the message will be pushed to the session which the
+// UID bound to at the time of sending, see Options.UIDDirectory
+func (g *Group) AddUID(uid int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosedGroup
+	}
+	if _, found := g.uids[uid]; found {
+		return ErrMemberDuplication
+	}
+	g.uids[uid] = struct{}{}
+	return nil
+}
+
+// Leave removes the session from group
+func (g *Group) Leave(s *session.Session) error {
+	n, err := g.currentNode()
+	if err != nil {
+		return err
+	}
+	gateAddr, sid := n.handler.sessionOrigin(s)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosedGroup
+	}
+	delete(g.sessions, sessionKey{gateAddr: gateAddr, sid: sid})
+	return nil
+}
+
+// LeaveUID removes the UID from group
+func (g *Group) LeaveUID(uid int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosedGroup
+	}
+	delete(g.uids, uid)
+	return nil
+}
+
+// LeaveAll removes all members from group
+func (g *Group) LeaveAll() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosedGroup
+	}
+	g.sessions = map[sessionKey]int64{}
+	g.uids = map[int64]struct{}{}
+	return nil
+}
+
+// Contains reports whether the UID is a member, or bound to a member session
+func (g *Group) Contains(uid int64) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if _, found := g.uids[uid]; found {
+		return true
+	}
+	for _, bound := range g.sessions {
+		if bound == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the amount of the member sessions and UIDs
+func (g *Group) Count() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return len(g.sessions) + len(g.uids)
+}
+
+// Broadcast pushes the message to all members, the UIDs not bound to any session will
+// be ignored. The first error of sending to gates will be returned after the message
+// has been sent to all gates.
+func (g *Group) Broadcast(route string, v interface{}) error {
+	n, err := g.currentNode()
+	if err != nil {
+		return err
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	g.mu.RLock()
+	if g.closed {
+		g.mu.RUnlock()
+		return ErrClosedGroup
+	}
+	targets := make(map[sessionKey]struct{}, len(g.sessions)+len(g.uids))
+	for key := range g.sessions {
+		targets[key] = struct{}{}
+	}
+	uids := make([]int64, 0, len(g.uids))
+	for uid := range g.uids {
+		uids = append(uids, uid)
+	}
+	g.mu.RUnlock()
+
+	if len(uids) > 0 {
+		entries, err := n.lookupUIDs(uids)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			targets[sessionKey{gateAddr: entry.GateAddr, sid: entry.SessionId}] = struct{}{}
+		}
+	}
+
+	batches := map[string][]int64{}
+	for key := range targets {
+		batches[key.gateAddr] = append(batches[key.gateAddr], key.sid)
+	}
+	var first error
+	for gateAddr, sids := range batches {
+		request := &clusterpb.GroupPushMessage{Route: route, Data: data, SessionIds: sids}
+		if err := n.groupPush(gateAddr, request); err != nil {
+			logger.Logger.Tracef("Group %s push to gate[%s] failed: %v", g.name, gateAddr, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Close closes the group and releases all members
+func (g *Group) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrCloseClosedGroup
+	}
+	g.closed = true
+	g.sessions = map[sessionKey]int64{}
+	g.uids = map[int64]struct{}{}
+	groups.Delete(g)
+	return nil
+}
+
+// removeSession removes the closed session, and the UID bound to it
+func (g *Group) removeSession(gateAddr string, sid, uid int64) {
+	g.mu.Lock()
+	delete(g.sessions, sessionKey{gateAddr: gateAddr, sid: sid})
+	if uid > 0 {
+		delete(g.uids, uid)
+	}
+	g.mu.Unlock()
+}
+
+// removeGate removes the sessions of the gate left cluster
+func (g *Group) removeGate(addr string) {
+	g.mu.Lock()
+	for key := range g.sessions {
+		if key.gateAddr == addr {
+			delete(g.sessions, key)
+		}
+	}
+	g.mu.Unlock()
+}
+
+// leaveGroups removes the closed session from all groups
+func leaveGroups(gateAddr string, sid, uid int64) {
+	groups.Range(func(g, _ interface{}) bool {
+		g.(*Group).removeSession(gateAddr, sid, uid)
+		return true
+	})
+}
+
+// leaveGroupsOfGate removes the sessions of the gate left cluster from all groups
+func leaveGroupsOfGate(addr string) {
+	groups.Range(func(g, _ interface{}) bool {
+		g.(*Group).removeGate(addr)
+		return true
+	})
+}
+
+func (n *Node) groupPush(gateAddr string, request *clusterpb.GroupPushMessage) error {
+	if gateAddr == n.ServiceAddr {
+		_, err := n.HandleGroupPush(context.Background(), request)
+		return err
+	}
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_GroupPush{GroupPush: request}}
+	return n.transport.send(context.Background(), gateAddr, m)
+}
+
+// HandleGroupPush implements the MemberServer interface, it pushes the message to the
+// sessions in current gate, the closed sessions will be ignored
+func (n *Node) HandleGroupPush(_ context.Context, req *clusterpb.GroupPushMessage) (*clusterpb.MemberHandleResponse, error) {
+	for _, sid := range req.SessionIds {
+		s := n.findSession(sid)
+		if s == nil {
+			continue
+		}
+		if err := s.Push(req.Route, req.Data); err != nil {
+			logger.Logger.Tracef("Session push message error, ID=%d, UID=%d, Error=%v", s.ID(), s.UID(), err)
+		}
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
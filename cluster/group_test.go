@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+)
+
+func TestGroup(t *testing.T) {
+	go scheduler.Sched()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "group.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	gate := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, UIDDirectory: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "group.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &Node{
+		Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "group.backend",
+	}
+	if err := backend.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Shutdown()
+
+	first, firstRoutes := newRecordedSession(gate)
+	second, secondRoutes := newRecordedSession(gate)
+	local, localRoutes := newRecordedSession(master)
+	local.Bind(8)
+
+	g := backend.NewGroup("room")
+	for _, s := range []int64{first.ID(), second.ID()} {
+		s, err := backend.findOrCreateSession(s, gate.ServiceAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.AddUID(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddUID(8); err != ErrMemberDuplication {
+		t.Fatalf("expect member duplication, got: %v", err)
+	}
+	if !g.Contains(8) || g.Count() != 3 {
+		t.Fatalf("unexpected members count: %d", g.Count())
+	}
+
+	if err := g.Broadcast("onNews", &testdata.Pong{Content: "news"}); err != nil {
+		t.Fatal(err)
+	}
+	expectRoute(t, firstRoutes, "onNews")
+	expectRoute(t, secondRoutes, "onNews")
+	expectRoute(t, localRoutes, "onNews")
+
+	// closed sessions are removed
+	request := &clusterpb.SessionClosedRequest{SessionId: first.ID(), GateAddr: gate.ServiceAddr}
+	if _, err := backend.SessionClosed(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	request = &clusterpb.SessionClosedRequest{SessionId: local.ID(), GateAddr: master.ServiceAddr, Uid: 8}
+	if _, err := backend.SessionClosed(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if g.Contains(8) || g.Count() != 1 {
+		t.Fatalf("unexpected members count: %d", g.Count())
+	}
+
+	// sessions of the left gate are removed
+	gate.Shutdown()
+	waitFor(t, func() bool { return g.Count() == 0 })
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddUID(8); err != ErrClosedGroup {
+		t.Fatalf("expect closed group, got: %v", err)
+	}
+}
@@ -24,12 +24,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"net"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/acoderup/core/logger"
@@ -51,8 +52,12 @@ var (
 	hbd []byte // heartbeat packet data
 )
 
+var defaultRoute = RandomRoute()
+
 type rpcHandler func(session *session.Session, msg *message.Message, noCopy bool)
 
+type callHandler func(ctx context.Context, session *session.Session, route string, v interface{}, reply interface{}) error
+
 // CustomerRemoteServiceRoute customer remote service route
 type CustomerRemoteServiceRoute func(service string, session *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo
 
@@ -99,18 +104,23 @@ func cache() {
 type LocalHandler struct {
 	localServices map[string]*component.Service // all registered service
 	localHandlers map[string]*component.Handler // all handler method
+	singletons    map[string]*singleton         // singleton components of services
 
 	mu             sync.RWMutex
 	remoteServices map[string][]*clusterpb.MemberInfo
 
 	pipeline    pipeline.Pipeline
 	currentNode *Node
+
+	timeoutMu sync.Mutex
+	timeouts  map[string]uint64 // count of the requests not responded in time of each route
 }
 
 func NewHandler(currentNode *Node, pipeline pipeline.Pipeline) *LocalHandler {
 	h := &LocalHandler{
 		localServices:  make(map[string]*component.Service),
 		localHandlers:  make(map[string]*component.Handler),
+		singletons:     make(map[string]*singleton),
 		remoteServices: map[string][]*clusterpb.MemberInfo{},
 		pipeline:       pipeline,
 		currentNode:    currentNode,
@@ -132,6 +142,9 @@ func (h *LocalHandler) register(comp component.Component, opts []component.Optio
 
 	// register all localHandlers
 	h.localServices[s.Name] = s
+	if s.Singleton {
+		h.singletons[s.Name] = &singleton{comp: comp}
+	}
 	for name, handler := range s.Handlers {
 		n := fmt.Sprintf("%s.%s", s.Name, name)
 		logger.Logger.Tracef("Register local handler[%v]", n)
@@ -178,9 +191,49 @@ func (h *LocalHandler) delMember(addr string) {
 	}
 }
 
+// updateMember replaces the member information which has the same service address,
+// the old information will not be modified because route strategies may be reading it
+func (h *LocalHandler) updateMember(member *clusterpb.MemberInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, members := range h.remoteServices {
+		var updated []*clusterpb.MemberInfo
+		for i, m := range members {
+			if m.ServiceAddr != member.ServiceAddr {
+				continue
+			}
+			if updated == nil {
+				updated = make([]*clusterpb.MemberInfo, len(members))
+				copy(updated, members)
+			}
+			updated[i] = member
+		}
+		if updated != nil {
+			h.remoteServices[name] = updated
+		}
+	}
+}
+
+// replaceMember replaces the information of the known member, the remote services will
+// be registered again if the services of member have been changed
+func (h *LocalHandler) replaceMember(old, member *clusterpb.MemberInfo) {
+	if slices.Equal(old.Services, member.Services) {
+		h.updateMember(member)
+		return
+	}
+	h.delMember(old.ServiceAddr)
+	h.addRemoteService(member)
+}
+
+// LocalService returns the services provided by current node, the singleton services
+// are included only if current node owns them
 func (h *LocalHandler) LocalService() []string {
 	var result []string
 	for service := range h.localServices {
+		if s := h.singletons[service]; s != nil && !s.running.Load() {
+			continue
+		}
 		result = append(result, service)
 	}
 	sort.Strings(result)
@@ -201,8 +254,10 @@ func (h *LocalHandler) RemoteService() []string {
 
 func (h *LocalHandler) handle(conn net.Conn, ip, userAgent string) {
 	// create a client agent and startup write gorontine
-	agent := newAgent(conn, ip, userAgent, h.pipeline, h.remoteProcess)
+	agent := newAgent(conn, ip, userAgent, h.pipeline, h.remoteProcess, h.call)
+	agent.idleTimeout = h.currentNode.SessionIdleTimeout
 	h.currentNode.storeSession(agent.session)
+	h.currentNode.trackSession(agent.session)
 
 	// startup write goroutine
 	go agent.write()
@@ -215,26 +270,13 @@ func (h *LocalHandler) handle(conn net.Conn, ip, userAgent string) {
 	defer func() {
 		request := &clusterpb.SessionClosedRequest{
 			SessionId: agent.session.ID(),
+			GateAddr:  h.currentNode.ServiceAddr,
+			Uid:       agent.session.UID(),
 		}
+		leaveGroups(request.GateAddr, request.SessionId, request.Uid)
 
-		members := h.currentNode.cluster.remoteAddrs()
-		for _, remote := range members {
-			logger.Logger.Tracef("Notify remote server[%v]", remote)
-			pool, err := h.currentNode.rpcClient.getConnPool(remote)
-			if err != nil {
-				logger.Logger.Tracef("Cannot retrieve connection pool for address[%v] err[%v]", remote, err)
-				continue
-			}
-			client := clusterpb.NewMemberClient(pool.Get())
-			_, err = client.SessionClosed(context.Background(), request)
-			if err != nil {
-				logger.Logger.Tracef("Cannot closed session in remote address[%v] err[%v]", remote, err)
-				continue
-			}
-			if env.Debug {
-				logger.Logger.Tracef("Notify remote server success[%v]", remote)
-			}
-		}
+		h.currentNode.untrackSession(agent.session)
+		h.currentNode.notifySessionClosed(agent.session, request)
 
 		agent.Close()
 		if env.Debug {
@@ -317,76 +359,127 @@ func (h *LocalHandler) processPacket(agent *agent, p *packet.Packet) error {
 		// expected
 	}
 
-	agent.lastAt = time.Now().Unix()
+	atomic.StoreInt64(&agent.lastAt, time.Now().Unix())
 	return nil
 }
 
+// localHandler returns the handler of route registered in current node, the handlers
+// of singleton services are available only if current node owns them
+func (h *LocalHandler) localHandler(route string) (*component.Handler, bool) {
+	handler, found := h.localHandlers[route]
+	if !found || len(h.singletons) == 0 {
+		return handler, found
+	}
+	if s := h.singletons[route[:strings.LastIndex(route, ".")]]; s != nil && !s.active.Load() {
+		return nil, false
+	}
+	return handler, true
+}
+
 func (h *LocalHandler) findMembers(service string) []*clusterpb.MemberInfo {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	return h.remoteServices[service]
 }
 
+// routeStrategy returns the route strategy of the service, the strategy specified
+// for the service takes precedence over the customer remote service route, and
+// select member randomly if neither of them specified.
+func (h *LocalHandler) routeStrategy(service string) CustomerRemoteServiceRoute {
+	if route, found := h.currentNode.Options.ServiceRoutes[service]; found && route != nil {
+		return route
+	}
+	if h.currentNode.Options.RemoteServiceRoute != nil {
+		return h.currentNode.Options.RemoteServiceRoute
+	}
+	return defaultRoute
+}
+
+// remoteAddr returns the member address which the messages of service should be forwarded to
+// 1. Use the service address directly if the router contains binding item, the service
+// which is not pinned is rerouted if the circuit breaker of the member is open
+// 2. Select a remote service address by the route strategy of the service and bind to router,
+// the members suspected by current node or with open circuit breakers are skipped unless
+// all members are unavailable
+func (h *LocalHandler) remoteAddr(service string, session *session.Session, members []*clusterpb.MemberInfo) (string, bool) {
+	if addr, found := session.Router().Find(service); found {
+		return h.reroute(service, session, addr, members), true
+	}
+	members = h.currentNode.availableMembers(h.currentNode.cluster.aliveMembers(routableMembers(members)))
+	if len(members) == 0 {
+		return "", false
+	}
+	member := h.routeStrategy(service)(service, session, members)
+	if member == nil {
+		return "", false
+	}
+	session.Router().Bind(service, member.ServiceAddr)
+	return member.ServiceAddr, true
+}
+
+// sessionOrigin retrieves the gate address and the session id in gate
+func (h *LocalHandler) sessionOrigin(session *session.Session) (string, int64) {
+	if v, ok := session.NetworkEntity().(*acceptor); ok {
+		return v.gateAddr, v.sid
+	}
+	return h.currentNode.ServiceAddr, session.ID()
+}
+
 func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Message, noCopy bool) {
 	index := strings.LastIndex(msg.Route, ".")
 	if index < 0 {
-		logger.Logger.Tracef(fmt.Sprintf("nano/handler: invalid route %s", msg.Route))
+		err := fmt.Errorf("nano/handler: invalid route %s", msg.Route)
+		logger.Logger.Tracef(err.Error())
+		h.failRequest(session, msg, ErrorCodeNotFound, err)
 		return
 	}
 
 	service := msg.Route[:index]
+	if a, ok := session.NetworkEntity().(*agent); ok {
+		h.trackRequest(a, msg)
+		if a.holds.hold(service, msg) {
+			return
+		}
+	}
+	h.forward(session, service, msg, noCopy)
+}
+
+// forward forwards the message of session to the member provides the service
+func (h *LocalHandler) forward(session *session.Session, service string, msg *message.Message, noCopy bool) {
 	members := h.findMembers(service)
 	if len(members) == 0 {
-		logger.Logger.Tracef(fmt.Sprintf("nano/handler: %s not found(forgot registered?)", msg.Route))
+		err := fmt.Errorf("nano/handler: %s not found(forgot registered?)", msg.Route)
+		logger.Logger.Tracef(err.Error())
+		h.failRequest(session, msg, ErrorCodeNotFound, err)
 		return
 	}
 
-	// Select a remote service address
-	// 1. if exist customer remote service route ,use it, otherwise use default strategy
-	// 2. Use the service address directly if the router contains binding item
-	// 3. Select a remote service address randomly and bind to router
-	var remoteAddr string
-	if h.currentNode.Options.RemoteServiceRoute != nil {
-		if addr, found := session.Router().Find(service); found {
-			remoteAddr = addr
-		} else {
-			member := h.currentNode.Options.RemoteServiceRoute(service, session, members)
-			if member == nil {
-				logger.Logger.Tracef(fmt.Sprintf("customize remoteServiceRoute handler: %s is not found", msg.Route))
-				return
-			}
-			remoteAddr = member.ServiceAddr
-			session.Router().Bind(service, remoteAddr)
-		}
-	} else {
-		if addr, found := session.Router().Find(service); found {
-			remoteAddr = addr
-		} else {
-			remoteAddr = members[rand.Intn(len(members))].ServiceAddr
-			session.Router().Bind(service, remoteAddr)
-		}
-	}
-	pool, err := h.currentNode.rpcClient.getConnPool(remoteAddr)
-	if err != nil {
-		logger.Logger.Trace(err)
+	remoteAddr, found := h.remoteAddr(service, session, members)
+	if !found {
+		err := fmt.Errorf("customize remoteServiceRoute handler: %s is not found", msg.Route)
+		logger.Logger.Tracef(err.Error())
+		h.failRequest(session, msg, ErrorCodeUnavailable, err)
 		return
 	}
+	touchSession(session, remoteAddr)
 	var data = msg.Data
 	if !noCopy && len(msg.Data) > 0 {
 		data = make([]byte, len(msg.Data))
 		copy(data, msg.Data)
 	}
 
-	// Retrieve gate address and session id
-	gateAddr := h.currentNode.ServiceAddr
-	sessionId := session.ID()
-	switch v := session.NetworkEntity().(type) {
-	case *acceptor:
-		gateAddr = v.gateAddr
-		sessionId = v.sid
+	var deadline int64
+	ctx := context.Background()
+	if !msg.Deadline.IsZero() {
+		deadline = msg.Deadline.UnixNano()
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, msg.Deadline)
+		defer cancel()
 	}
 
-	client := clusterpb.NewMemberClient(pool.Get())
+	gateAddr, sessionId := h.sessionOrigin(session)
+	sessionData := h.sessionData(session, remoteAddr)
+	var m *clusterpb.StreamMessage
 	switch msg.Type {
 	case message.Request:
 		request := &clusterpb.RequestMessage{
@@ -395,19 +488,38 @@ func (h *LocalHandler) remoteProcess(session *session.Session, msg *message.Mess
 			Id:        msg.ID,
 			Route:     msg.Route,
 			Data:      data,
+			Metadata:  msg.Metadata,
+			Deadline:  deadline,
+			Session:   sessionData,
 		}
-		_, err = client.HandleRequest(context.Background(), request)
+		m = &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Request{Request: request}}
 	case message.Notify:
 		request := &clusterpb.NotifyMessage{
 			GateAddr:  gateAddr,
 			SessionId: sessionId,
 			Route:     msg.Route,
 			Data:      data,
+			Metadata:  msg.Metadata,
+			Deadline:  deadline,
+			Session:   sessionData,
 		}
-		_, err = client.HandleNotify(context.Background(), request)
+		m = &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Notify{Notify: request}}
+	default:
+		logger.Logger.Tracef("Invalid message type: " + msg.Type.String())
+		return
+	}
+	var err error
+	if a, ok := session.NetworkEntity().(*agent); ok && msg.Type == message.Request {
+		err = h.currentNode.transport.sendRequest(ctx, a, msg.ID, remoteAddr, m)
+	} else {
+		err = h.currentNode.transport.send(ctx, remoteAddr, m)
 	}
 	if err != nil {
+		if sessionData != nil {
+			forgetSessionData(session, remoteAddr)
+		}
 		logger.Logger.Tracef(fmt.Sprintf("Process remote message (%d:%s) error: %+v", msg.ID, msg.Route, err))
+		h.failRequest(session, msg, ErrorCodeUnavailable, err)
 	}
 }
 
@@ -423,8 +535,18 @@ func (h *LocalHandler) processMessage(agent *agent, msg *message.Message) {
 		return
 	}
 
-	handler, found := h.localHandlers[msg.Route]
+	handler, found := h.localHandler(msg.Route)
 	if !found {
+		// The gate pipeline processes the message before forwarding, the metadata set
+		// by pipeline functions will be forwarded along with the message. The inbound
+		// pipeline is not run here, since backends run it before handling the message.
+		if pipe := h.currentNode.GatePipeline; pipe != nil {
+			err := pipe.Inbound().Process(agent.session, msg)
+			if err != nil {
+				logger.Logger.Tracef("Pipeline process failed: " + err.Error())
+				return
+			}
+		}
 		h.remoteProcess(agent.session, msg, false)
 	} else {
 		h.localProcess(handler, lastMid, agent.session, msg)
@@ -449,25 +571,19 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 		}
 	}
 
-	var payload = msg.Data
-	var data interface{}
-	if handler.IsRawArg {
-		data = payload
-	} else {
-		data = reflect.New(handler.Type.Elem()).Interface()
-		err := env.Serializer.Unmarshal(payload, data)
-		if err != nil {
-			logger.Logger.Tracef(fmt.Sprintf("Deserialize to %T failed: %+v (%v)", data, err, payload))
-			return
-		}
+	data, err := decodeArg(handler, msg.Data)
+	if err != nil {
+		logger.Logger.Tracef(err.Error())
+		return
 	}
 
 	if env.Debug {
 		logger.Logger.Tracef(fmt.Sprintf("UID=%d, Message={%s}, Data=%+v", session.UID(), msg.String(), data))
 	}
 
-	args := []reflect.Value{handler.Receiver, reflect.ValueOf(session), reflect.ValueOf(data)}
-	task := func() {
+	ctx, cancel := messageContext(msg)
+	task := func(ctx context.Context) {
+		defer cancel()
 		switch v := session.NetworkEntity().(type) {
 		case *agent:
 			v.lastMid = lastMid
@@ -475,37 +591,118 @@ func (h *LocalHandler) localProcess(handler *component.Handler, lastMid uint64,
 			v.lastMid = lastMid
 		}
 
-		result := handler.Method.Func.Call(args)
-		if len(result) > 0 {
-			if err := result[0].Interface(); err != nil {
-				logger.Logger.Tracef(fmt.Sprintf("Service %s error: %+v", msg.Route, err))
+		result := handler.Method.Func.Call(handlerArgs(handler, ctx, session, data))
+		if err := result[len(result)-1].Interface(); err != nil {
+			logger.Logger.Tracef(fmt.Sprintf("Service %s error: %+v", msg.Route, err))
+			return
+		}
+
+		// Response the reply to client if the handler returns a reply, the notifies
+		// are never responded
+		if handler.HasReply && msg.Type == message.Request && !result[0].IsNil() {
+			reply, err := message.Serialize(result[0].Interface())
+			if err != nil {
+				logger.Logger.Tracef(fmt.Sprintf("Service %s serialize reply error: %+v", msg.Route, err))
+				return
+			}
+			if err := session.Response(reply); err != nil {
+				logger.Logger.Tracef(fmt.Sprintf("Service %s response error: %+v", msg.Route, err))
 			}
 		}
 	}
 
-	index := strings.LastIndex(msg.Route, ".")
-	if index < 0 {
-		logger.Logger.Tracef(fmt.Sprintf("nano/handler: invalid route %s", msg.Route))
-		return
+	if err := h.scheduleHandler(ctx, msg.Route, session, task); err != nil {
+		cancel()
+		logger.Logger.Tracef(err.Error())
 	}
+}
 
-	// A message can be dispatch to global thread or a user customized thread
-	service := msg.Route[:index]
-	if s, found := h.localServices[service]; found && s.SchedName != "" {
-		sched := session.Value(s.SchedName)
-		if sched == nil {
-			logger.Logger.Tracef(fmt.Sprintf("nanl/handler: cannot found `schedular.LocalScheduler` by %s", s.SchedName))
-			return
-		}
+// messageContext returns the context carries the metadata and deadline of message
+func messageContext(msg *message.Message) (context.Context, context.CancelFunc) {
+	ctx := session.WithMetadata(context.Background(), msg.Metadata)
+	if msg.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, msg.Deadline)
+}
 
-		local, ok := sched.(scheduler.LocalScheduler)
-		if !ok {
-			logger.Logger.Tracef(fmt.Sprintf("nanl/handler: Type %T does not implement the `schedular.LocalScheduler` interface",
-				sched))
-			return
-		}
-		local.Schedule(task)
+// handlerArgs returns the arguments to call the handler method
+func handlerArgs(handler *component.Handler, ctx context.Context, s *session.Session, data interface{}) []reflect.Value {
+	if handler.HasContext {
+		return []reflect.Value{handler.Receiver, reflect.ValueOf(ctx), reflect.ValueOf(s), reflect.ValueOf(data)}
+	}
+	return []reflect.Value{handler.Receiver, reflect.ValueOf(s), reflect.ValueOf(data)}
+}
+
+// schedule dispatches the task of route to global thread or a user customized thread
+func (h *LocalHandler) schedule(route string, session *session.Session, task scheduler.Task) error {
+	sched, err := h.localScheduler(route, session)
+	if err != nil {
+		return err
+	}
+	if sched != nil {
+		sched.Schedule(task)
 	} else {
 		scheduler.PushTask(task)
 	}
+	return nil
+}
+
+// scheduleHandler dispatches the task invoking a handler of route like schedule, the
+// context passed to task is marked as running in the scheduler, so the calls made by
+// the handler with it will not wait for the scheduler, see scheduler.Scheduling
+func (h *LocalHandler) scheduleHandler(ctx context.Context, route string, session *session.Session, task func(ctx context.Context)) error {
+	sched, err := h.localScheduler(route, session)
+	if err != nil {
+		return err
+	}
+	return h.schedule(route, session, func() {
+		ctx, done := scheduler.WithScheduling(ctx, sched)
+		defer done()
+		task(ctx)
+	})
+}
+
+// localScheduler returns the user customized thread of route, nil if the tasks of route
+// are dispatched to global thread
+func (h *LocalHandler) localScheduler(route string, session *session.Session) (scheduler.LocalScheduler, error) {
+	index := strings.LastIndex(route, ".")
+	if index < 0 {
+		return nil, fmt.Errorf("nano/handler: invalid route %s", route)
+	}
+
+	service := route[:index]
+	if h.globalScheduled(service, session) {
+		return nil, nil
+	}
+	s := h.localServices[service]
+	sched := session.Value(s.SchedName)
+	if sched == nil {
+		return nil, fmt.Errorf("nanl/handler: cannot found `schedular.LocalScheduler` by %s", s.SchedName)
+	}
+
+	local, ok := sched.(scheduler.LocalScheduler)
+	if !ok {
+		return nil, fmt.Errorf("nanl/handler: Type %T does not implement the `schedular.LocalScheduler` interface",
+			sched)
+	}
+	return local, nil
+}
+
+// globalScheduled reports whether the tasks of service are dispatched to global thread
+func (h *LocalHandler) globalScheduled(service string, session *session.Session) bool {
+	s, found := h.localServices[service]
+	return !found || s.SchedName == "" || session == nil
+}
+
+// decodeArg deserializes the payload to the argument type of handler
+func decodeArg(handler *component.Handler, payload []byte) (interface{}, error) {
+	if handler.IsRawArg {
+		return payload, nil
+	}
+	data := reflect.New(handler.Type.Elem()).Interface()
+	if err := env.Serializer.Unmarshal(payload, data); err != nil {
+		return nil, fmt.Errorf("Deserialize to %T failed: %+v (%v)", data, err, payload)
+	}
+	return data, nil
 }
@@ -0,0 +1,165 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/acoderup/core/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// incarnationKey is the metadata key of the incarnation and service address of caller,
+// the value is formatted as incarnation@address
+const incarnationKey = "nano-incarnation"
+
+// maxFencedIncarnations is the count of the stale incarnations recorded for each address
+const maxFencedIncarnations = 8
+
+// Incarnation returns the incarnation of current node, which is a random id generated
+// for each start of the node. The master rejects the registration and heartbeat of
+// stale incarnations, and members reject the calls from them, so a crashed process
+// cannot resurrect and two processes cannot share a service address.
+func (n *Node) Incarnation() uint64 {
+	return n.incarnation.Load()
+}
+
+// newIncarnation generates a random non-zero incarnation, the incarnations are compared
+// by equality only, so a clock stepping back cannot make a new process stale
+func newIncarnation() uint64 {
+	for {
+		if incarnation := rand.Uint64(); incarnation != 0 {
+			return incarnation
+		}
+	}
+}
+
+// fence records the incarnation of addr as stale, it should be called with mu held
+func (c *cluster) fence(addr string, incarnation uint64) {
+	if incarnation == 0 || slices.Contains(c.fenced[addr], incarnation) {
+		return
+	}
+	fenced := append(c.fenced[addr], incarnation)
+	if len(fenced) > maxFencedIncarnations {
+		fenced = fenced[1:]
+	}
+	c.fenced[addr] = fenced
+}
+
+// stale reports whether the incarnation of addr has been fenced, the zero incarnation
+// is never stale. It should be called with mu held.
+func (c *cluster) stale(addr string, incarnation uint64) bool {
+	return incarnation != 0 && slices.Contains(c.fenced[addr], incarnation)
+}
+
+// superseded reports whether the member of addr has registered with an incarnation
+// other than incarnation, it should be called with mu held
+func (c *cluster) superseded(addr string, incarnation uint64) bool {
+	if incarnation == 0 {
+		return false
+	}
+	for _, m := range c.members {
+		if m.memberInfo.ServiceAddr == addr {
+			return m.memberInfo.Incarnation != 0 && m.memberInfo.Incarnation != incarnation
+		}
+	}
+	return false
+}
+
+func (c *cluster) isStale(addr string, incarnation uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stale(addr, incarnation)
+}
+
+// removable reports whether the member of addr can be removed by the request of the
+// incarnation, the incarnation will be fenced only if another incarnation is known
+func (c *cluster) removable(addr string, incarnation uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.members {
+		if m.memberInfo.ServiceAddr == addr && incarnation != 0 && m.memberInfo.Incarnation != incarnation {
+			c.fence(addr, incarnation)
+			return false
+		}
+	}
+	return true
+}
+
+// incarnationOptions returns the interceptors which attach the incarnation of current
+// node to the outgoing calls and reject the calls from stale incarnations
+func (n *Node) incarnationOptions() ([]grpc.ServerOption, []grpc.DialOption) {
+	// The incarnation is generated again if it has been fenced while registering
+	value := func() string {
+		return strconv.FormatUint(n.Incarnation(), 10) + "@" + n.ServiceAddr
+	}
+	unaryClient := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, incarnationKey, value())
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	streamClient := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, incarnationKey, value())
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	unaryServer := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := n.checkIncarnation(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	streamServer := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := n.checkIncarnation(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unaryServer), grpc.ChainStreamInterceptor(streamServer)},
+		[]grpc.DialOption{grpc.WithChainUnaryInterceptor(unaryClient), grpc.WithChainStreamInterceptor(streamClient)}
+}
+
+// checkIncarnation rejects the incoming call from a stale incarnation
+func (n *Node) checkIncarnation(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(incarnationKey)
+	if len(values) == 0 {
+		return nil
+	}
+	value, addr, ok := strings.Cut(values[0], "@")
+	if !ok {
+		return nil
+	}
+	incarnation, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	if n.cluster.isStale(addr, incarnation) {
+		logger.Logger.Tracef("Reject the call from stale incarnation [%d] of [%s]", incarnation, addr)
+		return status.Error(codes.FailedPrecondition, ErrStaleIncarnation.Error())
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/scheduler"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestIncarnation(t *testing.T) {
+	go scheduler.Sched()
+
+	heartbeat := env.Heartbeat
+	env.Heartbeat = 50 * time.Millisecond
+	defer func() { env.Heartbeat = heartbeat }()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "incarnation.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	startMember := func(addr string) *Node {
+		comps := &component.Components{}
+		comps.Register(&AccountComponent{})
+		member := &Node{
+			Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := member.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+	incarnation := func(n *Node, addr string) uint64 {
+		for _, info := range n.cluster.memberInfos() {
+			if info.ServiceAddr == addr {
+				return info.Incarnation
+			}
+		}
+		return 0
+	}
+	expectStale := func(err error) {
+		t.Helper()
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expect stale incarnation, got: %v", err)
+		}
+	}
+
+	peer := startMember("incarnation.peer")
+	defer peer.Shutdown()
+
+	// the process crashed and restarted on the same address
+	crashed := startMember("incarnation.crashed")
+	stale := crashed.Incarnation()
+	waitFor(t, func() bool { return incarnation(peer, crashed.ServiceAddr) == stale })
+	crashed.Kill()
+	restarted := startMember("incarnation.crashed")
+	defer restarted.Shutdown()
+	if restarted.Incarnation() == stale {
+		t.Fatalf("expect new incarnation, got: %d", stale)
+	}
+	waitFor(t, func() bool { return incarnation(peer, restarted.ServiceAddr) == restarted.Incarnation() })
+
+	// master rejects the stale incarnation
+	ctx := context.Background()
+	info := &clusterpb.MemberInfo{ServiceAddr: restarted.ServiceAddr, Incarnation: stale}
+	_, err := master.cluster.Heartbeat(ctx, &clusterpb.HeartbeatRequest{MemberInfo: info})
+	expectStale(err)
+	_, err = master.cluster.Register(ctx, &clusterpb.RegisterRequest{MemberInfo: info})
+	expectStale(err)
+	_, err = master.cluster.Unregister(ctx, &clusterpb.UnregisterRequest{ServiceAddr: info.ServiceAddr, Incarnation: stale})
+	expectStale(err)
+	// the heartbeat of an incarnation other than the registered one is stale as well
+	other := &clusterpb.MemberInfo{ServiceAddr: restarted.ServiceAddr, Incarnation: newIncarnation()}
+	_, err = master.cluster.Heartbeat(ctx, &clusterpb.HeartbeatRequest{MemberInfo: other})
+	expectStale(err)
+	if incarnation(master, restarted.ServiceAddr) != restarted.Incarnation() {
+		t.Fatal("expect current incarnation kept in master")
+	}
+
+	// peers ignore the notifications of stale incarnation
+	if _, err := peer.NewMember(ctx, &clusterpb.NewMemberRequest{MemberInfo: info}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.DelMember(ctx, &clusterpb.DelMemberRequest{ServiceAddr: info.ServiceAddr, Incarnation: stale}); err != nil {
+		t.Fatal(err)
+	}
+	if incarnation(peer, restarted.ServiceAddr) != restarted.Incarnation() {
+		t.Fatal("expect current incarnation kept in peer")
+	}
+
+	// peers reject the calls from stale incarnation
+	caller := func(incarnation uint64) context.Context {
+		value := fmt.Sprintf("%d@%s", incarnation, restarted.ServiceAddr)
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(incarnationKey, value))
+	}
+	expectStale(peer.checkIncarnation(caller(stale)))
+	if err := peer.checkIncarnation(caller(restarted.Incarnation())); err != nil {
+		t.Fatal(err)
+	}
+
+	// the superseded incarnation is fenced, while the removed one is allowed to rejoin
+	// since the member may be removed by missed heartbeats during a partition
+	current := restarted.Incarnation()
+	if _, err := peer.DelMember(ctx, &clusterpb.DelMemberRequest{ServiceAddr: info.ServiceAddr, Incarnation: current}); err != nil {
+		t.Fatal(err)
+	}
+	if incarnation(peer, restarted.ServiceAddr) != 0 {
+		t.Fatal("expect member removed")
+	}
+	if err := peer.checkIncarnation(caller(current)); err != nil {
+		t.Fatal(err)
+	}
+	expectStale(peer.checkIncarnation(caller(stale)))
+}
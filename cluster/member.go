@@ -31,12 +31,24 @@ type Member struct {
 	isMaster        bool
 	memberInfo      *clusterpb.MemberInfo
 	lastHeartbeatAt time.Time // cluster member report heartbeat time to the master
+	suspect         bool      // suspected by failure detector, or reloaded from member table and not confirmed by heartbeat
+	heartbeats      *heartbeatHistory
+
+	version   uint64                // version of member information when it was changed
+	versioned *clusterpb.MemberInfo // member information stamped with version
 }
 
 func (m *Member) MemberInfo() *clusterpb.MemberInfo {
 	return m.memberInfo
 }
 
+// Suspect reports whether the member is suspected to be dead by current node, which
+// happens when the member misses heartbeats or probes, or has been reloaded from the
+// member table after master restarted and has not been confirmed by heartbeat
+func (m *Member) Suspect() bool {
+	return m.suspect
+}
+
 func (m *Member) String() string {
 	return fmt.Sprintf("Master: %t MemberInfo: %s LastHeartbeatAt: %s", m.isMaster, m.memberInfo.String(), m.lastHeartbeatAt.String())
 }
@@ -0,0 +1,100 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano"
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/nanotest"
+	"github.com/acoderup/nano/session"
+)
+
+// EchoComponent replies the name of node to the frames forwarded by nanotest gates
+type EchoComponent struct {
+	component.Base
+	name string
+}
+
+func (c *EchoComponent) Message(s *session.Session, _ []byte) error {
+	return s.Response([]byte(c.name))
+}
+
+func echoComponents(name string) *component.Components {
+	comps := &component.Components{}
+	comps.Register(&EchoComponent{name: name}, component.WithName("Gate"))
+	return comps
+}
+
+// nextEvent returns the next event satisfying match, other events are skipped
+func nextEvent(t *testing.T, events chan cluster.MembershipEvent, match func(cluster.MembershipEvent) bool) cluster.MembershipEvent {
+	t.Helper()
+	timeout := time.After(nanotest.DefaultWaitTimeout)
+	for {
+		select {
+		case event := <-events:
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("membership event not received")
+			return nil
+		}
+	}
+}
+
+// memberEvent matches the events of members, the master may be lost shortly if the
+// heartbeat is slow
+func memberEvent(e cluster.MembershipEvent) bool {
+	switch e.(type) {
+	case cluster.MasterLost, cluster.MasterRecovered:
+		return false
+	}
+	return true
+}
+
+func TestMembershipEvents(t *testing.T) {
+	// the partitioned member is kept by master until healed
+	c := nanotest.New(t, nano.WithFailureDetector(2*nanotest.DefaultHeartbeat, time.Minute))
+	master := c.Master()
+
+	events := make(chan cluster.MembershipEvent, 16)
+	member := c.AddBackend("member", &component.Components{},
+		nano.WithMembershipListener(func(e cluster.MembershipEvent) { events <- e }),
+		nano.WithMembershipInScheduler())
+	if e, ok := nextEvent(t, events, memberEvent).(cluster.MemberJoined); !ok || e.Member.ServiceAddr != master.ServiceAddr {
+		t.Fatalf("expect master joined, got: %#v", e)
+	}
+	c.WaitMembers(master, member)
+
+	peer := c.AddBackend("peer", echoComponents("peer"))
+	joined, ok := nextEvent(t, events, memberEvent).(cluster.MemberJoined)
+	if !ok || joined.Member.ServiceAddr != peer.ServiceAddr || joined.Member.Services[0] != "Gate" {
+		t.Fatalf("expect peer joined, got: %#v", joined)
+	}
+
+	c.Shutdown(peer)
+	if e, ok := nextEvent(t, events, memberEvent).(cluster.MemberLeft); !ok || e.Member.ServiceAddr != peer.ServiceAddr {
+		t.Fatalf("expect peer left, got: %#v", e)
+	}
+
+	// master lost and recovered
+	c.Partition(master, member)
+	lost := nextEvent(t, events, func(e cluster.MembershipEvent) bool {
+		_, ok := e.(cluster.MasterLost)
+		return ok
+	}).(cluster.MasterLost)
+	if lost.Addr != master.ServiceAddr || lost.Error == nil {
+		t.Fatalf("expect master lost, got: %#v", lost)
+	}
+	c.Heal(master, member)
+	recovered := nextEvent(t, events, func(e cluster.MembershipEvent) bool {
+		_, ok := e.(cluster.MasterRecovered)
+		return ok
+	}).(cluster.MasterRecovered)
+	if recovered.Addr != master.ServiceAddr {
+		t.Fatalf("expect master recovered, got: %#v", recovered)
+	}
+	c.WaitConverged()
+}
@@ -0,0 +1,249 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// MembershipEpoch returns the membership epoch observed by current node. The master
+// increases the epoch on each change of membership, and members compare it with the
+// epoch responded in heartbeat to detect the missed changes.
+func (n *Node) MembershipEpoch() uint64 {
+	return n.cluster.epoch.Load()
+}
+
+// Members returns the members known by current node, including current node itself
+// if it is the master
+func (n *Node) Members() []*clusterpb.MemberInfo {
+	return n.cluster.memberInfos()
+}
+
+// advanceEpoch advances the epoch if it is the next one of the observed epoch, the
+// epoch will be left behind if some changes have been missed, so the member view will
+// be synchronized in next heartbeat
+func (c *cluster) advanceEpoch(epoch uint64) {
+	if epoch > 0 {
+		c.epoch.CompareAndSwap(epoch-1, epoch)
+	}
+}
+
+// confirmed reports whether the member table of master is complete, all members alive
+// have sent heartbeat to master if it has been started for the dead timeout
+func (c *cluster) confirmed() bool {
+	return time.Since(c.startedAt) > c.currentNode.deadTimeout()
+}
+
+// heartbeatRequest returns the heartbeat request reporting current node and the
+// member view observed by current node
+func (n *Node) heartbeatRequest() *clusterpb.HeartbeatRequest {
+	return &clusterpb.HeartbeatRequest{
+		MemberInfo: n.memberInfo(),
+		Epoch:      n.cluster.epoch.Load(),
+		Version:    n.cluster.observed.Load(),
+	}
+}
+
+// applyHeartbeat applies the member view responded in heartbeat, all members will be
+// synchronized if the epoch is different from the observed one, otherwise only the
+// changed members are responded and refreshed.
+//
+// The restarted master rebuilds the member table from the heartbeats, so the members
+// absent from it are not removed until master confirms the table, and the epoch going
+// backwards never removes members either.
+func (n *Node) applyHeartbeat(resp *clusterpb.HeartbeatResponse) {
+	c := n.cluster
+	if resp.Master != 0 && resp.Master != c.master.Load() {
+		logger.Logger.Tracef("Master restarted, synchronize members once it confirms the member table")
+		c.master.Store(resp.Master)
+		c.unconfirmed.Store(true)
+	}
+
+	// The master without member versions always responds all members
+	full := resp.Full || resp.Version == 0
+	switch {
+	case full && c.unconfirmed.Load():
+		n.syncMembers(resp.Members, resp.Confirmed)
+		c.epoch.Store(resp.Epoch)
+		c.unconfirmed.Store(!resp.Confirmed)
+	case full && resp.Epoch != c.epoch.Load():
+		n.syncMembers(resp.Members, resp.Epoch > c.epoch.Load())
+		c.epoch.Store(resp.Epoch)
+	default:
+		c.refreshMembers(resp.Members)
+	}
+	// All members are requested again until master confirms the member table
+	if c.unconfirmed.Load() {
+		c.observed.Store(0)
+	} else {
+		c.observed.Store(resp.Version)
+	}
+	n.applyOwners(resp.Owners)
+}
+
+// syncMembers replaces the member view of current node with members, the unknown
+// members will be added and the members absent from members will be removed if prune
+func (n *Node) syncMembers(members []*clusterpb.MemberInfo, prune bool) {
+	known := map[string]bool{n.ServiceAddr: true}
+	for _, info := range members {
+		if info.ServiceAddr == n.ServiceAddr {
+			continue
+		}
+		known[info.ServiceAddr] = true
+		if old, ok := n.cluster.addMember(info); ok && old == nil {
+			n.handler.addRemoteService(info)
+		} else if ok {
+			n.handler.replaceMember(old, info)
+		}
+	}
+	if !prune {
+		return
+	}
+	for _, addr := range n.cluster.remoteAddrs() {
+		if !known[addr] {
+			logger.Logger.Tracef("Remove stale member [%s]", addr)
+			n.removeMember(addr)
+		}
+	}
+}
+
+// persist requests to save the member table, the requests are coalesced
+func (c *cluster) persist() {
+	if c.currentNode.MemberTableFile == "" {
+		return
+	}
+	select {
+	case c.save <- struct{}{}:
+	default:
+	}
+}
+
+func (c *cluster) persistLoop() {
+	for {
+		select {
+		case <-c.save:
+			if err := c.saveMembers(); err != nil {
+				logger.Logger.Tracef("Save member table failed: %v", err)
+			}
+		case <-c.die:
+			return
+		}
+	}
+}
+
+// saveMembers writes the snapshot of member table to a temporary file and renames it,
+// the file and its directory are synchronized to disk, so the member table will not be
+// corrupted by a crash during writing
+func (c *cluster) saveMembers() error {
+	table := &clusterpb.MemberTable{}
+	c.mu.RLock()
+	table.Epoch = c.epoch.Load()
+	for _, m := range c.members {
+		if !m.isMaster {
+			table.Members = append(table.Members, m.memberInfo)
+		}
+	}
+	data, err := proto.Marshal(table)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	path := c.currentNode.MemberTableFile
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeFileSync writes data to the file and flushes it to disk before closing
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes the entries of directory to disk, so the renamed file survives a
+// crash. The directories cannot be synchronized on Windows, where it is skipped.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// loadMembers reloads the members from the member table as suspects, the suspects
+// will be removed if they do not send heartbeat in time
+func (c *cluster) loadMembers() {
+	data, err := os.ReadFile(c.currentNode.MemberTableFile)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Logger.Tracef("Load member table failed: %v", err)
+		}
+		return
+	}
+	table := &clusterpb.MemberTable{}
+	if err := proto.Unmarshal(data, table); err != nil {
+		logger.Logger.Tracef("Load member table failed: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch.Store(table.Epoch)
+	for _, info := range table.Members {
+		if info.ServiceAddr == c.currentNode.ServiceAddr {
+			continue
+		}
+		c.members = append(c.members, &Member{memberInfo: info, lastHeartbeatAt: time.Now(), suspect: true})
+		c.currentNode.handler.addRemoteService(info)
+		c.currentNode.events.emit(MemberJoined{Member: info})
+		logger.Logger.Tracef("Reload suspect member [%s]", info.ServiceAddr)
+	}
+	c.holdOwners()
+}
@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/scheduler"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMemberTable(t *testing.T) {
+	go scheduler.Sched()
+
+	heartbeat := env.Heartbeat
+	env.Heartbeat = 50 * time.Millisecond
+	defer func() { env.Heartbeat = heartbeat }()
+
+	file := filepath.Join(t.TempDir(), "members")
+	startMaster := func() *Node {
+		master := &Node{
+			Options:     Options{IsMaster: true, Components: &component.Components{}, MemberTableFile: file, Listener: memListen, Dialer: memDial},
+			ServiceAddr: "membertable.master",
+		}
+		if err := master.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		return master
+	}
+	startMember := func(addr string, comps *component.Components) *Node {
+		member := &Node{
+			Options:     Options{AdvertiseAddr: "membertable.master", Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := member.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		return member
+	}
+	savedMembers := func() int {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return 0
+		}
+		table := &clusterpb.MemberTable{}
+		if err := proto.Unmarshal(data, table); err != nil {
+			t.Fatal(err)
+		}
+		return len(table.Members)
+	}
+	suspect := func(n *Node, addr string) (bool, bool) {
+		n.cluster.mu.RLock()
+		defer n.cluster.mu.RUnlock()
+		for _, m := range n.cluster.members {
+			if m.memberInfo.ServiceAddr == addr {
+				return m.Suspect(), true
+			}
+		}
+		return false, false
+	}
+
+	master := startMaster()
+	comps := &component.Components{}
+	comps.Register(&AccountComponent{})
+	account := startMember("membertable.account", comps)
+	defer account.Shutdown()
+	crashed := startMember("membertable.crashed", &component.Components{})
+	waitFor(t, func() bool { return savedMembers() == 2 })
+	epoch := master.MembershipEpoch()
+	if epoch == 0 || account.MembershipEpoch() != epoch {
+		t.Fatalf("unexpected epoch, master: %d, member: %d", epoch, account.MembershipEpoch())
+	}
+
+	// the members are reloaded as suspects after master restarted
+	master.Kill()
+	crashed.Kill()
+	master = startMaster()
+	defer master.Shutdown()
+	if s, found := suspect(master, account.ServiceAddr); !found || !s {
+		t.Fatalf("expect suspect member reloaded, found: %v, suspect: %v", found, s)
+	}
+	if master.MembershipEpoch() != epoch {
+		t.Fatalf("expect epoch %d reloaded, got: %d", epoch, master.MembershipEpoch())
+	}
+
+	// new member receives the complete member view
+	caller := startMember("membertable.caller", &component.Components{})
+	defer caller.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	pong := &testdata.Pong{}
+	if err := caller.Call(ctx, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+
+	// alive members are confirmed and the crashed member is removed
+	waitFor(t, func() bool {
+		s, found := suspect(master, account.ServiceAddr)
+		return found && !s
+	})
+	waitFor(t, func() bool {
+		_, found := suspect(master, crashed.ServiceAddr)
+		return !found
+	})
+	waitFor(t, func() bool {
+		_, found := suspect(account, crashed.ServiceAddr)
+		return !found && account.MembershipEpoch() == master.MembershipEpoch()
+	})
+	if master.MembershipEpoch() <= epoch {
+		t.Fatalf("expect epoch increased, got: %d", master.MembershipEpoch())
+	}
+	waitFor(t, func() bool { return savedMembers() == 2 })
+
+	// stale view is synchronized once the epoch mismatches
+	account.cluster.addMember(&clusterpb.MemberInfo{ServiceAddr: "membertable.stale"})
+	account.cluster.epoch.Store(0)
+	waitFor(t, func() bool {
+		_, found := suspect(account, "membertable.stale")
+		return !found && account.MembershipEpoch() == master.MembershipEpoch()
+	})
+}
+
+func TestHeartbeatDelta(t *testing.T) {
+	master := &Node{
+		Options: Options{
+			IsMaster:   true,
+			Components: &component.Components{},
+			Listener:   memListen,
+		},
+		ServiceAddr: "heartbeat.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	ctx := context.Background()
+	heartbeat := func(req *clusterpb.HeartbeatRequest) *clusterpb.HeartbeatResponse {
+		t.Helper()
+		resp, err := master.cluster.Heartbeat(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	members := func(resp *clusterpb.HeartbeatResponse) map[string]int64 {
+		loads := map[string]int64{}
+		for _, info := range resp.Members {
+			loads[info.ServiceAddr] = info.Load
+		}
+		return loads
+	}
+
+	a := &clusterpb.MemberInfo{ServiceAddr: "a", Incarnation: 1}
+	b := &clusterpb.MemberInfo{ServiceAddr: "b", Incarnation: 1}
+	heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: a})
+	resp := heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: b})
+	if !resp.Full || len(resp.Members) != 3 {
+		t.Fatalf("expect all members responded, got: %v", members(resp))
+	}
+
+	// the member observing the current epoch and version receives nothing
+	resp = heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: a, Epoch: resp.Epoch, Version: resp.Version})
+	if resp.Full || len(resp.Members) != 0 {
+		t.Fatalf("expect no member responded, got: %v", members(resp))
+	}
+
+	// only the changed members are responded
+	b = proto.Clone(b).(*clusterpb.MemberInfo)
+	b.Load = 10
+	heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: b, Epoch: resp.Epoch, Version: resp.Version})
+	resp = heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: a, Epoch: resp.Epoch, Version: resp.Version})
+	if loads := members(resp); resp.Full || len(loads) != 1 || loads["b"] != 10 {
+		t.Fatalf("expect only b responded, got: %v", loads)
+	}
+
+	// all members are responded if the member observes a different epoch
+	resp = heartbeat(&clusterpb.HeartbeatRequest{MemberInfo: a, Epoch: resp.Epoch - 1, Version: resp.Version})
+	if !resp.Full || len(resp.Members) != 3 {
+		t.Fatalf("expect all members responded, got: %v", members(resp))
+	}
+}
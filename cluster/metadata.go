@@ -0,0 +1,99 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// Metadata returns a copy of the metadata of current node
+func (n *Node) Metadata() map[string]string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return maps.Clone(n.metadata)
+}
+
+// SetMetadata sets the metadata value of key and publishes the member information to
+// cluster immediately, the route strategies of other members will see the change
+func (n *Node) SetMetadata(key, value string) error {
+	n.mu.Lock()
+	if n.metadata == nil {
+		n.metadata = map[string]string{}
+	}
+	n.metadata[key] = value
+	n.mu.Unlock()
+	return n.publishMemberInfo()
+}
+
+// DeleteMetadata deletes the metadata of key and publishes the member information to
+// cluster immediately
+func (n *Node) DeleteMetadata(key string) error {
+	n.mu.Lock()
+	delete(n.metadata, key)
+	n.mu.Unlock()
+	return n.publishMemberInfo()
+}
+
+// publishMemberInfo sends the member information of current node to master without
+// waiting for the next heartbeat, and master will notify other members
+func (n *Node) publishMemberInfo() error {
+	if n.IsMaster {
+		c := n.cluster
+		info := n.memberInfo()
+		var peers []string
+		c.mu.Lock()
+		for _, m := range c.members {
+			if m.isMaster {
+				if memberChanged(m.memberInfo, info) {
+					n.events.emit(MemberUpdated{Old: m.memberInfo, New: info})
+					c.epoch.Add(1)
+				}
+				m.memberInfo = info
+			} else {
+				peers = append(peers, m.memberInfo.ServiceAddr)
+			}
+		}
+		c.mu.Unlock()
+		c.notifyMember(info, peers)
+		return nil
+	}
+	if n.AdvertiseAddr == "" {
+		return nil
+	}
+	client, err := n.masterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	n.heartbeatMu.Lock()
+	defer n.heartbeatMu.Unlock()
+	sentAt := time.Now()
+	resp, err := client.Heartbeat(ctx, n.heartbeatRequest())
+	if err != nil {
+		return err
+	}
+	n.renewLease(sentAt)
+	n.applyHeartbeat(resp)
+	return nil
+}
@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/scheduler"
+)
+
+// knownMember returns the member information of addr known by n
+func knownMember(n *Node, addr string) *clusterpb.MemberInfo {
+	n.cluster.mu.RLock()
+	defer n.cluster.mu.RUnlock()
+	for _, m := range n.cluster.members {
+		if m.memberInfo.ServiceAddr == addr {
+			return m.memberInfo
+		}
+	}
+	return nil
+}
+
+func TestMemberMetadata(t *testing.T) {
+	go scheduler.Sched()
+
+	heartbeat := env.Heartbeat
+	env.Heartbeat = 50 * time.Millisecond
+	defer func() { env.Heartbeat = heartbeat }()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "metadata.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	comps := &component.Components{}
+	comps.Register(&AccountComponent{})
+	member := &Node{
+		Options: Options{
+			AdvertiseAddr: master.ServiceAddr,
+			Components:    comps,
+			Metadata:      map[string]string{MetadataZone: "asia"},
+			LoadReporter:  func() map[string]int64 { return map[string]int64{"rooms": 3} },
+			Listener:      memListen,
+			Dialer:        memDial,
+		},
+		ServiceAddr: "metadata.member",
+	}
+	if err := member.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer member.Shutdown()
+
+	updates := make(chan MembershipEvent, 16)
+	peer := &Node{
+		Options: Options{
+			AdvertiseAddr:       master.ServiceAddr,
+			Components:          &component.Components{},
+			MembershipListeners: []MembershipListener{func(e MembershipEvent) { updates <- e }},
+			Listener:            memListen,
+			Dialer:              memDial,
+		},
+		ServiceAddr: "metadata.peer",
+	}
+	if err := peer.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Shutdown()
+
+	// metadata is propagated on registering, and the load is refreshed by heartbeat
+	members := peer.handler.findMembers("AccountComponent")
+	if len(members) != 1 || members[0].Metadata[MetadataZone] != "asia" {
+		t.Fatalf("unexpected members: %v", members)
+	}
+	waitFor(t, func() bool {
+		info := knownMember(master, member.ServiceAddr)
+		return info != nil && info.Loads["rooms"] == 3
+	})
+
+	// runtime updates are propagated to peers immediately
+	if err := member.SetMetadata(MetadataVersion, "2"); err != nil {
+		t.Fatal(err)
+	}
+	members = peer.handler.findMembers("AccountComponent")
+	if len(members) != 1 || members[0].Metadata[MetadataVersion] != "2" {
+		t.Fatalf("expect metadata updated, got: %v", members)
+	}
+	waitFor(t, func() bool {
+		for {
+			select {
+			case e := <-updates:
+				if u, ok := e.(MemberUpdated); ok && u.New.Metadata[MetadataVersion] == "2" {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	})
+
+	if err := master.SetMetadata(MetadataZone, "europe"); err != nil {
+		t.Fatal(err)
+	}
+	if info := knownMember(peer, master.ServiceAddr); info == nil || info.Metadata[MetadataZone] != "europe" {
+		t.Fatalf("expect master metadata updated, got: %v", info)
+	}
+	if err := member.DeleteMetadata(MetadataVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := peer.handler.findMembers("AccountComponent")[0].Metadata[MetadataVersion]; found {
+		t.Fatal("expect metadata deleted")
+	}
+}
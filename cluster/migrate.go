@@ -0,0 +1,538 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+// migrateTimeout is the timeout of a session migration if the context has no deadline
+const migrateTimeout = 5 * time.Second
+
+type (
+	// migration represents a service of session migrated from current node, the messages
+	// received before the target restores the session are buffered, and forwarded to the
+	// target after that
+	migration struct {
+		target  string
+		ready   chan struct{} // closed after the migration is committed or aborted
+		mu      sync.Mutex
+		pending []*clusterpb.StreamMessage
+		done    bool
+	}
+
+	// sessionHolds buffers the messages of the services of a gate session which are
+	// being migrated between members
+	sessionHolds struct {
+		mu     sync.Mutex
+		routes map[string]*heldMessages
+	}
+
+	heldMessages struct {
+		messages  []*message.Message
+		timer     *time.Timer
+		releasing bool
+	}
+)
+
+// hold buffers the message sent to service if the service is held, and reports
+// whether the message is buffered
+func (h *sessionHolds) hold(service string, msg *message.Message) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held := h.routes[service]
+	if held == nil {
+		return false
+	}
+	m := *msg
+	if len(msg.Data) > 0 {
+		m.Data = make([]byte, len(msg.Data))
+		copy(m.Data, msg.Data)
+	}
+	held.messages = append(held.messages, &m)
+	return true
+}
+
+// start holds the messages sent to service until deadline, expire will be called
+// if the hold has not been released in time
+func (h *sessionHolds) start(service string, deadline time.Time, expire func()) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, found := h.routes[service]; found {
+		return false
+	}
+	if h.routes == nil {
+		h.routes = map[string]*heldMessages{}
+	}
+	h.routes[service] = &heldMessages{timer: time.AfterFunc(time.Until(deadline), expire)}
+	return true
+}
+
+// release marks the hold of service as releasing, it reports false if the service is
+// not held or has been released by others
+func (h *sessionHolds) release(service string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held := h.routes[service]
+	if held == nil || held.releasing {
+		return false
+	}
+	held.releasing = true
+	held.timer.Stop()
+	return true
+}
+
+// take returns the messages buffered since last take, the hold is removed when
+// no message left, so the messages are always flushed in order
+func (h *sessionHolds) take(service string) []*message.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held := h.routes[service]
+	if held == nil {
+		return nil
+	}
+	messages := held.messages
+	held.messages = nil
+	if len(messages) == 0 {
+		delete(h.routes, service)
+	}
+	return messages
+}
+
+func routeService(route string) string {
+	if index := strings.LastIndex(route, "."); index >= 0 {
+		return route[:index]
+	}
+	return route
+}
+
+func providesService(members []*clusterpb.MemberInfo, addr string) bool {
+	for _, m := range routableMembers(members) {
+		if m.ServiceAddr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) memberClient(addr string) (clusterpb.MemberClient, error) {
+	pool, err := n.rpcClient.getConnPool(addr)
+	if err != nil {
+		return nil, err
+	}
+	return clusterpb.NewMemberClient(pool.Get()), nil
+}
+
+// MigrateSession migrates the service of session to the member of target, the migration
+// is started by the node started most recently in current process. See Node.MigrateSession
+// for more details.
+func MigrateSession(ctx context.Context, s *session.Session, service, target string) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.MigrateSession(ctx, s, service, target)
+}
+
+// MigrateSession migrates the service of session from current node to the member of
+// target without disconnecting the client. The session data and the state exported by
+// the component implementing component.SessionMigrator are restored in target member,
+// and the gate rebinds the service of session to target. The messages sent to the
+// service during the migration are held in gate and flushed to target after that.
+// The migration is aborted and the messages are flushed to current node if any step
+// fails. The session values which cannot be encoded are not migrated.
+//
+// The session state is exported in the scheduler of service after the messages scheduled
+// before, so the migration called by a task of the same scheduler, e.g. a handler of the
+// service passing the context it received, cannot wait for it. The migration is started
+// in background in that case, nil is returned once the arguments have been checked and
+// the failure is logged, the component will be notified by SessionMigrated after the
+// migration completes. The handlers without a context.Context argument should call it
+// in a new goroutine for the same reason.
+func (n *Node) MigrateSession(ctx context.Context, s *session.Session, service, target string) error {
+	ac, ok := s.NetworkEntity().(*acceptor)
+	if !ok {
+		return ErrNotForwarded
+	}
+	comp, found := n.handler.localServices[service]
+	if !found {
+		return fmt.Errorf("service not found in current node: %v", service)
+	}
+	if target == n.ServiceAddr || !providesService(n.handler.findMembers(service), target) {
+		return ErrNoMember
+	}
+	sched, err := n.handler.localScheduler(service+".", s)
+	if err != nil {
+		return err
+	}
+	if scheduler.Scheduling(ctx, sched) {
+		// The migration is not canceled once the task returns
+		ctx = context.WithoutCancel(ctx)
+		go func() {
+			if err := n.migrateSession(ctx, s, ac, comp, target); err != nil {
+				logger.Logger.Tracef("Migrate session[%d] service[%s] to [%s] error: %v", ac.sid, service, target, err)
+			}
+		}()
+		return nil
+	}
+	return n.migrateSession(ctx, s, ac, comp, target)
+}
+
+// migrateSession migrates the service of session to target, see MigrateSession
+func (n *Node) migrateSession(ctx context.Context, s *session.Session, ac *acceptor, comp *component.Service, target string) error {
+	service := comp.Name
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, migrateTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	gate, err := n.memberClient(ac.gateAddr)
+	if err != nil {
+		return err
+	}
+	hold := &clusterpb.HoldSessionRequest{SessionId: ac.sid, Service: service, Deadline: deadline.UnixNano()}
+	if _, err := gate.HoldSession(ctx, hold); err != nil {
+		return err
+	}
+
+	mig, state, err := n.exportSession(ctx, s, comp, target)
+	if err == nil {
+		var client clusterpb.MemberClient
+		if client, err = n.memberClient(target); err == nil {
+			state.GateAddr, state.SessionId, state.Uid = ac.gateAddr, ac.sid, s.UID()
+			_, err = client.RestoreSession(ctx, state)
+		}
+	}
+	if err != nil {
+		n.abortMigration(ac.sid, service, mig)
+		ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+		defer cancel()
+		if _, e := gate.ReleaseSession(ctx, &clusterpb.ReleaseSessionRequest{SessionId: ac.sid, Service: service}); e != nil {
+			logger.Logger.Tracef("Release session[%d] service[%s] error: %v", ac.sid, service, e)
+		}
+		return err
+	}
+
+	n.commitMigration(mig)
+	touchSession(s, target)
+	if m, ok := comp.Receiver.Interface().(component.SessionMigrator); ok {
+		if err := n.handler.schedule(service+".", s, func() { m.SessionMigrated(s) }); err != nil {
+			logger.Logger.Tracef(err.Error())
+		}
+	}
+
+	// The held messages are flushed to current node after the deadline if the release
+	// fails, and they will be forwarded to target since the migration has committed
+	release := &clusterpb.ReleaseSessionRequest{SessionId: ac.sid, Service: service, Target: target}
+	if _, err := gate.ReleaseSession(ctx, release); err != nil {
+		logger.Logger.Tracef("Release session[%d] service[%s] to [%s] error: %v", ac.sid, service, target, err)
+	}
+	return nil
+}
+
+// exportSession marks the service of session as migrating, and exports the session
+// state in the scheduler of service, so all messages scheduled before have been
+// processed and the later ones will be buffered
+func (n *Node) exportSession(ctx context.Context, s *session.Session, comp *component.Service, target string) (*migration, *clusterpb.SessionState, error) {
+	type result struct {
+		state *clusterpb.SessionState
+		err   error
+	}
+	done := make(chan result, 1)
+	task := func() {
+		state := &clusterpb.SessionState{Service: comp.Name, Values: map[string]*clusterpb.SessionValue{}}
+		for key, value := range maps.Clone(s.State()) {
+			v, err := encodeValue(value)
+			if err != nil {
+				logger.Logger.Tracef("Skip session value %s in migration: %v", key, err)
+				continue
+			}
+			state.Values[key] = v
+		}
+		if m, ok := comp.Receiver.Interface().(component.SessionMigrator); ok {
+			data, err := m.ExportSession(s)
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			state.State = data
+		}
+		done <- result{state: state}
+	}
+
+	sid := s.NetworkEntity().(*acceptor).sid
+	mig := &migration{target: target, ready: make(chan struct{})}
+	n.migrateMu.Lock()
+	if n.migrations[sid][comp.Name] != nil {
+		n.migrateMu.Unlock()
+		return nil, nil, ErrSessionMigrating
+	}
+	if err := n.handler.schedule(comp.Name+".", s, task); err != nil {
+		n.migrateMu.Unlock()
+		return nil, nil, err
+	}
+	if n.migrations == nil {
+		n.migrations = map[int64]map[string]*migration{}
+	}
+	if n.migrations[sid] == nil {
+		n.migrations[sid] = map[string]*migration{}
+	}
+	n.migrations[sid][comp.Name] = mig
+	n.migrateMu.Unlock()
+
+	select {
+	case r := <-done:
+		return mig, r.state, r.err
+	case <-ctx.Done():
+		return mig, nil, ctx.Err()
+	}
+}
+
+// commitMigration flushes the buffered messages to target in order before the later
+// messages are forwarded through the member stream
+func (n *Node) commitMigration(mig *migration) {
+	mig.mu.Lock()
+	defer mig.mu.Unlock()
+	client, err := n.memberClient(mig.target)
+	for _, m := range mig.pending {
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+			err = unaryCall(ctx, client, m)
+			cancel()
+		}
+		if err != nil {
+			logger.Logger.Tracef("Forward migrated message to [%s] error: %v", mig.target, err)
+		}
+	}
+	mig.pending = nil
+	mig.done = true
+	close(mig.ready)
+}
+
+// abortMigration processes the buffered messages in current node, the later messages
+// are blocked until the buffered ones have been scheduled
+func (n *Node) abortMigration(sid int64, service string, mig *migration) {
+	if mig == nil {
+		return
+	}
+	n.migrateMu.Lock()
+	defer n.migrateMu.Unlock()
+	if n.migrations[sid][service] == mig {
+		delete(n.migrations[sid], service)
+	}
+	mig.mu.Lock()
+	pending := mig.pending
+	mig.pending = nil
+	mig.mu.Unlock()
+	for _, m := range pending {
+		var err error
+		switch p := m.Payload.(type) {
+		case *clusterpb.StreamMessage_Request:
+			err = n.handleRequest(p.Request)
+		case *clusterpb.StreamMessage_Notify:
+			err = n.handleNotify(p.Notify)
+		}
+		if err != nil {
+			logger.Logger.Tracef("Handle message of aborted migration error: %v", err)
+		}
+	}
+	close(mig.ready)
+}
+
+// redirect buffers or forwards the message of the session service migrated from current
+// node, and reports whether the message has been redirected. The caller should hold the
+// read lock of migrations until the message is scheduled.
+func (n *Node) redirect(sid int64, route string, m *clusterpb.StreamMessage) bool {
+	mig := n.migrations[sid][routeService(route)]
+	if mig == nil {
+		return false
+	}
+	mig.mu.Lock()
+	defer mig.mu.Unlock()
+	if !mig.done {
+		mig.pending = append(mig.pending, m)
+		return true
+	}
+	if err := n.transport.send(context.Background(), mig.target, m); err != nil {
+		logger.Logger.Tracef("Forward migrated message to [%s] error: %v", mig.target, err)
+	}
+	return true
+}
+
+// migratedTarget returns the target which the service of session has been migrated to,
+// it waits until the migration in progress is committed or aborted
+func (n *Node) migratedTarget(ctx context.Context, sid int64, route string) (string, bool) {
+	n.migrateMu.RLock()
+	mig := n.migrations[sid][routeService(route)]
+	n.migrateMu.RUnlock()
+	if mig == nil {
+		return "", false
+	}
+	select {
+	case <-mig.ready:
+	case <-ctx.Done():
+		return "", false
+	}
+	mig.mu.Lock()
+	defer mig.mu.Unlock()
+	return mig.target, mig.done
+}
+
+// forgetMigrations removes the migrations of session, the services of session will
+// be processed by current node again if it is migrated back
+func (n *Node) forgetMigrations(sid int64, service string) {
+	n.migrateMu.Lock()
+	defer n.migrateMu.Unlock()
+	if service == "" {
+		delete(n.migrations, sid)
+	} else {
+		delete(n.migrations[sid], service)
+	}
+}
+
+// RestoreSession implements the MemberServer interface, it restores the session state
+// migrated from another member
+func (n *Node) RestoreSession(ctx context.Context, req *clusterpb.SessionState) (*clusterpb.MemberHandleResponse, error) {
+	comp, found := n.handler.localServices[req.Service]
+	if !found {
+		return nil, fmt.Errorf("service not found in current node: %v", req.Service)
+	}
+	values := make(map[string]interface{}, len(req.Values))
+	for key, v := range req.Values {
+		value, err := decodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	s, err := n.findOrCreateSession(req.SessionId, req.GateAddr)
+	if err != nil {
+		return nil, err
+	}
+	n.forgetMigrations(req.SessionId, req.Service)
+
+	done := make(chan error, 1)
+	task := func() {
+		data := maps.Clone(s.State())
+		maps.Copy(data, values)
+		s.Restore(data)
+		if req.Uid > 0 && s.UID() != req.Uid {
+			// The gate has known the uid, it is unnecessary to be synchronized
+			if ac, ok := s.NetworkEntity().(*acceptor); ok {
+				ac.synced.mu.Lock()
+				ac.synced.uid = req.Uid
+				ac.synced.mu.Unlock()
+			}
+			_ = s.Bind(req.Uid)
+		}
+		if m, ok := comp.Receiver.Interface().(component.SessionMigrator); ok {
+			done <- m.ImportSession(s, req.State)
+			return
+		}
+		done <- nil
+	}
+	if err := n.handler.schedule(req.Service+".", s, task); err != nil {
+		return nil, err
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return &clusterpb.MemberHandleResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// HoldSession implements the MemberServer interface, it holds the messages sent to
+// the service of session in gate
+func (n *Node) HoldSession(_ context.Context, req *clusterpb.HoldSessionRequest) (*clusterpb.MemberHandleResponse, error) {
+	a, err := n.gateAgent(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	expire := func() {
+		logger.Logger.Tracef("Hold of session[%d] service[%s] expired", req.SessionId, req.Service)
+		n.releaseSession(a, req.Service, "")
+	}
+	if !a.holds.start(req.Service, time.Unix(0, req.Deadline), expire) {
+		return nil, ErrSessionMigrating
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
+
+// ReleaseSession implements the MemberServer interface, it rebinds the service of
+// session to the target and flushes the held messages
+func (n *Node) ReleaseSession(_ context.Context, req *clusterpb.ReleaseSessionRequest) (*clusterpb.MemberHandleResponse, error) {
+	a, err := n.gateAgent(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	n.releaseSession(a, req.Service, req.Target)
+	return &clusterpb.MemberHandleResponse{}, nil
+}
+
+func (n *Node) gateAgent(sid int64) (*agent, error) {
+	s := n.findSession(sid)
+	if s == nil {
+		return nil, fmt.Errorf("session not found: %v", sid)
+	}
+	a, ok := s.NetworkEntity().(*agent)
+	if !ok {
+		return nil, fmt.Errorf("session is not connected to current node: %v", sid)
+	}
+	return a, nil
+}
+
+func (n *Node) releaseSession(a *agent, service, target string) {
+	if target != "" {
+		a.session.Router().Bind(service, target)
+		touchSession(a.session, target)
+	}
+	if !a.holds.release(service) {
+		return
+	}
+	for {
+		messages := a.holds.take(service)
+		if len(messages) == 0 {
+			return
+		}
+		for _, msg := range messages {
+			n.handler.forward(a.session, service, msg, true)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+type RoomComponent struct {
+	component.Base
+	node       *Node
+	mu         sync.Mutex
+	scores     map[int64]int
+	migrated   map[int64]bool
+	failImport bool
+}
+
+func newRoomComponent() *RoomComponent {
+	return &RoomComponent{scores: map[int64]int{}, migrated: map[int64]bool{}}
+}
+
+func (r *RoomComponent) Join(s *session.Session, ping *testdata.Ping) error {
+	uid, _ := strconv.ParseInt(ping.Content, 10, 64)
+	s.Set("level", 3)
+	return s.Bind(uid)
+}
+
+func (r *RoomComponent) Score(s *session.Session, _ *testdata.Ping) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scores[s.UID()]++
+	return nil
+}
+
+// Move migrates the session to the member of address in ping
+func (r *RoomComponent) Move(ctx context.Context, s *session.Session, ping *testdata.Ping) error {
+	return r.node.MigrateSession(ctx, s, "RoomComponent", ping.Content)
+}
+
+func (r *RoomComponent) ExportSession(s *session.Session) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return []byte(strconv.Itoa(r.scores[s.UID()])), nil
+}
+
+func (r *RoomComponent) ImportSession(s *session.Session, state []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failImport {
+		return errors.New("import failed")
+	}
+	score, err := strconv.Atoi(string(state))
+	if err != nil {
+		return err
+	}
+	r.scores[s.UID()] = score
+	return nil
+}
+
+func (r *RoomComponent) SessionMigrated(s *session.Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.scores, s.UID())
+	r.migrated[s.UID()] = true
+}
+
+func (r *RoomComponent) score(uid int64) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	score, found := r.scores[uid]
+	return score, found
+}
+
+func TestMigrateSession(t *testing.T) {
+	go scheduler.Sched()
+
+	gate := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "migrate.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	rooms := map[string]*RoomComponent{}
+	nodes := map[string]*Node{}
+	for _, addr := range []string{"migrate.room1", "migrate.room2"} {
+		room := newRoomComponent()
+		comps := &component.Components{}
+		comps.Register(room)
+		member := &Node{
+			Options:     Options{AdvertiseAddr: gate.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := member.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		defer member.Shutdown()
+		room.node = member
+		rooms[addr], nodes[addr] = room, member
+	}
+	waitFor(t, func() bool { return len(gate.handler.findMembers("RoomComponent")) == 2 })
+
+	for name := range nodes["migrate.room1"].handler.localServices["RoomComponent"].Handlers {
+		if name == "ImportSession" {
+			t.Fatal("expect migrator methods are not registered as handlers")
+		}
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go gate.handler.handle(conn, "", "")
+	var s *session.Session
+	waitFor(t, func() bool {
+		gate.mu.RLock()
+		defer gate.mu.RUnlock()
+		for _, v := range gate.sessions {
+			s = v
+		}
+		return s != nil
+	})
+
+	const uid = 1001
+	gate.handler.remoteProcess(s, notifyMessage("RoomComponent.Join", strconv.Itoa(uid)), true)
+	const before = 50
+	for i := 0; i < before; i++ {
+		gate.handler.remoteProcess(s, notifyMessage("RoomComponent.Score", ""), true)
+	}
+	sourceAddr, _ := s.Router().Find("RoomComponent")
+	var targetAddr string
+	for addr := range nodes {
+		if addr != sourceAddr {
+			targetAddr = addr
+		}
+	}
+	source, target := nodes[sourceAddr], nodes[targetAddr]
+	waitFor(t, func() bool { score, _ := rooms[sourceAddr].score(uid); return score == before })
+	backend := source.findSession(s.ID())
+
+	// the migration is aborted if the target fails to restore the session
+	rooms[targetAddr].mu.Lock()
+	rooms[targetAddr].failImport = true
+	rooms[targetAddr].mu.Unlock()
+	if err := source.MigrateSession(context.Background(), backend, "RoomComponent", targetAddr); err == nil {
+		t.Fatal("expect migration failed")
+	}
+	rooms[targetAddr].mu.Lock()
+	rooms[targetAddr].failImport = false
+	rooms[targetAddr].mu.Unlock()
+	gate.handler.remoteProcess(s, notifyMessage("RoomComponent.Score", ""), true)
+	waitFor(t, func() bool { score, _ := rooms[sourceAddr].score(uid); return score == before+1 })
+	if addr, _ := s.Router().Find("RoomComponent"); addr != sourceAddr {
+		t.Fatalf("expect session bound to source after abort, got: %s", addr)
+	}
+
+	// the messages sent during the migration are held and processed by target
+	const during = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < during; i++ {
+			gate.handler.remoteProcess(s, notifyMessage("RoomComponent.Score", ""), true)
+		}
+	}()
+	if err := source.MigrateSession(context.Background(), backend, "RoomComponent", targetAddr); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	total := before + 1 + during
+	waitFor(t, func() bool { score, _ := rooms[targetAddr].score(uid); return score == total })
+	if addr, _ := s.Router().Find("RoomComponent"); addr != targetAddr {
+		t.Fatalf("expect session rebound to target, got: %s", addr)
+	}
+	waitFor(t, func() bool {
+		rooms[sourceAddr].mu.Lock()
+		defer rooms[sourceAddr].mu.Unlock()
+		return rooms[sourceAddr].migrated[uid]
+	})
+	if _, found := rooms[sourceAddr].score(uid); found {
+		t.Fatal("expect source released the migrated state")
+	}
+	migrated := target.findSession(s.ID())
+	if migrated.UID() != uid || migrated.Int("level") != 3 {
+		t.Fatalf("expect session state restored, got uid %d level %d", migrated.UID(), migrated.Int("level"))
+	}
+
+	// the messages still sent to source are forwarded to target
+	notify := &clusterpb.NotifyMessage{
+		GateAddr:  gate.ServiceAddr,
+		SessionId: s.ID(),
+		Route:     "RoomComponent.Score",
+		Data:      notifyMessage("", "").Data,
+	}
+	if _, err := source.HandleNotify(context.Background(), notify); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { score, _ := rooms[targetAddr].score(uid); return score == total+1 })
+
+	// the migration started by a handler in the scheduler
+	gate.handler.remoteProcess(s, notifyMessage("RoomComponent.Move", sourceAddr), true)
+	waitFor(t, func() bool { score, _ := rooms[sourceAddr].score(uid); return score == total+1 })
+	// the gate rebinds the session once the migration released it
+	waitFor(t, func() bool { addr, _ := s.Router().Find("RoomComponent"); return addr == sourceAddr })
+
+	// the migrated session is closed in both members
+	peer.Close()
+	waitFor(t, func() bool { return source.findSession(s.ID()) == nil && target.findSession(s.ID()) == nil })
+	source.migrateMu.RLock()
+	defer source.migrateMu.RUnlock()
+	if len(source.migrations) != 0 {
+		t.Fatal("expect migrations of closed session removed")
+	}
+}
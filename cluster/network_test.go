@@ -0,0 +1,18 @@
+package cluster
+
+import (
+	"github.com/acoderup/nano/internal/memnet"
+	"google.golang.org/grpc"
+)
+
+// testNetwork connects the nodes started by tests in memory, the service addresses of
+// nodes are only the names of listeners in it, no ports are bound
+var testNetwork = memnet.New()
+
+// memListen and memDial are the Listener and Dialer options of the nodes in tests, and
+// memDialOptions dials the members of testNetwork by the clients created in tests
+var (
+	memListen      = testNetwork.Listen
+	memDial        = testNetwork.Dialer("")
+	memDialOptions = []grpc.DialOption{grpc.WithContextDialer(memDial)}
+)
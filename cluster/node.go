@@ -24,10 +24,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/acoderup/core/logger"
@@ -41,11 +43,14 @@ import (
 	"github.com/acoderup/nano/session"
 	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Options contains some configurations for current node
 type Options struct {
 	Pipeline           pipeline.Pipeline
+	GatePipeline       pipeline.Pipeline // processes the messages before gate forwards them, the inbound pipeline of Pipeline runs in backends only
 	IsMaster           bool
 	AdvertiseAddr      string
 	RetryInterval      time.Duration
@@ -57,8 +62,48 @@ type Options struct {
 	TSLKey             string
 	UnregisterCallback func(Member)
 	RemoteServiceRoute CustomerRemoteServiceRoute
+	ServiceRoutes      map[string]CustomerRemoteServiceRoute // route strategy of each service
+	RebindHandlers     map[string]RebindHandler              // rebind callback of each service
+	UnaryTransport     bool                                  // forward messages by unary calls instead of member streams
+	ConnPoolSize       int                                   // connections count to each member, default 10
+	ConnIdleTimeout    time.Duration                         // close the connections to member which are idle for the duration
+	ConnMaxBackoff     time.Duration                         // max delay of reconnecting to member, default 5s
+	ClusterName        string                                // members with different cluster name will be rejected
+	ClusterToken       string                                // shared secret required by the calls between members
+	ClusterCertFile    string                                // certificate of the mutual TLS between members
+	ClusterKeyFile     string                                // private key of the mutual TLS between members
+	ClusterCAFile      string                                // CA certificate to verify the members
+	SyncSession        bool                                  // synchronize the session UID and SyncSessionKeys between gate and backends
+	SyncSessionKeys    []string                              // keys of the session data to be synchronized
+	UIDDirectory       bool                                  // record the session bound to each UID in master
+	Metadata           map[string]string                     // initial metadata of current node, see MetadataZone, etc.
+	LoadReporter       func() map[string]int64               // reports the dynamic load in each heartbeat
+	TopicReplaySize    int                                   // recent messages kept per topic for the replay of late subscribers
+	MemberTableFile    string                                // file where master persists the member table
+	SuspectTimeout     time.Duration                         // suspect the member without heartbeat for the duration, default 2 heartbeats
+	DeadTimeout        time.Duration                         // remove the member without heartbeat for the duration, default 4 heartbeats
+	PhiThreshold       float64                               // suspect the member once phi of heartbeat history exceeds it, disabled if zero
+	ProbeInterval      time.Duration                         // interval of probing the connections to peers, disabled if zero
+	BreakerErrorRate   float64                               // open the circuit breaker of member once the error rate of calls exceeds it, disabled if zero
+	BreakerSlowCall    time.Duration                         // the calls slower than it are counted as errors by circuit breakers
+	BreakerOpenTimeout time.Duration                         // duration of circuit breaker staying open before a trial call, default 5s
+	PinnedServices     []string                              // services whose sessions are not rerouted when the circuit breaker opens
+	RequestTimeout     time.Duration                         // reply timeout error to client if the forwarded request is not responded, disabled if zero
+
+	MembershipListeners []MembershipListener // listeners of the membership events
+	ScheduleMembership  bool                 // deliver the membership events in the scheduler goroutine
+
+	Listener func(addr string) (net.Listener, error)                  // creates the listeners of service and client addresses, TCP by default
+	Dialer   func(ctx context.Context, addr string) (net.Conn, error) // dials the service addresses of members, TCP by default
+
+	SessionIdleTimeout time.Duration // close the client sessions without any packet for the duration, default 2 heartbeats
 }
 
+// RebindHandler represents a callback that will be called when the route of the
+// service has been moved from oldAddr to newAddr because the member of oldAddr left
+// cluster, newAddr will be empty if no member provides the service any more.
+type RebindHandler func(s *session.Session, oldAddr, newAddr string)
+
 // Node represents a node in nano cluster, which will contains a group of services.
 // All services will register to cluster and messages will be forwarded to the node
 // which provides respective service
@@ -70,12 +115,35 @@ type Node struct {
 	handler   *LocalHandler
 	server    *grpc.Server
 	rpcClient *rpcClient
-
-	mu       sync.RWMutex
-	sessions map[int64]*session.Session
-
-	once          sync.Once
-	keepaliveExit chan struct{}
+	transport *transport
+	events    *eventDispatcher
+	bus       *topicBus
+
+	mu          sync.RWMutex
+	sessions    map[int64]*session.Session
+	draining    atomic.Bool
+	metadata    map[string]string // protected by mu
+	incarnation atomic.Uint64     // unique id of each start of current node
+	heartbeatMu sync.Mutex        // serializes heartbeats, so master never receives a stale member information later
+
+	breakers         breakerSet     // circuit breakers of the members called by current node
+	directoryUpdates directoryQueue // updates waiting to be sent to the UID directory in master
+
+	migrateMu  sync.RWMutex
+	migrations map[int64]map[string]*migration // session services migrated from current node
+
+	singletonMu       sync.Mutex
+	singletonHookMu   sync.Mutex        // serializes the lifetime hooks of singletons
+	owners            map[string]string // singleton service -> owner elected by master
+	singletonsStarted bool
+	lease             *time.Timer // deactivates the singletons once master has not responded in time
+	leaseExpiry       time.Time
+
+	once           sync.Once
+	keepaliveStop  sync.Once
+	keepaliveExit  chan struct{}
+	keepaliveDone  chan struct{} // closed once the heartbeat goroutine exits
+	clientListener net.Listener
 }
 
 func (n *Node) Startup() error {
@@ -83,7 +151,11 @@ func (n *Node) Startup() error {
 		return errors.New("service address cannot be empty in master node")
 	}
 	n.sessions = map[int64]*session.Session{}
+	n.incarnation.Store(newIncarnation())
+	n.metadata = maps.Clone(n.Options.Metadata)
+	n.events = newEventDispatcher(n.MembershipListeners, n.ScheduleMembership)
 	n.cluster = newCluster(n)
+	n.bus = newTopicBus(n)
 	n.handler = NewHandler(n, n.Pipeline)
 	components := n.Components.List()
 	for _, c := range components {
@@ -97,14 +169,32 @@ func (n *Node) Startup() error {
 	if err := n.initNode(); err != nil {
 		return err
 	}
+	defaultNode.Store(n)
 
-	// Initialize all components
+	// Initialize all components, the singleton components are initialized
+	// once current node becomes the owner
 	for _, c := range components {
-		c.Comp.Init()
+		if !c.IsSingleton() {
+			c.Comp.Init()
+		}
 	}
 	for _, c := range components {
-		c.Comp.AfterInit()
+		if !c.IsSingleton() {
+			c.Comp.AfterInit()
+		}
+	}
+	switch {
+	case n.IsMaster:
+		n.cluster.reassignOwners()
+	case n.AdvertiseAddr == "":
+		// Current node owns all singleton services in singleton mode
+		owners := map[string]string{}
+		for _, service := range n.handler.singletonServices() {
+			owners[service] = n.ServiceAddr
+		}
+		n.applyOwners(owners)
 	}
+	n.startSingletons()
 
 	if n.ClientAddr != "" {
 		go func() {
@@ -134,19 +224,32 @@ func (n *Node) initNode() error {
 		return nil
 	}
 
-	listener, err := net.Listen("tcp", n.ServiceAddr)
+	listener, err := n.listen(n.ServiceAddr)
 	if err != nil {
 		return err
 	}
 
+	serverOptions, dialOptions, err := n.securityOptions()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	incarnationServer, incarnationDial := n.incarnationOptions()
+	serverOptions = append(serverOptions, incarnationServer...)
+	dialOptions = append(dialOptions, incarnationDial...)
+	if n.Dialer != nil {
+		dialOptions = append(dialOptions, grpc.WithContextDialer(n.Dialer))
+	}
+
 	// Initialize the gRPC server and register service
-	n.server = grpc.NewServer()
-	n.rpcClient = newRPCClient()
+	n.server = grpc.NewServer(serverOptions...)
+	n.rpcClient = newRPCClient(n.Options, dialOptions)
+	n.transport = newTransport(n)
 	clusterpb.RegisterMemberServer(n.server, n)
 
 	go func() {
 		err := n.server.Serve(listener)
-		if err != nil {
+		if err != nil && err != grpc.ErrServerStopped {
 			log.Fatalf("Start current node failed: %v", err)
 		}
 	}()
@@ -154,15 +257,17 @@ func (n *Node) initNode() error {
 	if n.IsMaster {
 		clusterpb.RegisterMasterServer(n.server, n.cluster)
 		member := &Member{
-			isMaster: true,
-			memberInfo: &clusterpb.MemberInfo{
-				Label:       n.Label,
-				ServiceAddr: n.ServiceAddr,
-				Services:    n.handler.LocalService(),
-			},
+			isMaster:   true,
+			memberInfo: n.memberInfo(),
 		}
+		n.cluster.mu.Lock()
 		n.cluster.members = append(n.cluster.members, member)
+		n.cluster.mu.Unlock()
 		n.cluster.setRpcClient(n.rpcClient)
+		if n.MemberTableFile != "" {
+			n.cluster.loadMembers()
+			go n.cluster.persistLoop()
+		}
 	} else {
 		pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
 		if err != nil {
@@ -170,23 +275,43 @@ func (n *Node) initNode() error {
 		}
 		client := clusterpb.NewMasterClient(pool.Get())
 		request := &clusterpb.RegisterRequest{
-			MemberInfo: &clusterpb.MemberInfo{
-				Label:       n.Label,
-				ServiceAddr: n.ServiceAddr,
-				Services:    n.handler.LocalService(),
-			},
+			MemberInfo: n.memberInfo(),
+			Cluster:    n.ClusterName,
 		}
 		for {
+			sentAt := time.Now()
 			resp, err := client.Register(context.Background(), request)
 			if err == nil {
 				n.handler.initRemoteService(resp.Members)
 				n.cluster.initMembers(resp.Members)
+				n.cluster.epoch.Store(resp.Epoch)
+				n.cluster.master.Store(resp.Master)
+				n.renewLease(sentAt)
+				n.applyOwners(resp.Owners)
 				break
 			}
+			// The incarnation fenced by master is generated again, which is unlikely
+			// unless the random ids collide
+			if status.Code(err) == codes.FailedPrecondition {
+				n.incarnation.Store(newIncarnation())
+				request.MemberInfo.Incarnation = n.Incarnation()
+				logger.Logger.Tracef("Register stale incarnation, retry with a new incarnation [%d]", n.Incarnation())
+				continue
+			}
+			// The member will never be accepted by master, so do not retry
+			switch status.Code(err) {
+			case codes.PermissionDenied, codes.Unauthenticated:
+				n.server.Stop()
+				n.rpcClient.closePool()
+				return err
+			}
 			logger.Logger.Trace("Register current node to cluster failed", err, "and will retry in", n.RetryInterval.String())
 			time.Sleep(n.RetryInterval)
 		}
 		n.once.Do(n.keepalive)
+		if n.ProbeInterval > 0 {
+			n.cluster.probeMembers()
+		}
 	}
 	return nil
 }
@@ -194,21 +319,26 @@ func (n *Node) initNode() error {
 // Shutdowns all components registered by application, that
 // call by reverse order against register
 func (n *Node) Shutdown() {
+	// The singleton components are shut down first because they were initialized last
+	n.stopSingletons()
+
 	// reverse call `BeforeShutdown` hooks
 	components := n.Components.List()
 	length := len(components)
 	for i := length - 1; i >= 0; i-- {
-		components[i].Comp.BeforeShutdown()
+		if !components[i].IsSingleton() {
+			components[i].Comp.BeforeShutdown()
+		}
 	}
 
 	// reverse call `Shutdown` hooks
 	for i := length - 1; i >= 0; i-- {
-		components[i].Comp.Shutdown()
+		if !components[i].IsSingleton() {
+			components[i].Comp.Shutdown()
+		}
 	}
 	// close sendHeartbeat
-	if n.keepaliveExit != nil {
-		close(n.keepaliveExit)
-	}
+	n.stopKeepalive()
 	if !n.IsMaster && n.AdvertiseAddr != "" {
 		pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
 		if err != nil {
@@ -218,6 +348,7 @@ func (n *Node) Shutdown() {
 		client := clusterpb.NewMasterClient(pool.Get())
 		request := &clusterpb.UnregisterRequest{
 			ServiceAddr: n.ServiceAddr,
+			Incarnation: n.Incarnation(),
 		}
 		_, err = client.Unregister(context.Background(), request)
 		if err != nil {
@@ -227,21 +358,96 @@ func (n *Node) Shutdown() {
 	}
 
 EXIT:
+	n.close(true)
+}
+
+// Kill stops current node abruptly without calling the shutdown hooks of components and
+// unregistering from master, the master will remove current node after the heartbeat
+// timeout. It simulates a crashed node in tests, so the client connections accepted by
+// current node are closed as well.
+func (n *Node) Kill() {
+	n.singletonMu.Lock()
+	n.singletonsStarted = false
+	n.stopLease()
+	n.singletonMu.Unlock()
+	n.stopKeepalive()
+	n.close(false)
+
+	n.mu.RLock()
+	var agents []*agent
+	for _, s := range n.sessions {
+		if a, ok := s.NetworkEntity().(*agent); ok {
+			agents = append(agents, a)
+		}
+	}
+	n.mu.RUnlock()
+	for _, a := range agents {
+		a.Close()
+	}
+}
+
+// stopKeepalive stops sending heartbeats to master and waits for the heartbeat in
+// flight, it can be called more than once
+func (n *Node) stopKeepalive() {
+	n.keepaliveStop.Do(func() {
+		if n.keepaliveExit != nil {
+			close(n.keepaliveExit)
+		}
+		if n.keepaliveDone != nil {
+			<-n.keepaliveDone
+		}
+	})
+}
+
+// close releases the resources of current node
+func (n *Node) close(graceful bool) {
+	defaultNode.CompareAndSwap(n, nil)
+	n.events.close()
+	n.cluster.close()
+	if n.transport != nil {
+		n.transport.close()
+	}
 	if n.server != nil {
-		n.server.GracefulStop()
+		if graceful {
+			n.server.GracefulStop()
+		} else {
+			n.server.Stop()
+		}
+	}
+	if n.rpcClient != nil {
+		n.rpcClient.closePool()
+	}
+	n.mu.Lock()
+	if n.clientListener != nil {
+		n.clientListener.Close()
+	}
+	n.mu.Unlock()
+}
+
+// listen creates the listener of addr by the Listener option, or a TCP listener
+func (n *Node) listen(addr string) (net.Listener, error) {
+	if n.Listener != nil {
+		return n.Listener(addr)
 	}
+	return net.Listen("tcp", addr)
 }
 
 // Enable current server accept connection
 func (n *Node) listenAndServe() {
-	listener, err := net.Listen("tcp", n.ClientAddr)
+	listener, err := n.listen(n.ClientAddr)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	n.mu.Lock()
+	n.clientListener = listener
+	n.mu.Unlock()
 
 	defer listener.Close()
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			logger.Logger.Tracef(err.Error())
 			continue
@@ -327,6 +533,37 @@ func (n *Node) listenAndServeWSTLS() {
 	}
 }
 
+// memberInfo returns the member information of current node, the load
+// is the count of sessions stored in current node
+func (n *Node) memberInfo() *clusterpb.MemberInfo {
+	n.mu.RLock()
+	load := int64(len(n.sessions))
+	metadata := maps.Clone(n.metadata)
+	n.mu.RUnlock()
+	info := &clusterpb.MemberInfo{
+		Label:       n.Label,
+		ServiceAddr: n.ServiceAddr,
+		Services:    n.handler.LocalService(),
+		Load:        load,
+		Draining:    n.draining.Load(),
+		Metadata:    metadata,
+		Singletons:  n.handler.singletonServices(),
+		Incarnation: n.Incarnation(),
+	}
+	if n.LoadReporter != nil {
+		info.Loads = n.LoadReporter()
+	}
+	return info
+}
+
+// ConnPoolStats returns the statistics of the connection pools to other members
+func (n *Node) ConnPoolStats() []ConnPoolStats {
+	if n.rpcClient == nil {
+		return nil
+	}
+	return n.rpcClient.stats()
+}
+
 func (n *Node) storeSession(s *session.Session) {
 	n.mu.Lock()
 	n.sessions[s.ID()] = s
@@ -345,18 +582,25 @@ func (n *Node) findOrCreateSession(sid int64, gateAddr string) (*session.Session
 	s, found := n.sessions[sid]
 	n.mu.RUnlock()
 	if !found {
-		conns, err := n.rpcClient.getConnPool(gateAddr)
-		if err != nil {
+		if _, err := n.rpcClient.getConnPool(gateAddr); err != nil {
 			return nil, err
 		}
 		ac := &acceptor{
-			sid:        sid,
-			gateClient: clusterpb.NewMemberClient(conns.Get()),
-			rpcHandler: n.handler.remoteProcess,
-			gateAddr:   gateAddr,
+			sid:         sid,
+			transport:   n.transport,
+			rpcHandler:  n.handler.remoteProcess,
+			callHandler: n.handler.call,
+			gateAddr:    gateAddr,
 		}
 		s = session.New(ac)
 		ac.session = s
+		var hook func(key string)
+		if n.SyncSession {
+			hook = n.syncHook(ac)
+		}
+		if hook = n.directoryHook(ac, hook); hook != nil {
+			s.SetChangeHook(hook)
+		}
 		n.mu.Lock()
 		n.sessions[sid] = s
 		n.mu.Unlock()
@@ -364,41 +608,119 @@ func (n *Node) findOrCreateSession(sid int64, gateAddr string) (*session.Session
 	return s, nil
 }
 
+// rebindSessions re-routes the services bound to the removed member address of all
+// sessions in current node, and emits the rebind callback of respective service, the
+// removed member will not be notified of the close of sessions
+func (n *Node) rebindSessions(addr string) {
+	n.mu.RLock()
+	sessions := make([]*session.Session, 0, len(n.sessions))
+	for _, s := range n.sessions {
+		sessions = append(sessions, s)
+	}
+	n.mu.RUnlock()
+
+	for _, s := range sessions {
+		forgetSessionData(s, addr)
+		forgetSessionMember(s, addr)
+		var services []string
+		s.Router().Range(func(service, address string) bool {
+			if address == addr {
+				services = append(services, service)
+			}
+			return true
+		})
+
+		for _, service := range services {
+			s.Router().Delete(service)
+			var newAddr string
+			if members := routableMembers(n.handler.findMembers(service)); len(members) > 0 {
+				if member := n.handler.routeStrategy(service)(service, s, members); member != nil {
+					newAddr = member.ServiceAddr
+					s.Router().Bind(service, newAddr)
+				}
+			}
+
+			logger.Logger.Tracef("Rebind session[%d] service[%s] from [%s] to [%s]", s.ID(), service, addr, newAddr)
+			if handler := n.RebindHandlers[service]; handler != nil {
+				scheduler.PushTask(func() { handler(s, addr, newAddr) })
+			}
+		}
+	}
+}
+
 func (n *Node) HandleRequest(_ context.Context, req *clusterpb.RequestMessage) (*clusterpb.MemberHandleResponse, error) {
-	handler, found := n.handler.localHandlers[req.Route]
+	n.migrateMu.RLock()
+	defer n.migrateMu.RUnlock()
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Request{Request: req}}
+	if n.redirect(req.SessionId, req.Route, m) {
+		return &clusterpb.MemberHandleResponse{}, nil
+	}
+	if err := n.handleRequest(req); err != nil {
+		return nil, err
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
+
+// handleRequest processes the request forwarded from gate in current node
+func (n *Node) handleRequest(req *clusterpb.RequestMessage) error {
+	handler, found := n.handler.localHandler(req.Route)
 	if !found {
-		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
+		return fmt.Errorf("service not found in current node: %v", req.Route)
 	}
 	s, err := n.findOrCreateSession(req.SessionId, req.GateAddr)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	n.applySessionData(s, req.Session)
 	msg := &message.Message{
-		Type:  message.Request,
-		ID:    req.Id,
-		Route: req.Route,
-		Data:  req.Data,
+		Type:     message.Request,
+		ID:       req.Id,
+		Route:    req.Route,
+		Data:     req.Data,
+		Metadata: req.Metadata,
+	}
+	if req.Deadline > 0 {
+		msg.Deadline = time.Unix(0, req.Deadline)
 	}
 	n.handler.localProcess(handler, req.Id, s, msg)
-	return &clusterpb.MemberHandleResponse{}, nil
+	return nil
 }
 
 func (n *Node) HandleNotify(_ context.Context, req *clusterpb.NotifyMessage) (*clusterpb.MemberHandleResponse, error) {
-	handler, found := n.handler.localHandlers[req.Route]
+	n.migrateMu.RLock()
+	defer n.migrateMu.RUnlock()
+	m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Notify{Notify: req}}
+	if n.redirect(req.SessionId, req.Route, m) {
+		return &clusterpb.MemberHandleResponse{}, nil
+	}
+	if err := n.handleNotify(req); err != nil {
+		return nil, err
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
+
+// handleNotify processes the notify forwarded from gate in current node
+func (n *Node) handleNotify(req *clusterpb.NotifyMessage) error {
+	handler, found := n.handler.localHandler(req.Route)
 	if !found {
-		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
+		return fmt.Errorf("service not found in current node: %v", req.Route)
 	}
 	s, err := n.findOrCreateSession(req.SessionId, req.GateAddr)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	n.applySessionData(s, req.Session)
 	msg := &message.Message{
-		Type:  message.Notify,
-		Route: req.Route,
-		Data:  req.Data,
+		Type:     message.Notify,
+		Route:    req.Route,
+		Data:     req.Data,
+		Metadata: req.Metadata,
+	}
+	if req.Deadline > 0 {
+		msg.Deadline = time.Unix(0, req.Deadline)
 	}
 	n.handler.localProcess(handler, 0, s, msg)
-	return &clusterpb.MemberHandleResponse{}, nil
+	return nil
 }
 
 func (n *Node) HandlePush(_ context.Context, req *clusterpb.PushMessage) (*clusterpb.MemberHandleResponse, error) {
@@ -418,27 +740,67 @@ func (n *Node) HandleResponse(_ context.Context, req *clusterpb.ResponseMessage)
 }
 
 func (n *Node) NewMember(_ context.Context, req *clusterpb.NewMemberRequest) (*clusterpb.NewMemberResponse, error) {
-	n.handler.addRemoteService(req.MemberInfo)
-	n.cluster.addMember(req.MemberInfo)
+	// Current node has been marked as draining by master
+	if req.MemberInfo.ServiceAddr == n.ServiceAddr {
+		if req.MemberInfo.Draining {
+			n.draining.Store(true)
+		}
+		return &clusterpb.NewMemberResponse{}, nil
+	}
+	old, ok := n.cluster.addMember(req.MemberInfo)
+	switch {
+	case !ok:
+		logger.Logger.Tracef("Ignore stale incarnation [%d] of member [%s]", req.MemberInfo.Incarnation, req.MemberInfo.ServiceAddr)
+	case old == nil:
+		n.handler.addRemoteService(req.MemberInfo)
+	default:
+		n.handler.replaceMember(old, req.MemberInfo)
+		// The member has restarted, the stream to the previous process is broken
+		if old.Incarnation != req.MemberInfo.Incarnation {
+			n.transport.closeStream(old.ServiceAddr)
+		}
+	}
+	n.cluster.advanceEpoch(req.Epoch)
 	return &clusterpb.NewMemberResponse{}, nil
 }
 
 func (n *Node) DelMember(_ context.Context, req *clusterpb.DelMemberRequest) (*clusterpb.DelMemberResponse, error) {
 	logger.Logger.Tracef("DelMember member [%v]", req.String())
-	n.handler.delMember(req.ServiceAddr)
-	n.cluster.delMember(req.ServiceAddr)
+	if n.cluster.removable(req.ServiceAddr, req.Incarnation) {
+		n.removeMember(req.ServiceAddr)
+	}
+	n.cluster.advanceEpoch(req.Epoch)
 	return &clusterpb.DelMemberResponse{}, nil
 }
 
+// removeMember removes the member left cluster and the resources related to it, the
+// member is removed from the member list at last, so the sessions have been rebound
+// once the member is not found in Members
+func (n *Node) removeMember(addr string) {
+	n.handler.delMember(addr)
+	n.forgetBreaker(addr)
+	n.transport.closeStream(addr)
+	n.rpcClient.closeConnPool(addr)
+	n.rebindSessions(addr)
+	leaveGroupsOfGate(addr)
+	n.cluster.delMember(addr)
+}
+
 // SessionClosed implements the MemberServer interface
 func (n *Node) SessionClosed(_ context.Context, req *clusterpb.SessionClosedRequest) (*clusterpb.SessionClosedResponse, error) {
 	n.mu.Lock()
 	s, found := n.sessions[req.SessionId]
 	delete(n.sessions, req.SessionId)
 	n.mu.Unlock()
+	n.forgetMigrations(req.SessionId, "")
 	if found {
+		// The messages of session may have been forwarded to other members
+		n.notifySessionClosed(s, req)
 		scheduler.PushTask(func() { session.Lifetime.Close(s) })
 	}
+	if req.GateAddr != "" {
+		leaveGroups(req.GateAddr, req.SessionId, req.Uid)
+	}
 	return &clusterpb.SessionClosedResponse{}, nil
 }
 
@@ -448,7 +810,13 @@ func (n *Node) CloseSession(_ context.Context, req *clusterpb.CloseSessionReques
 	s, found := n.sessions[req.SessionId]
 	delete(n.sessions, req.SessionId)
 	n.mu.Unlock()
-	if found {
+	if !found {
+		return &clusterpb.CloseSessionResponse{}, nil
+	}
+	// The messages pushed before closing are written to client, e.g. the kick reason
+	if a, ok := s.NetworkEntity().(*agent); ok {
+		a.closeAfterFlush()
+	} else {
 		s.Close()
 	}
 	return &clusterpb.CloseSessionResponse{}, nil
@@ -462,25 +830,43 @@ func (n *Node) keepalive() {
 	if n.AdvertiseAddr == "" || n.IsMaster {
 		return
 	}
+	var masterLost bool
 	heartbeat := func() {
+		n.heartbeatMu.Lock()
+		defer n.heartbeatMu.Unlock()
 		pool, err := n.rpcClient.getConnPool(n.AdvertiseAddr)
-		if err != nil {
-			logger.Logger.Tracef("rpcClient master conn [%v]", err)
-			return
+		if err == nil {
+			masterCli := clusterpb.NewMasterClient(pool.Get())
+			// The heartbeat is bounded by its interval, so an unreachable master never
+			// blocks the metadata updates waiting for heartbeatMu
+			ctx, cancel := context.WithTimeout(context.Background(), env.Heartbeat)
+			sentAt := time.Now()
+			var resp *clusterpb.HeartbeatResponse
+			resp, err = masterCli.Heartbeat(ctx, n.heartbeatRequest())
+			cancel()
+			if err == nil {
+				if masterLost {
+					masterLost = false
+					n.cluster.suspect(n.AdvertiseAddr, false)
+					n.events.emit(MasterRecovered{Addr: n.AdvertiseAddr})
+				}
+				n.renewLease(sentAt)
+				n.applyHeartbeat(resp)
+				return
+			}
 		}
-		masterCli := clusterpb.NewMasterClient(pool.Get())
-		if _, err := masterCli.Heartbeat(context.Background(), &clusterpb.HeartbeatRequest{
-			MemberInfo: &clusterpb.MemberInfo{
-				Label:       n.Label,
-				ServiceAddr: n.ServiceAddr,
-				Services:    n.handler.LocalService(),
-			},
-		}); err != nil {
-			logger.Logger.Tracef("Member send heartbeat error [%v]", err)
+
+		logger.Logger.Tracef("Member send heartbeat error [%v]", err)
+		if !masterLost {
+			masterLost = true
+			n.cluster.suspect(n.AdvertiseAddr, true)
+			n.events.emit(MasterLost{Addr: n.AdvertiseAddr, Error: err})
 		}
 	}
+	ticker := time.NewTicker(env.Heartbeat)
+	n.keepaliveDone = make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(env.Heartbeat)
+		defer close(n.keepaliveDone)
 		for {
 			select {
 			case <-ticker.C:
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+func TestNodeRebindSessions(t *testing.T) {
+	go scheduler.Sched()
+
+	type rebind struct {
+		sid              int64
+		oldAddr, newAddr string
+	}
+	rebinds := make(chan rebind, 4)
+	members := testMembers(2)
+	n := &Node{
+		Options: Options{
+			RebindHandlers: map[string]RebindHandler{
+				"Room": func(s *session.Session, oldAddr, newAddr string) {
+					rebinds <- rebind{s.ID(), oldAddr, newAddr}
+				},
+			},
+		},
+		sessions: map[int64]*session.Session{},
+	}
+	n.handler = NewHandler(n, nil)
+	n.handler.initRemoteService(members)
+	n.handler.addRemoteService(&clusterpb.MemberInfo{ServiceAddr: members[0].ServiceAddr, Services: []string{"Chat"}})
+
+	s := session.New(nil)
+	s.Router().Bind("Room", members[0].ServiceAddr)
+	s.Router().Bind("Chat", members[0].ServiceAddr)
+	n.storeSession(s)
+	unaffected := session.New(nil)
+	unaffected.Router().Bind("Room", members[1].ServiceAddr)
+	n.storeSession(unaffected)
+
+	n.handler.delMember(members[0].ServiceAddr)
+	n.rebindSessions(members[0].ServiceAddr)
+
+	if addr, _ := s.Router().Find("Room"); addr != members[1].ServiceAddr {
+		t.Fatalf("expect Room rebound to %s, got: %s", members[1].ServiceAddr, addr)
+	}
+	if _, found := s.Router().Find("Chat"); found {
+		t.Fatal("expect Chat route invalidated")
+	}
+	if addr, _ := unaffected.Router().Find("Room"); addr != members[1].ServiceAddr {
+		t.Fatalf("unaffected session route changed to: %s", addr)
+	}
+
+	select {
+	case r := <-rebinds:
+		want := rebind{s.ID(), members[0].ServiceAddr, members[1].ServiceAddr}
+		if r != want {
+			t.Fatalf("expect: %+v, got: %+v", want, r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rebind handler not called")
+	}
+	select {
+	case r := <-rebinds:
+		t.Fatalf("unexpected rebind: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
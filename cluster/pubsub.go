@@ -0,0 +1,431 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/scheduler"
+)
+
+// topicsKey is the metadata key which advertises the topic patterns subscribed by
+// current node, so the publishers only send the messages to the interested members
+const topicsKey = "nano.topics"
+
+// maxReplayTopics is the count of topics whose recent messages are kept for replay, the
+// buffer of the least recently published topic is evicted once exceeded
+const maxReplayTopics = 1024
+
+type (
+	// TopicMessage represents a message received from a topic
+	TopicMessage struct {
+		Topic     string
+		Publisher string // service address of the publisher
+		Data      []byte
+	}
+
+	// TopicHandler represents a callback which receives the messages of subscribed
+	// topics, it will be called in the scheduler goroutine
+	TopicHandler func(m *TopicMessage)
+
+	// Subscription represents the subscription of a topic pattern
+	Subscription struct {
+		bus     *topicBus
+		pattern string
+		handler TopicHandler
+		closed  atomic.Bool
+
+		mu        sync.Mutex
+		replaying bool
+		pending   []*clusterpb.PublishMessage // messages received while replaying
+	}
+)
+
+// Decode deserializes the data of message to v, the raw data will be assigned to
+// v directly if v is a *[]byte
+func (m *TopicMessage) Decode(v interface{}) error {
+	return decodeReply(m.Data, v)
+}
+
+// Pattern returns the topic pattern of the subscription
+func (s *Subscription) Pattern() string {
+	return s.pattern
+}
+
+// Unsubscribe stops the subscription, the messages have been scheduled may be
+// still delivered
+func (s *Subscription) Unsubscribe() error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	if s.bus.remove(s) {
+		return s.bus.advertise()
+	}
+	return nil
+}
+
+func (s *Subscription) deliver(m *clusterpb.PublishMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying {
+		s.pending = append(s.pending, m)
+		return
+	}
+	s.dispatch(m)
+}
+
+// dispatch schedules the handler with the message, it should be called with mu held
+func (s *Subscription) dispatch(m *clusterpb.PublishMessage) {
+	msg := &TopicMessage{Topic: m.Topic, Publisher: m.Publisher, Data: m.Data}
+	scheduler.PushTask(func() {
+		if !s.closed.Load() {
+			s.handler(msg)
+		}
+	})
+}
+
+// replayKey identifies a message published by a member
+type replayKey struct {
+	publisher string
+	seq       uint64
+}
+
+// finishReplay delivers the replayed messages followed by the messages received
+// while replaying, the replayed messages which have been received are skipped
+func (s *Subscription) finishReplay(messages []*clusterpb.PublishMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Publisher != messages[j].Publisher {
+			return messages[i].Publisher < messages[j].Publisher
+		}
+		return messages[i].Seq < messages[j].Seq
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	received := make(map[replayKey]struct{}, len(s.pending))
+	for _, m := range s.pending {
+		received[replayKey{m.Publisher, m.Seq}] = struct{}{}
+	}
+	for _, m := range messages {
+		if _, found := received[replayKey{m.Publisher, m.Seq}]; !found {
+			s.dispatch(m)
+		}
+	}
+	for _, m := range s.pending {
+		s.dispatch(m)
+	}
+	s.pending = nil
+	s.replaying = false
+}
+
+// topicBus maintains the subscriptions of current node and the replay buffers of
+// the messages published by current node
+type topicBus struct {
+	node *Node
+
+	mu       sync.Mutex
+	subs     map[*Subscription]struct{}
+	patterns map[string]int // pattern -> count of subscriptions
+	seq      uint64
+	replay   map[string][]*clusterpb.PublishMessage // topic -> recent messages
+
+	advertiseMu sync.Mutex
+}
+
+func newTopicBus(node *Node) *topicBus {
+	return &topicBus{
+		node:     node,
+		subs:     map[*Subscription]struct{}{},
+		patterns: map[string]int{},
+		// The sequence starts from the startup time, so the messages published after
+		// restart will not be taken as the replayed ones by subscribers
+		seq:    uint64(time.Now().UnixNano()),
+		replay: map[string][]*clusterpb.PublishMessage{},
+	}
+}
+
+// add adds the subscription and reports whether the pattern is newly subscribed
+func (b *topicBus) add(s *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	b.patterns[s.pattern]++
+	return b.patterns[s.pattern] == 1
+}
+
+// remove removes the subscription and reports whether the pattern is no longer subscribed
+func (b *topicBus) remove(s *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := b.subs[s]; !found {
+		return false
+	}
+	delete(b.subs, s)
+	b.patterns[s.pattern]--
+	if b.patterns[s.pattern] > 0 {
+		return false
+	}
+	delete(b.patterns, s.pattern)
+	return true
+}
+
+// advertise publishes the subscribed patterns in the metadata of current node
+func (b *topicBus) advertise() error {
+	b.advertiseMu.Lock()
+	defer b.advertiseMu.Unlock()
+
+	b.mu.Lock()
+	patterns := make([]string, 0, len(b.patterns))
+	for p := range b.patterns {
+		patterns = append(patterns, p)
+	}
+	b.mu.Unlock()
+	if len(patterns) == 0 {
+		return b.node.DeleteMetadata(topicsKey)
+	}
+	sort.Strings(patterns)
+	return b.node.SetMetadata(topicsKey, strings.Join(patterns, ","))
+}
+
+// publish assigns the sequence to a new message and records it in the replay buffer
+func (b *topicBus) publish(topic string, data []byte) *clusterpb.PublishMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	m := &clusterpb.PublishMessage{Topic: topic, Data: data, Publisher: b.node.ServiceAddr, Seq: b.seq}
+	if size := b.node.TopicReplaySize; size > 0 {
+		buffer := append(b.replay[topic], m)
+		if len(buffer) > size {
+			buffer = buffer[len(buffer)-size:]
+		}
+		b.replay[topic] = buffer
+		if len(b.replay) > maxReplayTopics {
+			b.evictReplay()
+		}
+	}
+	return m
+}
+
+// evictReplay evicts the buffer of the least recently published topic, it should be
+// called with mu held
+func (b *topicBus) evictReplay() {
+	var oldest string
+	var seq uint64
+	for topic, buffer := range b.replay {
+		if last := buffer[len(buffer)-1].Seq; oldest == "" || last < seq {
+			oldest, seq = topic, last
+		}
+	}
+	delete(b.replay, oldest)
+}
+
+// recent returns the buffered messages of the topics matched by pattern
+func (b *topicBus) recent(pattern string) []*clusterpb.PublishMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var messages []*clusterpb.PublishMessage
+	for topic, buffer := range b.replay {
+		if TopicMatch(pattern, topic) {
+			messages = append(messages, buffer...)
+		}
+	}
+	return messages
+}
+
+// deliver delivers the message to the subscriptions matching the topic
+func (b *topicBus) deliver(m *clusterpb.PublishMessage) {
+	b.mu.Lock()
+	var matched []*Subscription
+	for s := range b.subs {
+		if TopicMatch(s.pattern, m.Topic) {
+			matched = append(matched, s)
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range matched {
+		s.deliver(m)
+	}
+}
+
+// TopicMatch reports whether the topic matches the pattern. Topics consist of segments
+// separated by dots, the wildcard "*" matches exactly one segment and "#" at the end
+// matches zero or more segments, e.g. "chat.*" matches "chat.world" and "guild.#"
+// matches both "guild" and "guild.1.notice".
+func TopicMatch(pattern, topic string) bool {
+	patterns := strings.Split(pattern, ".")
+	segments := strings.Split(topic, ".")
+	for i, p := range patterns {
+		if p == "#" {
+			return true
+		}
+		if i >= len(segments) || (p != "*" && p != segments[i]) {
+			return false
+		}
+	}
+	return len(patterns) == len(segments)
+}
+
+// checkTopic validates the topic, the wildcards are allowed in patterns only
+func checkTopic(topic string, pattern bool) error {
+	segments := strings.Split(topic, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == "" || strings.ContainsAny(segment, ", \t\r\n"):
+			return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+		case segment == "*" || segment == "#":
+			if !pattern || (segment == "#" && i != len(segments)-1) {
+				return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+			}
+		case strings.ContainsAny(segment, "*#"):
+			return fmt.Errorf("%w: %q", ErrInvalidTopic, topic)
+		}
+	}
+	return nil
+}
+
+// subscribed reports whether the member has subscribed a pattern matching the topic
+func subscribed(info *clusterpb.MemberInfo, topic string) bool {
+	patterns := info.Metadata[topicsKey]
+	if patterns == "" {
+		return false
+	}
+	for _, p := range strings.Split(patterns, ",") {
+		if TopicMatch(p, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe subscribes the topic pattern, the handler receives the messages published
+// by all members after the subscription has been propagated to them. The delivery
+// is at-most-once, messages published to the lost members are dropped, and the messages
+// published concurrently by a member may be delivered in a different order.
+func (n *Node) Subscribe(pattern string, handler TopicHandler) (*Subscription, error) {
+	return n.subscribe(pattern, handler, false)
+}
+
+// SubscribeReplay subscribes the topic pattern as Subscribe, and the recent messages
+// buffered by the publishers will be delivered first, see Options.TopicReplaySize
+func (n *Node) SubscribeReplay(pattern string, handler TopicHandler) (*Subscription, error) {
+	return n.subscribe(pattern, handler, true)
+}
+
+func (n *Node) subscribe(pattern string, handler TopicHandler, replay bool) (*Subscription, error) {
+	if n.bus == nil {
+		return nil, ErrNodeNotStarted
+	}
+	if err := checkTopic(pattern, true); err != nil {
+		return nil, err
+	}
+	s := &Subscription{
+		bus:       n.bus,
+		pattern:   pattern,
+		handler:   handler,
+		replaying: replay,
+	}
+	if n.bus.add(s) {
+		// The metadata will be sent in next heartbeat if master is unavailable
+		if err := n.bus.advertise(); err != nil {
+			logger.Logger.Tracef("Advertise topic %s failed: %v", pattern, err)
+		}
+	}
+	if replay {
+		s.finishReplay(n.replayMessages(pattern))
+	}
+	return s, nil
+}
+
+// replayMessages collects the buffered messages matching the pattern from all members
+func (n *Node) replayMessages(pattern string) []*clusterpb.PublishMessage {
+	messages := n.bus.recent(pattern)
+	for _, info := range n.cluster.memberInfos() {
+		if info.ServiceAddr == n.ServiceAddr {
+			continue
+		}
+		pool, err := n.rpcClient.getConnPool(info.ServiceAddr)
+		if err != nil {
+			logger.Logger.Tracef("Replay topic %s from %s failed: %v", pattern, info.ServiceAddr, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+		resp, err := clusterpb.NewMemberClient(pool.Get()).Replay(ctx, &clusterpb.ReplayRequest{Pattern: pattern})
+		cancel()
+		if err != nil {
+			logger.Logger.Tracef("Replay topic %s from %s failed: %v", pattern, info.ServiceAddr, err)
+			continue
+		}
+		messages = append(messages, resp.Messages...)
+	}
+	return messages
+}
+
+// Publish publishes the message to topic, the message will be sent to the members
+// subscribing the topic without waiting for the handling, and the first error of
+// sending will be returned.
+func (n *Node) Publish(topic string, v interface{}) error {
+	if n.bus == nil {
+		return ErrNodeNotStarted
+	}
+	if err := checkTopic(topic, false); err != nil {
+		return err
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	m := n.bus.publish(topic, data)
+	n.bus.deliver(m)
+	var first error
+	for _, info := range n.cluster.memberInfos() {
+		if info.ServiceAddr == n.ServiceAddr || !subscribed(info, topic) {
+			continue
+		}
+		sm := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Publish{Publish: m}}
+		if err := n.transport.send(context.Background(), info.ServiceAddr, sm); err != nil && first == nil {
+			first = fmt.Errorf("publish %s to %s: %w", topic, info.ServiceAddr, err)
+		}
+	}
+	return first
+}
+
+// HandlePublish implements the MemberServer interface, it delivers the message to
+// the subscriptions of current node
+func (n *Node) HandlePublish(_ context.Context, req *clusterpb.PublishMessage) (*clusterpb.MemberHandleResponse, error) {
+	n.bus.deliver(req)
+	return &clusterpb.MemberHandleResponse{}, nil
+}
+
+// Replay implements the MemberServer interface, it returns the messages published by
+// current node recently whose topics match the pattern
+func (n *Node) Replay(_ context.Context, req *clusterpb.ReplayRequest) (*clusterpb.ReplayResponse, error) {
+	return &clusterpb.ReplayResponse{Messages: n.bus.recent(req.Pattern)}, nil
+}
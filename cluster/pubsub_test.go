@@ -0,0 +1,222 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+)
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"chat.world", "chat.world", true},
+		{"chat.world", "chat.guild", false},
+		{"chat.*", "chat.world", true},
+		{"chat.*", "chat", false},
+		{"chat.*", "chat.world.1", false},
+		{"*.notice", "guild.notice", true},
+		{"guild.#", "guild", true},
+		{"guild.#", "guild.1.notice", true},
+		{"guild.#", "chat.1", false},
+		{"#", "any.topic", true},
+	}
+	for _, c := range cases {
+		if got := TopicMatch(c.pattern, c.topic); got != c.match {
+			t.Fatalf("TopicMatch(%q, %q) = %v, expect %v", c.pattern, c.topic, got, c.match)
+		}
+	}
+
+	for _, topic := range []string{"", "chat.", "chat..world", "chat.#.world", "chat.w*", "chat,world"} {
+		if err := checkTopic(topic, true); !errors.Is(err, ErrInvalidTopic) {
+			t.Fatalf("expect topic %q invalid, got: %v", topic, err)
+		}
+	}
+	if err := checkTopic("chat.*", false); !errors.Is(err, ErrInvalidTopic) {
+		t.Fatalf("expect wildcard invalid in topic, got: %v", err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	go scheduler.Sched()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, TopicReplaySize: 2, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "pubsub.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	var nodes []*Node
+	for _, addr := range []string{"pubsub.a", "pubsub.b"} {
+		node := &Node{
+			Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := node.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		defer node.Shutdown()
+		nodes = append(nodes, node)
+	}
+	a, b := nodes[0], nodes[1]
+	waitFor(t, func() bool { return len(b.cluster.memberInfos()) >= 2 })
+
+	received := make(chan string, 16)
+	record := func(m *TopicMessage) {
+		ping := &testdata.Ping{}
+		if err := m.Decode(ping); err != nil {
+			t.Error(err)
+		}
+		received <- m.Topic + " " + ping.Content
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-received:
+				if got != w {
+					t.Fatalf("expect %q received, got: %q", w, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("message %q not received", w)
+			}
+		}
+		select {
+		case got := <-received:
+			t.Fatalf("unexpected message received: %q", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	interested := func(n *Node, addr, topic string) bool {
+		for _, info := range n.cluster.memberInfos() {
+			if info.ServiceAddr == addr {
+				return subscribed(info, topic)
+			}
+		}
+		return false
+	}
+
+	// remote subscriber with wildcard
+	chat, err := a.Subscribe("chat.*", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return interested(b, a.ServiceAddr, "chat.world") })
+	if err := b.Publish("chat.world", &testdata.Ping{Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish("guild.1", &testdata.Ping{Content: "ignored"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("chat.world hi")
+
+	// local subscriber
+	if _, err := b.Subscribe("guild.#", record); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish("guild.1.notice", &testdata.Ping{Content: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("guild.1.notice local")
+
+	// late subscriber receives the recent messages
+	for _, content := range []string{"1", "2", "3"} {
+		if err := master.Publish("news.daily", &testdata.Ping{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	news, err := a.SubscribeReplay("news.*", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("news.daily 2", "news.daily 3")
+	waitFor(t, func() bool { return interested(master, a.ServiceAddr, "news.daily") })
+	if err := master.Publish("news.daily", &testdata.Ping{Content: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("news.daily 4")
+
+	// unsubscribed
+	if err := chat.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if err := news.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !interested(b, a.ServiceAddr, "chat.world") })
+	if err := b.Publish("chat.world", &testdata.Ping{Content: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+
+	if err := b.Publish("chat.*", &testdata.Ping{}); !errors.Is(err, ErrInvalidTopic) {
+		t.Fatalf("expect invalid topic, got: %v", err)
+	}
+}
+
+func TestSubscriptionOrder(t *testing.T) {
+	go scheduler.Sched()
+
+	received := make(chan uint64, 16)
+	s := &Subscription{
+		handler:   func(m *TopicMessage) { received <- uint64(m.Data[0]) },
+		replaying: true,
+	}
+	message := func(seq uint64) *clusterpb.PublishMessage {
+		return &clusterpb.PublishMessage{Topic: "chat", Publisher: "a", Seq: seq, Data: []byte{byte(seq)}}
+	}
+	expect := func(want ...uint64) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-received:
+				if got != w {
+					t.Fatalf("expect message %d, got: %d", w, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("message %d not received", w)
+			}
+		}
+	}
+
+	// the replayed messages received while replaying are delivered once
+	s.deliver(message(3))
+	s.deliver(message(2))
+	s.finishReplay([]*clusterpb.PublishMessage{message(2), message(1)})
+	expect(1, 3, 2)
+
+	// the live messages received out of order are not dropped
+	s.deliver(message(5))
+	s.deliver(message(4))
+	expect(5, 4)
+}
+
+func TestReplayEviction(t *testing.T) {
+	bus := newTopicBus(&Node{Options: Options{TopicReplaySize: 2}})
+	for i := 0; i < maxReplayTopics; i++ {
+		bus.publish(fmt.Sprintf("topic.%d", i), nil)
+	}
+	bus.publish("topic.0", nil)
+
+	// the least recently published topic is evicted
+	bus.publish("topic.new", nil)
+	if len(bus.replay) != maxReplayTopics {
+		t.Fatalf("expect %d topics buffered, got: %d", maxReplayTopics, len(bus.replay))
+	}
+	if len(bus.recent("topic.1")) != 0 {
+		t.Fatal("expect topic.1 evicted")
+	}
+	if len(bus.recent("topic.0")) != 2 || len(bus.recent("topic.new")) != 1 {
+		t.Fatal("expect recently published topics kept")
+	}
+}
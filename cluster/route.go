@@ -0,0 +1,347 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/session"
+)
+
+// virtualNodes is the count of points every member occupies on the hash ring
+const virtualNodes = 160
+
+// The well-known keys of member metadata
+const (
+	MetadataZone       = "zone"
+	MetadataVersion    = "version"
+	MetadataWeight     = "weight"
+	MetadataMaxPlayers = "max_players"
+)
+
+// HashKey extracts the key used by ConsistentHash from a session
+type HashKey func(s *session.Session) string
+
+// UIDKey hashes sessions by the binding UID
+func UIDKey(s *session.Session) string {
+	return strconv.FormatInt(s.UID(), 10)
+}
+
+// SessionKey hashes sessions by the string value stored under key in session data
+func SessionKey(key string) HashKey {
+	return func(s *session.Session) string {
+		return s.String(key)
+	}
+}
+
+// RandomRoute selects a member randomly, it is the default strategy when no
+// route has been specified for a service
+func RandomRoute() CustomerRemoteServiceRoute {
+	return func(_ string, _ *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		if len(members) == 0 {
+			return nil
+		}
+		return members[rand.Intn(len(members))]
+	}
+}
+
+// RoundRobin selects members one after another
+func RoundRobin() CustomerRemoteServiceRoute {
+	var next uint64
+	return func(_ string, _ *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		if len(members) == 0 {
+			return nil
+		}
+		members = sortedMembers(members)
+		return members[(atomic.AddUint64(&next, 1)-1)%uint64(len(members))]
+	}
+}
+
+// WeightedRandom selects a member randomly with the probability proportional to
+// the weight of member, members with non-positive weight will never be selected
+// unless all members have non-positive weight
+func WeightedRandom(weight func(*clusterpb.MemberInfo) int) CustomerRemoteServiceRoute {
+	return func(_ string, _ *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		if len(members) == 0 {
+			return nil
+		}
+		var total int
+		weights := make([]int, len(members))
+		for i, m := range members {
+			if w := weight(m); w > 0 {
+				weights[i] = w
+				total += w
+			}
+		}
+		if total == 0 {
+			return members[rand.Intn(len(members))]
+		}
+		n := rand.Intn(total)
+		for i, w := range weights {
+			if n < w {
+				return members[i]
+			}
+			n -= w
+		}
+		return members[len(members)-1]
+	}
+}
+
+// routedLoad counts the sessions routed to a member since its load was reported
+type routedLoad struct {
+	reported int64
+	since    time.Time // time of counting the routed sessions from
+	routed   int64
+	round    uint64 // the last selection the member took part in
+}
+
+// LeastLoaded selects the member which has the lowest load, the load reported in
+// heartbeat is stale between heartbeats, so the sessions routed to the member since
+// the load was reported are added to it. The routed sessions are reset once another
+// load is reported, or two heartbeat intervals later even if the same load is reported,
+// when the member has reported the load including them and current node received it.
+// The member is selected randomly if several members have the same lowest load.
+func LeastLoaded() CustomerRemoteServiceRoute {
+	var mu sync.Mutex
+	var round uint64
+	services := map[string]map[string]*routedLoad{}
+	return func(service string, _ *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		mu.Lock()
+		defer mu.Unlock()
+		loads := services[service]
+		if loads == nil {
+			loads = map[string]*routedLoad{}
+			services[service] = loads
+		}
+		round++
+		now := time.Now()
+		var least []*clusterpb.MemberInfo
+		var lowest int64
+		for _, m := range members {
+			l := loads[m.ServiceAddr]
+			if l == nil || l.reported != m.Load || now.Sub(l.since) >= 2*env.Heartbeat {
+				l = &routedLoad{reported: m.Load, since: now}
+				loads[m.ServiceAddr] = l
+			}
+			l.round = round
+			load := l.reported + l.routed
+			switch {
+			case len(least) == 0 || load < lowest:
+				least, lowest = []*clusterpb.MemberInfo{m}, load
+			case load == lowest:
+				least = append(least, m)
+			}
+		}
+		// Forget the members left
+		if len(loads) > len(members) {
+			for addr, l := range loads {
+				if l.round != round {
+					delete(loads, addr)
+				}
+			}
+		}
+		if len(least) == 0 {
+			return nil
+		}
+		selected := least[rand.Intn(len(least))]
+		loads[selected.ServiceAddr].routed++
+		return selected
+	}
+}
+
+// LabelMatch filters members whose label equals to the label extracted from session
+// and delegates the selection to next, all members will be passed to next if none
+// of them matches.
+func LabelMatch(label func(*session.Session) string, next CustomerRemoteServiceRoute) CustomerRemoteServiceRoute {
+	return func(service string, s *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		want := label(s)
+		var matched []*clusterpb.MemberInfo
+		for _, m := range members {
+			if m.Label == want {
+				matched = append(matched, m)
+			}
+		}
+		if len(matched) == 0 {
+			matched = members
+		}
+		return next(service, s, matched)
+	}
+}
+
+// MemberWeight returns the weight in the metadata of member, 1 will be returned if the
+// weight is not specified or invalid, it can be used by WeightedRandom
+func MemberWeight(m *clusterpb.MemberInfo) int {
+	weight, err := strconv.Atoi(m.Metadata[MetadataWeight])
+	if err != nil {
+		return 1
+	}
+	return weight
+}
+
+// MetadataMatch filters members whose metadata value of key equals to the value
+// extracted from session and delegates the selection to next, e.g. match the zone
+// of members, all members will be passed to next if none of them matches.
+func MetadataMatch(key string, value func(*session.Session) string, next CustomerRemoteServiceRoute) CustomerRemoteServiceRoute {
+	return func(service string, s *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		want := value(s)
+		var matched []*clusterpb.MemberInfo
+		for _, m := range members {
+			if m.Metadata[key] == want {
+				matched = append(matched, m)
+			}
+		}
+		if len(matched) == 0 {
+			matched = members
+		}
+		return next(service, s, matched)
+	}
+}
+
+// WithinCapacity filters members whose load is lower than the max players in metadata
+// and delegates the selection to next, members without max players are unlimited.
+// No member will be selected if all members are full.
+func WithinCapacity(next CustomerRemoteServiceRoute) CustomerRemoteServiceRoute {
+	return func(service string, s *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+		var available []*clusterpb.MemberInfo
+		for _, m := range members {
+			max, err := strconv.ParseInt(m.Metadata[MetadataMaxPlayers], 10, 64)
+			if err != nil || m.Load < max {
+				available = append(available, m)
+			}
+		}
+		if len(available) == 0 {
+			return nil
+		}
+		return next(service, s, available)
+	}
+}
+
+// ConsistentHash selects members by consistent hashing the key extracted from session,
+// only about 1/n keys will be remapped when a member joins or leaves.
+// The loadFactor bounds the load of the selected member to loadFactor times of the
+// average load reported in heartbeat, keys will walk clockwise on the ring until a
+// member has free capacity. Bounded load is disabled when loadFactor less than 1.
+func ConsistentHash(key HashKey, loadFactor float64) CustomerRemoteServiceRoute {
+	r := &hashRouter{key: key, loadFactor: loadFactor, rings: map[string]*hashRing{}}
+	return r.route
+}
+
+type hashRouter struct {
+	key        HashKey
+	loadFactor float64
+
+	mu    sync.Mutex
+	rings map[string]*hashRing // service name -> hash ring
+}
+
+type hashRing struct {
+	signature string
+	points    []uint32
+	owners    map[uint32]string // hash point -> service address
+}
+
+func (r *hashRouter) route(service string, s *session.Session, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+	return r.owner(service, r.key(s), members)
+}
+
+// owner returns the member which owns the key on the hash ring of service
+func (r *hashRouter) owner(service, key string, members []*clusterpb.MemberInfo) *clusterpb.MemberInfo {
+	if len(members) == 0 {
+		return nil
+	}
+	ring := r.ring(service, members)
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hash })
+
+	current := make(map[string]*clusterpb.MemberInfo, len(members))
+	var total int64
+	for _, m := range members {
+		current[m.ServiceAddr] = m
+		total += m.Load
+	}
+	capacity := int64(math.MaxInt64)
+	if r.loadFactor >= 1 {
+		capacity = int64(math.Ceil(r.loadFactor * float64(total+1) / float64(len(members))))
+	}
+
+	for i := 0; i < len(ring.points); i++ {
+		owner := current[ring.owners[ring.points[(start+i)%len(ring.points)]]]
+		if owner.Load < capacity {
+			return owner
+		}
+	}
+	return current[ring.owners[ring.points[start%len(ring.points)]]]
+}
+
+// ring returns the hash ring of the service, the ring will be rebuilt if
+// the member list has changed
+func (r *hashRouter) ring(service string, members []*clusterpb.MemberInfo) *hashRing {
+	addrs := make([]string, 0, len(members))
+	for _, m := range members {
+		addrs = append(addrs, m.ServiceAddr)
+	}
+	sort.Strings(addrs)
+	signature := strings.Join(addrs, ",")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ring, found := r.rings[service]; found && ring.signature == signature {
+		return ring
+	}
+
+	ring := &hashRing{
+		signature: signature,
+		points:    make([]uint32, 0, len(members)*virtualNodes),
+		owners:    make(map[uint32]string, len(members)*virtualNodes),
+	}
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			point := crc32.ChecksumIEEE([]byte(m.ServiceAddr + "#" + strconv.Itoa(i)))
+			if _, dup := ring.owners[point]; dup {
+				continue
+			}
+			ring.points = append(ring.points, point)
+			ring.owners[point] = m.ServiceAddr
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	r.rings[service] = ring
+	return ring
+}
+
+// sortedMembers returns a copy of members sorted by service address, which makes
+// the result of strategies independent of the order of member registration
+func sortedMembers(members []*clusterpb.MemberInfo) []*clusterpb.MemberInfo {
+	sorted := make([]*clusterpb.MemberInfo, len(members))
+	copy(sorted, members)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ServiceAddr < sorted[j].ServiceAddr })
+	return sorted
+}
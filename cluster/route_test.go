@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/session"
+)
+
+func testMembers(n int) []*clusterpb.MemberInfo {
+	var members []*clusterpb.MemberInfo
+	for i := 0; i < n; i++ {
+		members = append(members, &clusterpb.MemberInfo{
+			ServiceAddr: fmt.Sprintf("127.0.0.1:%d", 10000+i),
+			Services:    []string{"Room"},
+		})
+	}
+	return members
+}
+
+func testSession(uid int64) *session.Session {
+	s := session.New(nil)
+	s.Bind(uid)
+	return s
+}
+
+func TestRoundRobin(t *testing.T) {
+	route := RoundRobin()
+	members := testMembers(3)
+	for i := 0; i < 6; i++ {
+		m := route("Room", testSession(1), members)
+		if m != members[i%3] {
+			t.Fatalf("round %d expect: %s, got: %s", i, members[i%3].ServiceAddr, m.ServiceAddr)
+		}
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	members := testMembers(3)
+	route := WeightedRandom(func(m *clusterpb.MemberInfo) int {
+		if m == members[1] {
+			return 1
+		}
+		return 0
+	})
+	for i := 0; i < 100; i++ {
+		if m := route("Room", testSession(1), members); m != members[1] {
+			t.Fatalf("expect: %s, got: %s", members[1].ServiceAddr, m.ServiceAddr)
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	members := testMembers(3)
+	members[0].Load = 10
+	members[1].Load = 3
+	members[2].Load = 5
+	heartbeat := env.Heartbeat
+	env.Heartbeat = time.Hour
+	defer func() { env.Heartbeat = heartbeat }()
+	route := LeastLoaded()
+	if m := route("Room", testSession(1), members); m != members[1] {
+		t.Fatalf("expect: %s, got: %s", members[1].ServiceAddr, m.ServiceAddr)
+	}
+
+	// the sessions routed between heartbeats are counted into the load
+	counts := map[*clusterpb.MemberInfo]int{}
+	for i := 0; i < 7; i++ {
+		counts[route("Room", testSession(1), members)]++
+	}
+	if counts[members[0]] != 0 || counts[members[1]] != 4 || counts[members[2]] != 3 {
+		t.Fatalf("unexpected routed sessions: %d, %d, %d", counts[members[0]], counts[members[1]], counts[members[2]])
+	}
+
+	// the routed sessions are reset when the load is reported again
+	members[2].Load = 4
+	if m := route("Room", testSession(1), members); m != members[2] {
+		t.Fatalf("expect: %s, got: %s", members[2].ServiceAddr, m.ServiceAddr)
+	}
+
+	// the routed sessions are reset after the heartbeats even if the same load is reported
+	env.Heartbeat = 0
+	for i := 0; i < 3; i++ {
+		if m := route("Room", testSession(1), members); m != members[1] {
+			t.Fatalf("expect: %s, got: %s", members[1].ServiceAddr, m.ServiceAddr)
+		}
+	}
+}
+
+func TestLabelMatch(t *testing.T) {
+	members := testMembers(3)
+	members[2].Label = "asia"
+	route := LabelMatch(func(*session.Session) string { return "asia" }, RoundRobin())
+	for i := 0; i < 10; i++ {
+		if m := route("Room", testSession(1), members); m != members[2] {
+			t.Fatalf("expect: %s, got: %s", members[2].ServiceAddr, m.ServiceAddr)
+		}
+	}
+
+	route = LabelMatch(func(*session.Session) string { return "europe" }, LeastLoaded())
+	if m := route("Room", testSession(1), members); m == nil {
+		t.Fatal("expect fallback to all members")
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	const keys = 10000
+	route := ConsistentHash(UIDKey, 0)
+	members := testMembers(4)
+
+	before := make(map[int64]string, keys)
+	for uid := int64(1); uid <= keys; uid++ {
+		before[uid] = route("Room", testSession(uid), members).ServiceAddr
+		if again := route("Room", testSession(uid), members).ServiceAddr; again != before[uid] {
+			t.Fatalf("uid %d mapped to %s and %s", uid, before[uid], again)
+		}
+	}
+
+	// a new member should only take over about 1/5 keys
+	members = append(members, testMembers(5)[4])
+	var moved int
+	for uid := int64(1); uid <= keys; uid++ {
+		addr := route("Room", testSession(uid), members).ServiceAddr
+		if addr != before[uid] {
+			if addr != members[4].ServiceAddr {
+				t.Fatalf("uid %d moved between existing members %s -> %s", uid, before[uid], addr)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > keys*2/5 {
+		t.Fatalf("unexpected remapped keys count: %d", moved)
+	}
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	members := testMembers(3)
+	s := testSession(42)
+	owner := ConsistentHash(UIDKey, 0)("Room", s, members)
+	owner.Load = 100
+
+	m := ConsistentHash(UIDKey, 1.25)("Room", s, members)
+	if m == owner {
+		t.Fatalf("overloaded member %s should be skipped", owner.ServiceAddr)
+	}
+}
+
+func TestMetadataRoutes(t *testing.T) {
+	members := testMembers(3)
+	members[0].Metadata = map[string]string{MetadataZone: "asia", MetadataWeight: "5", MetadataMaxPlayers: "10"}
+	members[0].Load = 10
+	members[1].Metadata = map[string]string{MetadataZone: "asia", MetadataWeight: "invalid"}
+	if MemberWeight(members[0]) != 5 || MemberWeight(members[1]) != 1 {
+		t.Fatal("unexpected member weight")
+	}
+
+	route := MetadataMatch(MetadataZone, func(*session.Session) string { return "asia" }, WithinCapacity(LeastLoaded()))
+	for i := 0; i < 10; i++ {
+		if m := route("Room", testSession(1), members); m != members[1] {
+			t.Fatalf("expect: %s, got: %s", members[1].ServiceAddr, m.ServiceAddr)
+		}
+	}
+	if m := WithinCapacity(LeastLoaded())("Room", testSession(1), members[:1]); m != nil {
+		t.Fatalf("expect no member available, got: %s", m.ServiceAddr)
+	}
+}
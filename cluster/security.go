@@ -0,0 +1,186 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenKey is the metadata key of the cluster token
+const tokenKey = "nano-token"
+
+// certReloader loads the certificate and reloads it once the certificate or key
+// file has been modified, so the certificate can be rotated without restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.lastModified()
+	if err == nil && r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err == nil {
+			r.cert, r.modTime = &cert, modTime
+			return r.cert, nil
+		}
+	}
+
+	// Keep using the loaded certificate if the new one is not available
+	if r.cert != nil {
+		logger.Logger.Tracef("Reload certificate failed: %v", err)
+		return r.cert, nil
+	}
+	return nil, err
+}
+
+func (r *certReloader) lastModified() (time.Time, error) {
+	cert, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if key.ModTime().After(cert.ModTime()) {
+		return key.ModTime(), nil
+	}
+	return cert.ModTime(), nil
+}
+
+// tlsConfigs returns the server and client side TLS configurations of mutual TLS,
+// both sides present the certificate and verify the peer by the CA certificate
+func tlsConfigs(certFile, keyFile, caFile string) (*tls.Config, *tls.Config, error) {
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, nil, errors.New("certificate, key and CA certificate are all required by cluster TLS")
+	}
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.certificate(); err != nil {
+		return nil, nil, err
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, nil, fmt.Errorf("no valid certificate found in %s", caFile)
+	}
+
+	server := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		},
+	}
+	client := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		},
+	}
+	return server, client, nil
+}
+
+// tokenCredentials attaches the cluster token to each call
+type tokenCredentials string
+
+// GetRequestMetadata implements the credentials.PerRPCCredentials interface
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{tokenKey: string(t)}, nil
+}
+
+// RequireTransportSecurity implements the credentials.PerRPCCredentials interface
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// checkToken verifies the cluster token carried by the incoming call
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(tokenKey)
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid cluster token")
+	}
+	return nil
+}
+
+// securityOptions returns the gRPC server and dial options of the cluster TLS and token
+func (n *Node) securityOptions() ([]grpc.ServerOption, []grpc.DialOption, error) {
+	var serverOptions []grpc.ServerOption
+	var dialOptions []grpc.DialOption
+	if n.ClusterCertFile != "" || n.ClusterKeyFile != "" || n.ClusterCAFile != "" {
+		server, client, err := tlsConfigs(n.ClusterCertFile, n.ClusterKeyFile, n.ClusterCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(server)))
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(client)))
+	}
+
+	if token := n.ClusterToken; token != "" {
+		unary := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkToken(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}
+		stream := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkToken(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
+		serverOptions = append(serverOptions, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(tokenCredentials(token)))
+	}
+	return serverOptions, dialOptions, nil
+}
@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// writeCert issues a certificate signed by parent, or a self-signed CA certificate
+// if parent is nil, and writes the PEM files into dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"security.master", "security.member"},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClusterSecurity(t *testing.T) {
+	go scheduler.Sched()
+
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node", ca, caKey)
+	secure := func(opts Options) Options {
+		opts.ClusterName = "nano"
+		opts.ClusterToken = "secret"
+		opts.ClusterCertFile = filepath.Join(dir, "node.pem")
+		opts.ClusterKeyFile = filepath.Join(dir, "node.key")
+		opts.ClusterCAFile = filepath.Join(dir, "ca.pem")
+		return opts
+	}
+
+	master := &Node{
+		Options:     secure(Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "security.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	comps := &component.Components{}
+	comps.Register(&AccountComponent{})
+	member := &Node{
+		Options:     secure(Options{AdvertiseAddr: master.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "security.member",
+	}
+	if err := member.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer member.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	pong := &testdata.Pong{}
+	if err := master.Call(ctx, "AccountComponent.Query", &testdata.Ping{Content: "ping"}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "account ping" {
+		t.Fatalf("unexpected reply: %s", pong.Content)
+	}
+
+	// wrong token
+	intruder := &Node{
+		Options:     secure(Options{AdvertiseAddr: master.ServiceAddr, Components: &component.Components{}, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "security.token",
+	}
+	intruder.ClusterToken = "guess"
+	if err := intruder.Startup(); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expect unauthenticated, got: %v", err)
+	}
+
+	// wrong cluster name
+	intruder = &Node{
+		Options:     secure(Options{AdvertiseAddr: master.ServiceAddr, Components: &component.Components{}, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "security.name",
+	}
+	intruder.ClusterName = "other"
+	if err := intruder.Startup(); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expect permission denied, got: %v", err)
+	}
+
+	// member service requires token as well
+	_, client, err := tlsConfigs(master.ClusterCertFile, master.ClusterKeyFile, master.ClusterCAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRPCClient(Options{}, []grpc.DialOption{grpc.WithContextDialer(memDial), grpc.WithTransportCredentials(credentials.NewTLS(client))})
+	defer c.closePool()
+	pool, err := c.getConnPool(member.ServiceAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clusterpb.NewMemberClient(pool.Get()).CloseSession(ctx, &clusterpb.CloseSessionRequest{SessionId: 1})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expect unauthenticated, got: %v", err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	first, _ := writeCert(t, dir, "node", ca, caKey)
+
+	reloader := &certReloader{certFile: filepath.Join(dir, "node.pem"), keyFile: filepath.Join(dir, "node.key")}
+	cert, err := reloader.certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], first.Raw) {
+		t.Fatal("unexpected certificate loaded")
+	}
+
+	// rotate certificate
+	second, _ := writeCert(t, dir, "node", ca, caKey)
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{"node.pem", "node.key"} {
+		if err := os.Chtimes(filepath.Join(dir, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, err = reloader.certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], second.Raw) {
+		t.Fatal("expect certificate reloaded")
+	}
+
+	// keep using the loaded certificate if the files are broken
+	if err := os.WriteFile(filepath.Join(dir, "node.pem"), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "node.pem"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = reloader.certificate()
+	if err != nil || !bytes.Equal(cert.Certificate[0], second.Raw) {
+		t.Fatalf("expect previous certificate kept, got: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/session"
+	"google.golang.org/protobuf/proto"
+)
+
+// The session data synchronization keeps the UID and the values of the keys listed in
+// Options.SyncSessionKeys consistent between the session in gate and the sessions
+// created in backends for it:
+//
+//   - The gate holds the authoritative copy. Once the synchronized data in gate has
+//     been changed, a snapshot will be attached to the next message forwarded to each
+//     backend, and the backend replaces its copy with the snapshot.
+//   - The changes made in backend are sent to gate immediately, and applied to the
+//     gate session in arrival order.
+//   - If the gate and a backend change the same key concurrently, the value arriving
+//     at gate last wins, and the backend will converge to it by the next forwarded
+//     message.
+//
+// The values are encoded in JSON, the Go basic types are preserved and other types
+// will be decoded as the generic JSON values(map[string]interface{}, float64, etc.).
+
+// syncState records the synchronized session data exchanged with other members
+type syncState struct {
+	mu    sync.Mutex
+	sent  map[string][]byte                  // snapshots sent to each backend, used in gate
+	known map[string]*clusterpb.SessionValue // values exchanged with gate, used in backend
+	uid   int64                              // UID exchanged with gate, used in backend
+}
+
+var sessionValueDecoders = map[string]func([]byte) (interface{}, error){
+	"int":     decodeSessionValue[int],
+	"int8":    decodeSessionValue[int8],
+	"int16":   decodeSessionValue[int16],
+	"int32":   decodeSessionValue[int32],
+	"int64":   decodeSessionValue[int64],
+	"uint":    decodeSessionValue[uint],
+	"uint8":   decodeSessionValue[uint8],
+	"uint16":  decodeSessionValue[uint16],
+	"uint32":  decodeSessionValue[uint32],
+	"uint64":  decodeSessionValue[uint64],
+	"float32": decodeSessionValue[float32],
+	"float64": decodeSessionValue[float64],
+	"string":  decodeSessionValue[string],
+	"bool":    decodeSessionValue[bool],
+	"bytes":   decodeSessionValue[[]byte],
+	"":        decodeSessionValue[interface{}],
+}
+
+func decodeSessionValue[T any](data []byte) (interface{}, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func sessionValueType(v interface{}) string {
+	switch v.(type) {
+	case int:
+		return "int"
+	case int8:
+		return "int8"
+	case int16:
+		return "int16"
+	case int32:
+		return "int32"
+	case int64:
+		return "int64"
+	case uint:
+		return "uint"
+	case uint8:
+		return "uint8"
+	case uint16:
+		return "uint16"
+	case uint32:
+		return "uint32"
+	case uint64:
+		return "uint64"
+	case float32:
+		return "float32"
+	case float64:
+		return "float64"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case []byte:
+		return "bytes"
+	}
+	return ""
+}
+
+func encodeValue(v interface{}) (*clusterpb.SessionValue, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterpb.SessionValue{Type: sessionValueType(v), Data: data}, nil
+}
+
+func decodeValue(v *clusterpb.SessionValue) (interface{}, error) {
+	decoder, found := sessionValueDecoders[v.Type]
+	if !found {
+		return nil, fmt.Errorf("unknown session value type: %s", v.Type)
+	}
+	return decoder(v.Data)
+}
+
+func sameValue(a, b *clusterpb.SessionValue) bool {
+	return a != nil && b != nil && a.Type == b.Type && bytes.Equal(a.Data, b.Data)
+}
+
+// sessionData returns the snapshot of the synchronized data of the gate session which
+// should be attached to the message forwarded to addr, nil will be returned if the data
+// has not been changed since the last message forwarded to addr
+func (h *LocalHandler) sessionData(s *session.Session, addr string) *clusterpb.SessionData {
+	if s == nil || !h.currentNode.SyncSession {
+		return nil
+	}
+	a, ok := s.NetworkEntity().(*agent)
+	if !ok {
+		return nil
+	}
+
+	data := &clusterpb.SessionData{Uid: s.UID()}
+	for _, key := range h.currentNode.SyncSessionKeys {
+		if !s.HasKey(key) {
+			continue
+		}
+		v, err := encodeValue(s.Value(key))
+		if err != nil {
+			logger.Logger.Tracef("Encode session[%d] value of %s failed: %v", s.ID(), key, err)
+			continue
+		}
+		if data.Values == nil {
+			data.Values = map[string]*clusterpb.SessionValue{}
+		}
+		data.Values[key] = v
+	}
+	snapshot, err := proto.MarshalOptions{Deterministic: true}.Marshal(data)
+	if err != nil {
+		logger.Logger.Tracef("Encode session[%d] data failed: %v", s.ID(), err)
+		return nil
+	}
+
+	a.synced.mu.Lock()
+	defer a.synced.mu.Unlock()
+	if a.synced.sent == nil {
+		a.synced.sent = map[string][]byte{}
+	}
+	if sent, found := a.synced.sent[addr]; found && bytes.Equal(sent, snapshot) {
+		return nil
+	}
+	a.synced.sent[addr] = snapshot
+	return data
+}
+
+// forgetSessionData discards the snapshot sent to addr, so the snapshot will be sent
+// again along with the next message, it will be called if the forwarding failed or the
+// member of addr left cluster
+func forgetSessionData(s *session.Session, addr string) {
+	a, ok := s.NetworkEntity().(*agent)
+	if !ok {
+		return
+	}
+	a.synced.mu.Lock()
+	delete(a.synced.sent, addr)
+	a.synced.mu.Unlock()
+}
+
+// applySessionData replaces the synchronized data of the backend session with the
+// snapshot received from gate
+func (n *Node) applySessionData(s *session.Session, data *clusterpb.SessionData) {
+	if data == nil || !n.SyncSession {
+		return
+	}
+	ac, ok := s.NetworkEntity().(*acceptor)
+	if !ok {
+		return
+	}
+
+	values := map[string]interface{}{}
+	ac.synced.mu.Lock()
+	ac.synced.uid = data.Uid
+	ac.synced.known = map[string]*clusterpb.SessionValue{}
+	for key, v := range data.Values {
+		value, err := decodeValue(v)
+		if err != nil {
+			logger.Logger.Tracef("Decode session[%d] value of %s failed: %v", s.ID(), key, err)
+			continue
+		}
+		values[key] = value
+		ac.synced.known[key] = v
+	}
+	ac.synced.mu.Unlock()
+
+	// The changes are recorded as known, so the change hook will not send them back
+	if data.Uid > 0 && s.UID() != data.Uid {
+		s.Bind(data.Uid)
+	}
+	for _, key := range n.SyncSessionKeys {
+		if value, found := values[key]; found {
+			s.Set(key, value)
+		} else if s.HasKey(key) {
+			s.Remove(key)
+		}
+	}
+}
+
+// syncHook returns the session change hook of the backend session, which sends the
+// changes of the synchronized data to gate
+func (n *Node) syncHook(ac *acceptor) func(key string) {
+	keys := make(map[string]bool, len(n.SyncSessionKeys))
+	for _, key := range n.SyncSessionKeys {
+		keys[key] = true
+	}
+	return func(key string) {
+		s := ac.session
+		request := &clusterpb.SessionSyncMessage{SessionId: ac.sid}
+
+		// Hold the lock while sending, so the changes are sent in order
+		ac.synced.mu.Lock()
+		defer ac.synced.mu.Unlock()
+		switch {
+		case key == "":
+			uid := s.UID()
+			if uid == ac.synced.uid {
+				return
+			}
+			ac.synced.uid = uid
+			request.Data = &clusterpb.SessionData{Uid: uid}
+		case !keys[key]:
+			return
+		case !s.HasKey(key):
+			if _, found := ac.synced.known[key]; !found {
+				return
+			}
+			delete(ac.synced.known, key)
+			request.Removed = []string{key}
+		default:
+			v, err := encodeValue(s.Value(key))
+			if err != nil {
+				logger.Logger.Tracef("Encode session[%d] value of %s failed: %v", s.ID(), key, err)
+				return
+			}
+			if sameValue(ac.synced.known[key], v) {
+				return
+			}
+			if ac.synced.known == nil {
+				ac.synced.known = map[string]*clusterpb.SessionValue{}
+			}
+			ac.synced.known[key] = v
+			request.Data = &clusterpb.SessionData{Values: map[string]*clusterpb.SessionValue{key: v}}
+		}
+
+		m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_SessionSync{SessionSync: request}}
+		if err := ac.send(m); err != nil {
+			logger.Logger.Tracef("Sync session[%d] data to gate failed: %v", s.ID(), err)
+		}
+	}
+}
+
+// HandleSessionSync implements the MemberServer interface, it applies the changes of
+// synchronized data made in backend to the gate session
+func (n *Node) HandleSessionSync(_ context.Context, req *clusterpb.SessionSyncMessage) (*clusterpb.MemberHandleResponse, error) {
+	s := n.findSession(req.SessionId)
+	if s == nil {
+		return &clusterpb.MemberHandleResponse{}, fmt.Errorf("session not found: %v", req.SessionId)
+	}
+	if data := req.Data; data != nil {
+		if data.Uid > 0 {
+			s.Bind(data.Uid)
+		}
+		for key, v := range data.Values {
+			value, err := decodeValue(v)
+			if err != nil {
+				return &clusterpb.MemberHandleResponse{}, err
+			}
+			s.Set(key, value)
+		}
+	}
+	for _, key := range req.Removed {
+		s.Remove(key)
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
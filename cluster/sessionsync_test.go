@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+type syncedSession struct {
+	uid   int64
+	room  string
+	level int
+}
+
+type SyncComponent struct {
+	component.Base
+	received chan syncedSession
+}
+
+func (c *SyncComponent) Check(s *session.Session, ping *testdata.Ping) error {
+	c.received <- syncedSession{uid: s.UID(), room: s.String("room"), level: s.Int("level")}
+	switch ping.Content {
+	case "bind":
+		return s.Bind(200)
+	case "level":
+		s.Set("level", 3)
+	case "remove":
+		s.Remove("room")
+	}
+	return nil
+}
+
+func TestSessionSync(t *testing.T) {
+	go scheduler.Sched()
+
+	withSync := func(opts Options) Options {
+		opts.SyncSession = true
+		opts.SyncSessionKeys = []string{"room", "level"}
+		return opts
+	}
+	gate := &Node{
+		Options:     withSync(Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "sessionsync.gate",
+	}
+	if err := gate.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer gate.Shutdown()
+
+	comp := &SyncComponent{received: make(chan syncedSession, 1)}
+	comps := &component.Components{}
+	comps.Register(comp)
+	backend := &Node{
+		Options:     withSync(Options{AdvertiseAddr: gate.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial}),
+		ServiceAddr: "sessionsync.backend",
+	}
+	if err := backend.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Shutdown()
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	a := newAgent(conn, "", "", nil, gate.handler.remoteProcess, gate.handler.call)
+	s := a.session
+	gate.storeSession(s)
+
+	check := func(content string, expect syncedSession) {
+		gate.handler.remoteProcess(s, notifyMessage("SyncComponent.Check", content), true)
+		select {
+		case got := <-comp.received:
+			if got != expect {
+				t.Fatalf("expect backend session %+v, got: %+v", expect, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("notify handler not called")
+		}
+	}
+
+	// gate changes are synchronized along with the next message
+	s.Bind(100)
+	s.Set("room", "lobby")
+	s.Set("secret", "gate only")
+	check("level", syncedSession{uid: 100, room: "lobby"})
+
+	// backend changes are sent back to gate
+	waitFor(t, func() bool { return s.Int("level") == 3 })
+	s.Set("room", "hall")
+	check("bind", syncedSession{uid: 100, room: "hall", level: 3})
+	waitFor(t, func() bool { return s.UID() == 200 })
+	check("remove", syncedSession{uid: 200, room: "hall", level: 3})
+	waitFor(t, func() bool { return !s.HasKey("room") })
+	check("", syncedSession{uid: 200, level: 3})
+
+	if backend.findSession(s.ID()).HasKey("secret") {
+		t.Fatal("unexpected key synchronized")
+	}
+}
+
+func TestSessionValue(t *testing.T) {
+	values := []interface{}{
+		int8(-8), uint64(1 << 63), float32(1.5), "text", true, []byte("raw"),
+		map[string]interface{}{"score": float64(10)},
+	}
+	for _, value := range values {
+		v, err := encodeValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, ok := value.([]byte); ok {
+			if !bytes.Equal(decoded.([]byte), b) {
+				t.Fatalf("expect %v, got: %v", b, decoded)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Fatalf("expect %#v, got: %#v", value, decoded)
+		}
+	}
+}
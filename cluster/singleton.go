@@ -0,0 +1,255 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/component"
+)
+
+// singleton represents a component registered with component.WithSingleton, which
+// is active in the owner elected by master only
+type singleton struct {
+	comp    component.Component
+	active  atomic.Bool // the handlers are available
+	running atomic.Bool // initialized and not shut down yet, advertised to master
+}
+
+// singletonServices returns the singleton services registered in current node
+func (h *LocalHandler) singletonServices() []string {
+	var result []string
+	for service := range h.singletons {
+		result = append(result, service)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// assignOwners elects the owner of each singleton service among the members able to
+// run it. The current owner is kept while it is alive and not draining, otherwise the
+// member which has activated the service or the member with the lowest address will be
+// elected, draining members are elected only if no other member is available. It should
+// be called with mu held.
+//
+// The service is not handed over while another member still runs it, the owner stops
+// running it once it is not elected any more, or once its lease expires after missing
+// heartbeats for the dead timeout. The master reloaded without knowing the previous
+// owners waits for the lease of them as well.
+func (c *cluster) assignOwners() map[string]string {
+	candidates := map[string][]string{}
+	draining := map[string][]string{}
+	active := map[string][]string{}
+	for _, m := range c.members {
+		info := m.memberInfo
+		for _, service := range info.Singletons {
+			if info.Draining {
+				draining[service] = append(draining[service], info.ServiceAddr)
+			} else {
+				candidates[service] = append(candidates[service], info.ServiceAddr)
+			}
+			if slices.Contains(info.Services, service) {
+				active[service] = append(active[service], info.ServiceAddr)
+			}
+		}
+	}
+	for service, addrs := range draining {
+		if len(candidates[service]) == 0 {
+			candidates[service] = addrs
+		}
+	}
+
+	owners := make(map[string]string, len(candidates))
+	elected := make(map[string]string, len(candidates))
+	for service, addrs := range candidates {
+		var owner string
+		switch {
+		case slices.Contains(addrs, c.owners[service]):
+			owner = c.owners[service]
+		case len(active[service]) > 0 && slices.Contains(addrs, active[service][0]):
+			owner = active[service][0]
+		default:
+			owner = slices.Min(addrs)
+		}
+		elected[service] = owner
+		switch {
+		case slices.ContainsFunc(active[service], func(addr string) bool { return addr != owner }):
+			logger.Logger.Tracef("Hold singleton service[%s] until it is stopped by %v", service, active[service])
+			continue
+		case len(active[service]) == 0 && time.Now().Before(c.leaseUntil):
+			logger.Logger.Tracef("Hold singleton service[%s] until the lease of previous owner expires", service)
+			continue
+		}
+		owners[service] = owner
+		if owner != c.owners[service] {
+			logger.Logger.Tracef("Elect singleton service[%s] owner [%s]", service, owner)
+		}
+	}
+	c.owners = elected
+	return owners
+}
+
+// holdOwners postpones electing the owners which are not running the singletons, it
+// should be called with mu held once master finds itself reloaded, since the owners
+// elected by previous master may be still running until their lease expires
+func (c *cluster) holdOwners() {
+	c.leaseUntil = c.startedAt.Add(c.currentNode.deadTimeout())
+}
+
+// reassignOwners elects the owners after the members have been changed, and applies
+// the result to master itself, other members will receive it in heartbeat
+func (c *cluster) reassignOwners() {
+	c.mu.Lock()
+	owners := c.assignOwners()
+	c.mu.Unlock()
+	c.currentNode.applyOwners(owners)
+}
+
+// applyOwners activates the singleton services owned by current node and deactivates
+// the services which have been handed over to other members
+func (n *Node) applyOwners(owners map[string]string) {
+	n.singletonMu.Lock()
+	defer n.singletonMu.Unlock()
+	n.owners = owners
+	if n.singletonsStarted {
+		n.reconcileSingletons()
+	}
+}
+
+// renewLease extends the lease of the singletons owned by current node after master
+// responded the request sent at sentAt. Master elects other owners only if current node
+// missed heartbeats for the dead timeout, so the singletons are deactivated once the
+// lease expires before the next response.
+func (n *Node) renewLease(sentAt time.Time) {
+	if len(n.handler.singletons) == 0 {
+		return
+	}
+	n.singletonMu.Lock()
+	defer n.singletonMu.Unlock()
+	n.leaseExpiry = sentAt.Add(n.deadTimeout())
+	if n.lease == nil {
+		n.lease = time.AfterFunc(time.Until(n.leaseExpiry), n.expireLease)
+	} else {
+		n.lease.Reset(time.Until(n.leaseExpiry))
+	}
+}
+
+// expireLease deactivates the singletons owned by current node, they will be activated
+// again if current node is still the owner once master responds
+func (n *Node) expireLease() {
+	n.singletonMu.Lock()
+	defer n.singletonMu.Unlock()
+	// The lease has been renewed after the timer fired
+	if time.Now().Before(n.leaseExpiry) || n.owners == nil {
+		return
+	}
+	logger.Logger.Tracef("Singleton lease expired without heartbeat response of master")
+	n.owners = nil
+	if n.singletonsStarted {
+		n.reconcileSingletons()
+	}
+}
+
+// stopLease stops the lease timer, it should be called with singletonMu held
+func (n *Node) stopLease() {
+	if n.lease != nil {
+		n.lease.Stop()
+	}
+}
+
+// startSingletons activates the singleton services owned by current node, it will be
+// called after all other components have been initialized
+func (n *Node) startSingletons() {
+	n.singletonMu.Lock()
+	n.singletonsStarted = true
+	n.singletonMu.Unlock()
+	n.runSingletonHooks()
+}
+
+// stopSingletons deactivates all singleton services before shutdown, the master will
+// elect other owners once current node has left cluster
+func (n *Node) stopSingletons() {
+	n.singletonMu.Lock()
+	n.singletonsStarted = false
+	n.owners = nil
+	n.stopLease()
+	n.singletonMu.Unlock()
+	n.runSingletonHooks()
+}
+
+// reconcileSingletons applies the ownership changed to the singletons in background, it
+// should be called with singletonMu held. The ownership is changed by the heartbeats and
+// registrations handled by master, which should not wait for the lifetime hooks.
+func (n *Node) reconcileSingletons() {
+	go n.runSingletonHooks()
+}
+
+// runSingletonHooks calls the lifetime hooks of the singletons whose ownership has been
+// changed. The hooks are called one by one without singletonMu held, and the ownership
+// changed meanwhile is applied by the next call. The deactivated services are advertised
+// to master after their shutdown hooks returned, so master hands them over after that.
+func (n *Node) runSingletonHooks() {
+	n.singletonHookMu.Lock()
+	defer n.singletonHookMu.Unlock()
+
+	var changed bool
+	for _, service := range n.handler.singletonServices() {
+		s := n.handler.singletons[service]
+		n.singletonMu.Lock()
+		own := n.owners[service] == n.ServiceAddr
+		started := n.singletonsStarted
+		n.singletonMu.Unlock()
+		switch {
+		case own && started && !s.running.Load():
+			logger.Logger.Tracef("Activate singleton service[%s]", service)
+			s.comp.Init()
+			s.comp.AfterInit()
+			s.active.Store(true)
+			s.running.Store(true)
+		case !own && s.running.Load():
+			logger.Logger.Tracef("Deactivate singleton service[%s]", service)
+			s.active.Store(false)
+			s.comp.BeforeShutdown()
+			s.comp.Shutdown()
+			s.running.Store(false)
+		default:
+			continue
+		}
+		changed = true
+	}
+
+	// Publish the services immediately, so the messages of activated services can
+	// be routed to current node without waiting for the next heartbeat
+	n.singletonMu.Lock()
+	started := n.singletonsStarted
+	n.singletonMu.Unlock()
+	if changed && started {
+		go func() {
+			if err := n.publishMemberInfo(); err != nil {
+				logger.Logger.Tracef("Publish singleton services failed: %v", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,196 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/nanotest"
+	"github.com/acoderup/nano/session"
+)
+
+type SettlerComponent struct {
+	addr   string
+	events chan string
+}
+
+func (c *SettlerComponent) Init()           { c.events <- "init " + c.addr }
+func (c *SettlerComponent) AfterInit()      { c.events <- "after init " + c.addr }
+func (c *SettlerComponent) BeforeShutdown() { c.events <- "before shutdown " + c.addr }
+func (c *SettlerComponent) Shutdown()       { c.events <- "shutdown " + c.addr }
+
+func (c *SettlerComponent) Owner(_ *session.Session, _ *testdata.Ping) (*testdata.Pong, error) {
+	return &testdata.Pong{Content: c.addr}, nil
+}
+
+// settlers starts the nodes running SettlerComponent as a singleton in the cluster
+type settlers struct {
+	t      *testing.T
+	c      *nanotest.Cluster
+	events chan string
+}
+
+func newSettlers(t *testing.T) *settlers {
+	return &settlers{t: t, c: nanotest.New(t), events: make(chan string, 16)}
+}
+
+func (s *settlers) start(name string) *cluster.Node {
+	s.t.Helper()
+	comps := &component.Components{}
+	comps.Register(&SettlerComponent{addr: name, events: s.events}, component.WithSingleton())
+	return s.c.AddBackend(name, comps)
+}
+
+func (s *settlers) expect(want ...string) {
+	s.t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-s.events:
+			if got != w {
+				s.t.Fatalf("expect %q, got: %q", w, got)
+			}
+		case <-time.After(nanotest.DefaultWaitTimeout):
+			s.t.Fatalf("event %q not received", w)
+		}
+	}
+}
+
+func (s *settlers) expectNone() {
+	s.t.Helper()
+	select {
+	case e := <-s.events:
+		s.t.Fatalf("unexpected event: %s", e)
+	default:
+	}
+}
+
+func owner(n *cluster.Node) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pong := &testdata.Pong{}
+	if err := n.Call(ctx, "SettlerComponent.Owner", &testdata.Ping{}, pong); err != nil {
+		return ""
+	}
+	return pong.Content
+}
+
+func TestSingleton(t *testing.T) {
+	s := newSettlers(t)
+	c, master := s.c, s.c.Master()
+
+	// the first node runs the singleton
+	first := s.start("first")
+	s.expect("init first", "after init first")
+	c.WaitFor(func() bool { return owner(master) == first.ServiceAddr })
+
+	// the second node forwards the messages to owner
+	second := s.start("second")
+	c.WaitFor(func() bool { return owner(second) == first.ServiceAddr })
+	if services := second.Handler().LocalService(); len(services) != 0 {
+		t.Fatalf("unexpected services of standby node: %v", services)
+	}
+
+	// failover after the owner crashed
+	c.Kill(first)
+	s.expect("init second", "after init second")
+	c.WaitFor(func() bool { return owner(master) == second.ServiceAddr })
+
+	// handover on shutdown
+	third := s.start("third")
+	c.WaitFor(func() bool { return owner(third) == second.ServiceAddr })
+	c.Shutdown(second)
+	s.expect("before shutdown second", "shutdown second", "init third", "after init third")
+	c.WaitFor(func() bool { return owner(master) == third.ServiceAddr })
+	s.expectNone()
+}
+
+func TestSingletonLease(t *testing.T) {
+	s := newSettlers(t)
+	c, master := s.c, s.c.Master()
+
+	// the draining owner stops the singleton before the next owner starts it
+	first := s.start("first")
+	s.expect("init first", "after init first")
+	second := s.start("second")
+	if err := first.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	s.expect("before shutdown first", "shutdown first", "init second", "after init second")
+
+	// shutdown after the node has been killed
+	c.Kill(first)
+	first.Shutdown()
+	s.expectNone()
+
+	// the owner partitioned from master stops the singleton after its lease expired,
+	// and runs it again after rejoined
+	c.Partition(master, second)
+	s.expect("before shutdown second", "shutdown second")
+	c.Heal(master, second)
+	s.expect("init second", "after init second")
+	c.WaitFor(func() bool { return owner(master) == second.ServiceAddr })
+	s.expectNone()
+}
+
+// SlowSettlerComponent blocks in Init until released
+type SlowSettlerComponent struct {
+	SettlerComponent
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *SlowSettlerComponent) Init() {
+	close(c.started)
+	<-c.release
+	c.SettlerComponent.Init()
+}
+
+func TestSingletonSlowHooks(t *testing.T) {
+	s := newSettlers(t)
+	c, master := s.c, s.c.Master()
+	first := s.start("first")
+	s.expect("init first", "after init first")
+
+	comp := &SlowSettlerComponent{
+		SettlerComponent: SettlerComponent{addr: "slow", events: s.events},
+		started:          make(chan struct{}),
+		release:          make(chan struct{}),
+	}
+	comps := &component.Components{}
+	comps.Register(comp, component.WithSingleton(), component.WithName("SettlerComponent"))
+	slow := c.AddBackend("slow", comps)
+	c.WaitFor(func() bool { return owner(slow) == first.ServiceAddr })
+
+	// the heartbeats are not blocked by the hook of elected standby
+	c.Kill(first)
+	select {
+	case <-comp.started:
+	case <-time.After(nanotest.DefaultWaitTimeout):
+		t.Fatal("standby not elected")
+	}
+	updated := make(chan error, 1)
+	go func() { updated <- slow.SetMetadata(cluster.MetadataZone, "b") }()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(nanotest.DefaultWaitTimeout):
+		t.Fatal("metadata update blocked by the hook")
+	}
+	c.WaitFor(func() bool {
+		for _, m := range master.Members() {
+			if m.ServiceAddr == slow.ServiceAddr {
+				return m.Metadata[cluster.MetadataZone] == "b"
+			}
+		}
+		return false
+	})
+
+	close(comp.release)
+	s.expect("init slow", "after init slow")
+	c.WaitFor(func() bool { return owner(master) == slow.ServiceAddr })
+}
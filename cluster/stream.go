@@ -0,0 +1,457 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	streamQueueSize     = 1024    // messages buffered in each member stream
+	streamMaxBatchSize  = 128     // max messages sent in one batch
+	streamMaxBatchBytes = 1 << 20 // max bytes sent in one batch
+
+	streamPeerKey   = "nano-peer"   // metadata key of the service address of stream opener
+	streamHeaderKey = "nano-stream" // header key sent by the member which supports stream
+)
+
+var (
+	errStreamUnsupported = errors.New("member stream is not supported by peer")
+	errStreamClosed      = errors.New("member stream has been closed")
+)
+
+// batchStream is the common interface of the client and server side member streams
+type batchStream interface {
+	Send(*clusterpb.StreamBatch) error
+	Recv() (*clusterpb.StreamBatch, error)
+}
+
+// memberStream multiplexes the messages between current node and a peer member in
+// a bidirectional stream, the messages are written by a single goroutine in sending
+// order, so the messages of a session will be handled by the peer in the same order.
+type memberStream struct {
+	addr   string
+	stream batchStream
+	cancel context.CancelFunc // cancels the stream opened by current node
+	pool   *connPool          // the connection pool carries the stream opened by current node
+
+	queue chan *clusterpb.StreamMessage
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+func newMemberStream(addr string, stream batchStream, cancel context.CancelFunc, pool *connPool) *memberStream {
+	return &memberStream{
+		addr:   addr,
+		stream: stream,
+		cancel: cancel,
+		pool:   pool,
+		queue:  make(chan *clusterpb.StreamMessage, streamQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// start starts the read and write goroutines, the messages received will be handled
+// by dispatch in the read goroutine sequentially
+func (s *memberStream) start(dispatch func(*clusterpb.StreamMessage)) {
+	s.wg.Add(1)
+	go s.write()
+	go s.read(dispatch)
+}
+
+// send enqueues the message, errStreamClosed will be returned if the stream has been
+// closed, and the error of ctx if the queue is still full once ctx is done
+func (s *memberStream) send(ctx context.Context, m *clusterpb.StreamMessage) error {
+	select {
+	case <-s.done:
+		return errStreamClosed
+	default:
+	}
+	s.touch()
+
+	select {
+	case s.queue <- m:
+		return nil
+	case <-s.done:
+		return errStreamClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *memberStream) read(dispatch func(*clusterpb.StreamMessage)) {
+	for {
+		batch, err := s.stream.Recv()
+		if err != nil {
+			logger.Logger.Tracef("Member stream [%s] read error: %v", s.addr, err)
+			s.close()
+			return
+		}
+		s.touch()
+		for _, m := range batch.Messages {
+			dispatch(m)
+		}
+	}
+}
+
+// write sends the queued messages in batches, all messages queued at the moment
+// will be sent in one batch, so the small messages are batched under pressure
+// without delaying the single message.
+func (s *memberStream) write() {
+	defer s.wg.Done()
+	for {
+		var m *clusterpb.StreamMessage
+		select {
+		case m = <-s.queue:
+		case <-s.done:
+			if n := len(s.queue); n > 0 {
+				logger.Logger.Tracef("Member stream [%s] closed, %d messages dropped", s.addr, n)
+			}
+			return
+		}
+
+		batch := &clusterpb.StreamBatch{Messages: []*clusterpb.StreamMessage{m}}
+		size := proto.Size(m)
+	collect:
+		for len(batch.Messages) < streamMaxBatchSize && size < streamMaxBatchBytes {
+			select {
+			case m := <-s.queue:
+				batch.Messages = append(batch.Messages, m)
+				size += proto.Size(m)
+			default:
+				break collect
+			}
+		}
+
+		if err := s.stream.Send(batch); err != nil {
+			logger.Logger.Tracef("Member stream [%s] write error: %v, %d messages dropped", s.addr, err, len(batch.Messages))
+			s.close()
+			return
+		}
+	}
+}
+
+// touch keeps the connection pool carrying the stream from being reaped while the
+// stream is in use, since the stream never gets connections from the pool again
+func (s *memberStream) touch() {
+	if s.pool != nil {
+		s.pool.touch()
+	}
+}
+
+func (s *memberStream) close() {
+	s.once.Do(func() {
+		close(s.done)
+		if s.cancel != nil {
+			s.cancel()
+		}
+	})
+}
+
+// transport sends the messages to peer members through member streams, and falls
+// back to unary calls if the peer does not support member stream
+type transport struct {
+	node *Node
+
+	mu      sync.Mutex
+	closed  bool
+	streams map[string]*memberStream
+	opening map[string]chan struct{}
+	unary   map[string]bool // the peers which do not support member stream
+}
+
+func newTransport(node *Node) *transport {
+	return &transport{
+		node:    node,
+		streams: map[string]*memberStream{},
+		opening: map[string]chan struct{}{},
+		unary:   map[string]bool{},
+	}
+}
+
+// send sends the message to the member of addr, the message will be sent through the
+// member stream asynchronously, and sent by unary call if stream is not available.
+// The message is rejected if the circuit breaker of the member is open.
+func (t *transport) send(ctx context.Context, addr string, m *clusterpb.StreamMessage) error {
+	return t.node.guard(addr, func() error {
+		return t.deliver(ctx, addr, m)
+	})
+}
+
+// sendRequest sends the request of gate session to member addr like send, but the
+// request queued in the member stream has not been handled yet, so the circuit breaker
+// of the member records the result once the response is received or the request
+// timeout, the requests not tracked by RequestTimeout are recorded once sent
+func (t *transport) sendRequest(ctx context.Context, a *agent, mid uint64, addr string, m *clusterpb.StreamMessage) error {
+	call, err := t.node.admit(addr)
+	if err != nil {
+		return err
+	}
+	if call == nil || !a.requests.observe(mid, call) {
+		err = t.deliver(ctx, addr, m)
+		call.done(err)
+		return err
+	}
+	// The failure is recorded by failRequest of the caller
+	return t.deliver(ctx, addr, m)
+}
+
+// deliver sends the message through the member stream, or the unary call if the stream
+// is not available
+func (t *transport) deliver(ctx context.Context, addr string, m *clusterpb.StreamMessage) error {
+	if !t.node.UnaryTransport {
+		// The closed stream falls back to the unary call
+		if s := t.stream(addr); s != nil {
+			if err := s.send(ctx, m); !errors.Is(err, errStreamClosed) {
+				return err
+			}
+		}
+	}
+
+	pool, err := t.node.rpcClient.getConnPool(addr)
+	if err != nil {
+		return err
+	}
+	return unaryCall(ctx, clusterpb.NewMemberClient(pool.Get()), m)
+}
+
+// stream returns the member stream of addr and opens a new one if not exists,
+// it returns nil if the stream cannot be opened
+func (t *transport) stream(addr string) *memberStream {
+	for {
+		t.mu.Lock()
+		if t.closed || t.unary[addr] {
+			t.mu.Unlock()
+			return nil
+		}
+		if s, found := t.streams[addr]; found {
+			t.mu.Unlock()
+			return s
+		}
+		if ch, found := t.opening[addr]; found {
+			t.mu.Unlock()
+			<-ch
+			continue
+		}
+		ch := make(chan struct{})
+		t.opening[addr] = ch
+		t.mu.Unlock()
+
+		s, err := t.open(addr)
+
+		t.mu.Lock()
+		delete(t.opening, addr)
+		switch {
+		case err == errStreamUnsupported:
+			logger.Logger.Tracef("Member [%s] does not support stream, fall back to unary calls", addr)
+			t.unary[addr] = true
+		case err != nil:
+			logger.Logger.Tracef("Open member stream [%s] error: %v", addr, err)
+		case t.closed:
+			s.close()
+			s = nil
+		default:
+			t.streams[addr] = s
+		}
+		t.mu.Unlock()
+		close(ch)
+
+		if s != nil {
+			t.serve(s)
+		}
+		return s
+	}
+}
+
+func (t *transport) open(addr string) (*memberStream, error) {
+	pool, err := t.node.rpcClient.getConnPool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = metadata.AppendToOutgoingContext(ctx, streamPeerKey, t.node.ServiceAddr)
+	stream, err := clusterpb.NewMemberClient(pool.Get()).Stream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The member which supports stream sends the header immediately, otherwise the
+	// stream will be terminated without header and the status can be received
+	header, err := stream.Header()
+	if err == nil && len(header.Get(streamHeaderKey)) == 0 {
+		if _, err = stream.Recv(); err == nil {
+			err = errStreamUnsupported
+		}
+	}
+	if err != nil {
+		cancel()
+		if status.Code(err) == codes.Unimplemented {
+			return nil, errStreamUnsupported
+		}
+		return nil, err
+	}
+	return newMemberStream(addr, stream, cancel, pool), nil
+}
+
+// accept serves the member stream opened by peer until it is closed, the stream
+// will be used to send messages to the peer if there is no stream of the peer
+func (t *transport) accept(s *memberStream) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	if _, found := t.streams[s.addr]; !found {
+		t.streams[s.addr] = s
+	}
+	t.mu.Unlock()
+
+	t.serve(s)
+	<-s.done
+	s.wg.Wait()
+}
+
+// serve starts the member stream, and removes it from transport after closed
+func (t *transport) serve(s *memberStream) {
+	s.start(t.node.handleStreamMessage)
+	go func() {
+		<-s.done
+		t.mu.Lock()
+		if t.streams[s.addr] == s {
+			delete(t.streams, s.addr)
+		}
+		t.mu.Unlock()
+	}()
+}
+
+// closeStream closes the member stream of addr, it will be called when the member
+// left cluster
+func (t *transport) closeStream(addr string) {
+	t.mu.Lock()
+	s, found := t.streams[addr]
+	delete(t.streams, addr)
+	delete(t.unary, addr)
+	t.mu.Unlock()
+	if found {
+		s.close()
+	}
+}
+
+func (t *transport) close() {
+	t.mu.Lock()
+	t.closed = true
+	streams := t.streams
+	t.streams = map[string]*memberStream{}
+	t.mu.Unlock()
+	for _, s := range streams {
+		s.close()
+	}
+}
+
+// Stream implements the MemberServer interface
+func (n *Node) Stream(stream clusterpb.Member_StreamServer) error {
+	var addr string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if v := md.Get(streamPeerKey); len(v) > 0 {
+			addr = v[0]
+		}
+	}
+	if addr == "" {
+		return status.Error(codes.InvalidArgument, "missing service address of peer")
+	}
+	if err := stream.SendHeader(metadata.Pairs(streamHeaderKey, "1")); err != nil {
+		return err
+	}
+	n.transport.accept(newMemberStream(addr, stream, nil, nil))
+	return nil
+}
+
+// handleStreamMessage handles the message received from member stream in the same
+// way as the respective unary call
+func (n *Node) handleStreamMessage(m *clusterpb.StreamMessage) {
+	ctx := context.Background()
+	var err error
+	switch p := m.Payload.(type) {
+	case *clusterpb.StreamMessage_Request:
+		_, err = n.HandleRequest(ctx, p.Request)
+	case *clusterpb.StreamMessage_Notify:
+		_, err = n.HandleNotify(ctx, p.Notify)
+	case *clusterpb.StreamMessage_Push:
+		_, err = n.HandlePush(ctx, p.Push)
+	case *clusterpb.StreamMessage_Response:
+		_, err = n.HandleResponse(ctx, p.Response)
+	case *clusterpb.StreamMessage_CloseSession:
+		_, err = n.CloseSession(ctx, p.CloseSession)
+	case *clusterpb.StreamMessage_SessionSync:
+		_, err = n.HandleSessionSync(ctx, p.SessionSync)
+	case *clusterpb.StreamMessage_GroupPush:
+		_, err = n.HandleGroupPush(ctx, p.GroupPush)
+	case *clusterpb.StreamMessage_System:
+		_, err = n.HandleSystem(ctx, p.System)
+	case *clusterpb.StreamMessage_Publish:
+		_, err = n.HandlePublish(ctx, p.Publish)
+	case *clusterpb.StreamMessage_SessionClosed:
+		_, err = n.SessionClosed(ctx, p.SessionClosed)
+	}
+	if err != nil {
+		logger.Logger.Tracef("Handle stream message error: %v", err)
+	}
+}
+
+// unaryCall sends the message by the respective unary call
+func unaryCall(ctx context.Context, client clusterpb.MemberClient, m *clusterpb.StreamMessage) error {
+	var err error
+	switch p := m.Payload.(type) {
+	case *clusterpb.StreamMessage_Request:
+		_, err = client.HandleRequest(ctx, p.Request)
+	case *clusterpb.StreamMessage_Notify:
+		_, err = client.HandleNotify(ctx, p.Notify)
+	case *clusterpb.StreamMessage_Push:
+		_, err = client.HandlePush(ctx, p.Push)
+	case *clusterpb.StreamMessage_Response:
+		_, err = client.HandleResponse(ctx, p.Response)
+	case *clusterpb.StreamMessage_CloseSession:
+		_, err = client.CloseSession(ctx, p.CloseSession)
+	case *clusterpb.StreamMessage_SessionSync:
+		_, err = client.HandleSessionSync(ctx, p.SessionSync)
+	case *clusterpb.StreamMessage_GroupPush:
+		_, err = client.HandleGroupPush(ctx, p.GroupPush)
+	case *clusterpb.StreamMessage_System:
+		_, err = client.HandleSystem(ctx, p.System)
+	case *clusterpb.StreamMessage_Publish:
+		_, err = client.HandlePublish(ctx, p.Publish)
+	case *clusterpb.StreamMessage_SessionClosed:
+		_, err = client.SessionClosed(ctx, p.SessionClosed)
+	}
+	return err
+}
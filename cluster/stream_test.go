@@ -0,0 +1,203 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/mock"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+	"google.golang.org/grpc"
+)
+
+type OrderComponent struct {
+	component.Base
+	count  int64
+	target int64
+	done   chan struct{}
+}
+
+func (c *OrderComponent) Echo(s *session.Session, ping *testdata.Ping) error {
+	return s.Push("onEcho", &testdata.Pong{Content: ping.Content})
+}
+
+func (c *OrderComponent) Count(_ *session.Session, _ *testdata.Ping) error {
+	if atomic.AddInt64(&c.count, 1) == atomic.LoadInt64(&c.target) {
+		c.done <- struct{}{}
+	}
+	return nil
+}
+
+// pushRecorder records the data pushed to session in order
+type pushRecorder struct {
+	*mock.NetworkEntity
+	pushes chan string
+}
+
+func (r *pushRecorder) Push(_ string, v interface{}) error {
+	pong := &testdata.Pong{}
+	if err := env.Serializer.Unmarshal(v.([]byte), pong); err != nil {
+		return err
+	}
+	r.pushes <- pong.Content
+	return nil
+}
+
+func notifyMessage(route string, content string) *message.Message {
+	data, _ := message.Serialize(&testdata.Ping{Content: content})
+	return &message.Message{Type: message.Notify, Route: route, Data: data}
+}
+
+func startGateAndBackend(tb testing.TB, name string, unary bool, comp component.Component) (*Node, *Node) {
+	gate := &Node{
+		Options:     Options{IsMaster: true, UnaryTransport: unary, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: name + ".gate",
+	}
+	if err := gate.Startup(); err != nil {
+		tb.Fatal(err)
+	}
+
+	comps := &component.Components{}
+	comps.Register(comp)
+	backend := &Node{
+		Options:     Options{AdvertiseAddr: gate.ServiceAddr, UnaryTransport: unary, Components: comps, Listener: memListen, Dialer: memDial},
+		ServiceAddr: name + ".backend",
+	}
+	if err := backend.Startup(); err != nil {
+		gate.Shutdown()
+		tb.Fatal(err)
+	}
+	return gate, backend
+}
+
+func TestStreamTransport(t *testing.T) {
+	go scheduler.Sched()
+
+	gate, backend := startGateAndBackend(t, "stream", false, &OrderComponent{})
+	defer gate.Shutdown()
+	defer backend.Shutdown()
+
+	recorder := &pushRecorder{NetworkEntity: mock.NewNetworkEntity(), pushes: make(chan string, 1000)}
+	s := session.New(recorder)
+	gate.storeSession(s)
+
+	expect := func(count int) {
+		for i := 0; i < count; i++ {
+			select {
+			case content := <-recorder.pushes:
+				if content != strconv.Itoa(i) {
+					t.Fatalf("expect push %d, got: %s", i, content)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("push %d not received", i)
+			}
+		}
+	}
+
+	// the notifies and the pushes are delivered in order through member stream
+	const count = 1000
+	for i := 0; i < count; i++ {
+		gate.handler.remoteProcess(s, notifyMessage("OrderComponent.Echo", strconv.Itoa(i)), true)
+	}
+	expect(count)
+	gate.transport.mu.Lock()
+	_, found := gate.transport.streams[backend.ServiceAddr]
+	gate.transport.mu.Unlock()
+	if !found {
+		t.Fatal("expect member stream opened")
+	}
+
+	// fall back to unary calls for the member does not support stream
+	legacy := &Node{
+		Options:     Options{UnaryTransport: true},
+		ServiceAddr: "stream.legacy",
+		sessions:    map[int64]*session.Session{},
+		rpcClient:   newRPCClient(Options{}, memDialOptions),
+	}
+	legacy.transport = newTransport(legacy)
+	legacy.handler = NewHandler(legacy, nil)
+	if err := legacy.handler.register(&OrderComponent{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := memListen(legacy.ServiceAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	desc := clusterpb.Member_ServiceDesc
+	desc.Streams = nil
+	server.RegisterService(&desc, legacy)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	for i := 0; i < 10; i++ {
+		request := &clusterpb.NotifyMessage{
+			GateAddr:  gate.ServiceAddr,
+			SessionId: s.ID(),
+			Route:     "OrderComponent.Echo",
+			Data:      notifyMessage("", strconv.Itoa(i)).Data,
+		}
+		m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_Notify{Notify: request}}
+		if err := gate.transport.send(context.Background(), legacy.ServiceAddr, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(10)
+	gate.transport.mu.Lock()
+	unary := gate.transport.unary[legacy.ServiceAddr]
+	gate.transport.mu.Unlock()
+	if !unary {
+		t.Fatal("expect fall back to unary calls")
+	}
+}
+
+func TestStreamSendContext(t *testing.T) {
+	// the stream is not started, so the queue is never drained
+	s := newMemberStream("peer", &discardStream{closed: make(chan struct{})}, nil, nil)
+	for i := 0; i < streamQueueSize; i++ {
+		if err := s.send(context.Background(), &clusterpb.StreamMessage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.send(ctx, &clusterpb.StreamMessage{}); err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, got: %v", err)
+	}
+	s.close()
+	if err := s.send(context.Background(), &clusterpb.StreamMessage{}); err != errStreamClosed {
+		t.Fatalf("expect stream closed, got: %v", err)
+	}
+}
+
+func BenchmarkForwardNotify(b *testing.B) {
+	go scheduler.Sched()
+
+	for _, transport := range []string{"unary", "stream"} {
+		b.Run(transport, func(b *testing.B) {
+			comp := &OrderComponent{done: make(chan struct{}, 1)}
+			gate, backend := startGateAndBackend(b, "forward."+transport, transport == "unary", comp)
+			defer gate.Shutdown()
+			defer backend.Shutdown()
+
+			atomic.StoreInt64(&comp.target, int64(b.N))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				s := session.New(mock.NewNetworkEntity())
+				for pb.Next() {
+					gate.handler.remoteProcess(s, notifyMessage("OrderComponent.Count", "benchmark"), true)
+				}
+			})
+			<-comp.done
+		})
+	}
+}
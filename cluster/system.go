@@ -0,0 +1,224 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/session"
+)
+
+// systemRouter maps the hash keys of system messages to members, the bounded load
+// is disabled so all nodes map a key to the same member
+var systemRouter = &hashRouter{rings: map[string]*hashRing{}}
+
+// Target specifies the members which a system message will be sent to, the zero
+// Target selects a member provides the service of route randomly
+type Target struct {
+	addr string
+	key  string
+	all  bool
+}
+
+// ToMember targets the member of the service address
+func ToMember(addr string) Target {
+	return Target{addr: addr}
+}
+
+// ToService targets all members provide the service of route, including the
+// draining members
+func ToService() Target {
+	return Target{all: true}
+}
+
+// ToKey targets the member which owns the key on the consistent hash ring of the
+// service, every node maps the same key to the same member as long as they have
+// the same view of membership
+func ToKey(key string) Target {
+	return Target{key: key}
+}
+
+// Notify sends a system message to the members of target by the node started most
+// recently in current process. See Node.Notify for more details.
+func Notify(ctx context.Context, target Target, route string, v interface{}) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.Notify(ctx, target, route, v)
+}
+
+// Request sends a system message to the member of target and waits for the reply by
+// the node started most recently in current process. See Node.Request for more details.
+func Request(ctx context.Context, target Target, route string, v interface{}, reply interface{}) error {
+	n := defaultNode.Load()
+	if n == nil {
+		return ErrNodeNotStarted
+	}
+	return n.Request(ctx, target, route, v, reply)
+}
+
+// Notify sends a system message to the members of target without waiting for the
+// handling. The handler of route will receive a nil session and the metadata carried
+// by ctx, the deadline of ctx will be propagated and the expired messages are dropped.
+// All targets will be tried and the first error will be returned.
+func (n *Node) Notify(ctx context.Context, target Target, route string, v interface{}) error {
+	addrs, err := n.systemTargets(route, target)
+	if err != nil {
+		return err
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	request := &clusterpb.SystemMessage{Route: route, Data: data, Metadata: contextMetadata(ctx)}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Deadline = deadline.UnixNano()
+	}
+	var first error
+	for _, addr := range addrs {
+		if addr == n.ServiceAddr {
+			_, err = n.HandleSystem(ctx, request)
+		} else {
+			m := &clusterpb.StreamMessage{Payload: &clusterpb.StreamMessage_System{System: request}}
+			err = n.transport.send(ctx, addr, m)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("notify %s to %s: %w", route, addr, err)
+		}
+	}
+	return first
+}
+
+// Request sends a system message to the member of target and waits for the reply,
+// ToService is not allowed since only one member can reply. The handler of route
+// receives a nil session and the reply in the same way as Call, the handler registered
+// in current node will be dispatched to its scheduler in the same way as Call.
+func (n *Node) Request(ctx context.Context, target Target, route string, v interface{}, reply interface{}) error {
+	if target.all {
+		return ErrMultipleTargets
+	}
+	addrs, err := n.systemTargets(route, target)
+	if err != nil {
+		return err
+	}
+	data, err := message.Serialize(v)
+	if err != nil {
+		return err
+	}
+
+	if handler, found := n.handler.localHandler(route); found && addrs[0] == n.ServiceAddr {
+		data, handleErr, err := n.handler.dispatch(ctx, route, handler, nil, data)
+		if err != nil {
+			return err
+		}
+		if handleErr != nil {
+			return handleErr
+		}
+		return decodeReply(data, reply)
+	}
+	request := &clusterpb.CallRequest{Route: route, Data: data, Metadata: contextMetadata(ctx)}
+	return n.handler.callMember(ctx, addrs[0], request, reply)
+}
+
+// systemTargets returns the service addresses of target, current node is one of
+// the candidates if the handler of route has been registered locally
+func (n *Node) systemTargets(route string, target Target) ([]string, error) {
+	index := strings.LastIndex(route, ".")
+	if index < 0 {
+		return nil, fmt.Errorf("nano/handler: invalid route %s", route)
+	}
+	service := route[:index]
+	members := n.handler.findMembers(service)
+	if _, found := n.handler.localHandler(route); found {
+		self := &clusterpb.MemberInfo{ServiceAddr: n.ServiceAddr, Draining: n.draining.Load()}
+		members = append(slices.Clone(members), self)
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("nano/handler: %s not found(forgot registered?)", route)
+	}
+
+	var addrs []string
+	switch {
+	case target.addr != "":
+		for _, m := range members {
+			if m.ServiceAddr == target.addr {
+				addrs = append(addrs, m.ServiceAddr)
+				break
+			}
+		}
+	case target.all:
+		for _, m := range members {
+			addrs = append(addrs, m.ServiceAddr)
+		}
+	case target.key != "":
+		if m := systemRouter.owner(service, target.key, routableMembers(members)); m != nil {
+			addrs = append(addrs, m.ServiceAddr)
+		}
+	default:
+		if m := defaultRoute(service, nil, n.availableMembers(routableMembers(members))); m != nil {
+			addrs = append(addrs, m.ServiceAddr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, ErrNoMember
+	}
+	return addrs, nil
+}
+
+// HandleSystem implements the MemberServer interface, it schedules the handler of the
+// system message with a nil session
+func (n *Node) HandleSystem(_ context.Context, req *clusterpb.SystemMessage) (*clusterpb.MemberHandleResponse, error) {
+	handler, found := n.handler.localHandler(req.Route)
+	if !found {
+		return nil, fmt.Errorf("service not found in current node: %v", req.Route)
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if req.Deadline > 0 {
+		deadline := time.Unix(0, req.Deadline)
+		if time.Now().After(deadline) {
+			logger.Logger.Tracef("System message expired, Route=%s", req.Route)
+			return &clusterpb.MemberHandleResponse{}, nil
+		}
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	ctx = session.WithMetadata(ctx, req.Metadata)
+	task := func(ctx context.Context) {
+		defer cancel()
+		if _, err := n.handler.invoke(ctx, handler, nil, req.Data); err != nil {
+			logger.Logger.Tracef("Handle system message error, Route=%s, Error=%v", req.Route, err)
+		}
+	}
+	if err := n.handler.scheduleHandler(ctx, req.Route, nil, task); err != nil {
+		cancel()
+		return nil, err
+	}
+	return &clusterpb.MemberHandleResponse{}, nil
+}
@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+type SystemComponent struct {
+	component.Base
+	addr     string
+	received chan string
+}
+
+func (c *SystemComponent) Notice(s *session.Session, ping *testdata.Ping) error {
+	if s != nil {
+		return errors.New("unexpected session")
+	}
+	c.received <- c.addr + " " + ping.Content
+	return nil
+}
+
+func (c *SystemComponent) Whoami(s *session.Session, _ *testdata.Ping) (*testdata.Pong, error) {
+	if s != nil {
+		return nil, errors.New("unexpected session")
+	}
+	return &testdata.Pong{Content: c.addr}, nil
+}
+
+func TestSystemMessage(t *testing.T) {
+	go scheduler.Sched()
+
+	master := &Node{
+		Options:     Options{IsMaster: true, Components: &component.Components{}, Listener: memListen, Dialer: memDial},
+		ServiceAddr: "system.master",
+	}
+	if err := master.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer master.Shutdown()
+
+	received := make(chan string, 16)
+	var backends []*Node
+	for _, addr := range []string{"system.a", "system.b"} {
+		comps := &component.Components{}
+		comps.Register(&SystemComponent{addr: addr, received: received})
+		backend := &Node{
+			Options:     Options{AdvertiseAddr: master.ServiceAddr, Components: comps, Listener: memListen, Dialer: memDial},
+			ServiceAddr: addr,
+		}
+		if err := backend.Startup(); err != nil {
+			t.Fatal(err)
+		}
+		defer backend.Shutdown()
+		backends = append(backends, backend)
+	}
+	waitFor(t, func() bool { return len(master.handler.findMembers("SystemComponent")) == 2 })
+	waitFor(t, func() bool { return len(backends[0].handler.findMembers("SystemComponent")) == 1 })
+
+	expect := func(want ...string) {
+		t.Helper()
+		got := map[string]bool{}
+		for range want {
+			select {
+			case r := <-received:
+				got[r] = true
+			case <-time.After(3 * time.Second):
+				t.Fatalf("system message not received, got: %v", got)
+			}
+		}
+		for _, w := range want {
+			if !got[w] {
+				t.Fatalf("expect %q received, got: %v", w, got)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// all members of service
+	if err := master.Notify(ctx, ToService(), "SystemComponent.Notice", &testdata.Ping{Content: "all"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("system.a all", "system.b all")
+
+	// specified member, current node included
+	if err := backends[0].Notify(ctx, ToMember("system.a"), "SystemComponent.Notice", &testdata.Ping{Content: "self"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("system.a self")
+	pong := &testdata.Pong{}
+	if err := backends[0].Request(ctx, ToMember("system.b"), "SystemComponent.Whoami", &testdata.Ping{}, pong); err != nil {
+		t.Fatal(err)
+	}
+	if pong.Content != "system.b" {
+		t.Fatalf("unexpected reply: %s", pong.Content)
+	}
+
+	// hash key is mapped to the same member by all nodes
+	for _, key := range []string{"room-1", "room-2", "room-3"} {
+		owner := &testdata.Pong{}
+		if err := master.Request(ctx, ToKey(key), "SystemComponent.Whoami", &testdata.Ping{}, owner); err != nil {
+			t.Fatal(err)
+		}
+		for _, backend := range backends {
+			pong := &testdata.Pong{}
+			if err := backend.Request(ctx, ToKey(key), "SystemComponent.Whoami", &testdata.Ping{}, pong); err != nil {
+				t.Fatal(err)
+			}
+			if pong.Content != owner.Content {
+				t.Fatalf("key %s mapped to %s and %s", key, owner.Content, pong.Content)
+			}
+		}
+		if err := backends[1].Notify(ctx, ToKey(key), "SystemComponent.Notice", &testdata.Ping{Content: key}); err != nil {
+			t.Fatal(err)
+		}
+		expect(owner.Content + " " + key)
+	}
+
+	// invalid targets
+	err := master.Request(ctx, ToService(), "SystemComponent.Whoami", &testdata.Ping{}, pong)
+	if err != ErrMultipleTargets {
+		t.Fatalf("expect multiple targets error, got: %v", err)
+	}
+	err = master.Notify(ctx, ToMember("system.unknown"), "SystemComponent.Notice", &testdata.Ping{})
+	if err != ErrNoMember {
+		t.Fatalf("expect no member error, got: %v", err)
+	}
+
+	// expired messages are dropped
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	if err := master.Notify(expired, ToMember("system.a"), "SystemComponent.Notice", &testdata.Ping{Content: "expired"}); err == nil {
+		select {
+		case r := <-received:
+			t.Fatalf("unexpected expired message received: %s", r)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
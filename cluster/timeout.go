@@ -0,0 +1,189 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/acoderup/core/logger"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/session"
+)
+
+// Error codes of the ErrorResponse replied to client by gate on behalf of backends
+const (
+	ErrorCodeNotFound    int32 = 404 // no member provides the service of route
+	ErrorCodeUnavailable int32 = 503 // the request cannot be forwarded to the member
+	ErrorCodeTimeout     int32 = 504 // the member does not respond in time
+)
+
+type (
+	// pendingRequests tracks the requests of a gate session which have been forwarded
+	// to backends and not responded yet
+	pendingRequests struct {
+		mu       sync.Mutex
+		requests map[uint64]*pendingRequest
+	}
+
+	pendingRequest struct {
+		timer   *time.Timer
+		expired bool         // the error has been replied, the late response will be dropped
+		call    *breakerCall // records the result in the circuit breaker of backend
+	}
+)
+
+var errRequestTimeout = errors.New("request timeout")
+
+// track starts the timer of the request mid, expire will be called if the request
+// is not responded in time
+func (p *pendingRequests) track(mid uint64, timeout time.Duration, expire func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.requests == nil {
+		p.requests = map[uint64]*pendingRequest{}
+	}
+	if r := p.requests[mid]; r != nil {
+		r.timer.Stop()
+		if !r.expired {
+			r.call.cancel()
+		}
+	}
+	p.requests[mid] = &pendingRequest{timer: time.AfterFunc(timeout, expire)}
+}
+
+// observe records the result of the request mid in the circuit breaker by call once
+// the request is responded, failed or expired, it reports false if the request is not
+// tracked
+func (p *pendingRequests) observe(mid uint64, call *breakerCall) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.requests[mid]
+	if r == nil || r.expired {
+		return false
+	}
+	r.call = call
+	return true
+}
+
+// finish removes the request mid with the result err of forwarding, nil means the
+// request has been responded. It reports false if the request has expired.
+func (p *pendingRequests) finish(mid uint64, err error) bool {
+	p.mu.Lock()
+	r := p.requests[mid]
+	if r == nil {
+		p.mu.Unlock()
+		return true
+	}
+	delete(p.requests, mid)
+	p.mu.Unlock()
+	r.timer.Stop()
+	if !r.expired {
+		r.call.done(err)
+	}
+	return !r.expired
+}
+
+// expire marks the request mid as expired, it reports false if the request has
+// been responded
+func (p *pendingRequests) expire(mid uint64) bool {
+	p.mu.Lock()
+	r := p.requests[mid]
+	if r == nil || r.expired {
+		p.mu.Unlock()
+		return false
+	}
+	r.expired = true
+	p.mu.Unlock()
+	r.call.done(errRequestTimeout)
+	return true
+}
+
+// clear stops the timers of all pending requests, the results of them are not recorded
+// since the session has been closed
+func (p *pendingRequests) clear() {
+	p.mu.Lock()
+	requests := p.requests
+	p.requests = nil
+	p.mu.Unlock()
+	for _, r := range requests {
+		r.timer.Stop()
+		if !r.expired {
+			r.call.cancel()
+		}
+	}
+}
+
+// trackRequest replies a timeout error to client if the request forwarded to backend is
+// not responded in RequestTimeout, the requests without message id are not tracked
+func (h *LocalHandler) trackRequest(a *agent, msg *message.Message) {
+	timeout := h.currentNode.RequestTimeout
+	if timeout <= 0 || msg.Type != message.Request || msg.ID == 0 {
+		return
+	}
+	mid, route := msg.ID, msg.Route
+	a.requests.track(mid, timeout, func() {
+		if !a.requests.expire(mid) {
+			return
+		}
+		h.timeoutMu.Lock()
+		if h.timeouts == nil {
+			h.timeouts = map[string]uint64{}
+		}
+		h.timeouts[route]++
+		h.timeoutMu.Unlock()
+		logger.Logger.Tracef("Request (%d:%s) of session[%d] timeout", mid, route, a.session.ID())
+		a.replyError(mid, ErrorCodeTimeout, fmt.Sprintf("request %s timeout", route))
+	})
+}
+
+// failRequest replies an error to client if the request cannot be forwarded to backend
+func (h *LocalHandler) failRequest(s *session.Session, msg *message.Message, code int32, err error) {
+	a, ok := s.NetworkEntity().(*agent)
+	if !ok || msg.Type != message.Request || !a.requests.finish(msg.ID, err) {
+		return
+	}
+	a.replyError(msg.ID, code, err.Error())
+}
+
+// replyError sends the ErrorResponse of request mid to client
+func (a *agent) replyError(mid uint64, code int32, text string) {
+	data, err := message.Serialize(&clusterpb.ErrorResponse{Code: code, Message: text})
+	if err != nil {
+		logger.Logger.Tracef("Serialize error response error: %v", err)
+		return
+	}
+	if err := a.send(pendingMessage{typ: message.Response, mid: mid, payload: data}); err != nil {
+		logger.Logger.Tracef("Reply error of request %d error: %v", mid, err)
+	}
+}
+
+// RequestTimeouts returns the count of the requests forwarded by current node which are
+// not responded in time, keyed by route
+func (n *Node) RequestTimeouts() map[string]uint64 {
+	n.handler.timeoutMu.Lock()
+	defer n.handler.timeoutMu.Unlock()
+	return maps.Clone(n.handler.timeouts)
+}
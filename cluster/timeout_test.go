@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano/benchmark/testdata"
+	"github.com/acoderup/nano/cluster/clusterpb"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/internal/message"
+	"github.com/acoderup/nano/scheduler"
+	"github.com/acoderup/nano/session"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	go scheduler.Sched()
+
+	gate, backend := startGateAndBackend(t, "timeout", false, &AccountComponent{})
+	defer gate.Shutdown()
+	defer backend.Shutdown()
+	gate.RequestTimeout = 200 * time.Millisecond
+	waitFor(t, func() bool { return len(gate.handler.findMembers("AccountComponent")) == 1 })
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go gate.handler.handle(conn, "", "")
+	var s *session.Session
+	waitFor(t, func() bool {
+		gate.mu.RLock()
+		defer gate.mu.RUnlock()
+		for _, v := range gate.sessions {
+			s = v
+		}
+		return s != nil
+	})
+
+	request := func(id uint64, route string) {
+		data, _ := message.Serialize(&testdata.Ping{Content: "ping"})
+		gate.handler.remoteProcess(s, &message.Message{Type: message.Request, ID: id, Route: route, Data: data}, true)
+	}
+	read := func() []byte {
+		peer.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, 1024)
+		n, err := peer.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+	expectError := func(code int32) {
+		resp := &clusterpb.ErrorResponse{}
+		if err := env.Serializer.Unmarshal(read(), resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != code {
+			t.Fatalf("expect error code %d, got: %v", code, resp)
+		}
+	}
+	expectPong := func() {
+		pong := &testdata.Pong{}
+		if err := env.Serializer.Unmarshal(read(), pong); err != nil {
+			t.Fatal(err)
+		}
+		if pong.Content != "session ping" {
+			t.Fatalf("unexpected response: %v", pong)
+		}
+	}
+
+	// the request cannot be forwarded is replied immediately
+	request(1, "UnknownComponent.Query")
+	expectError(ErrorCodeNotFound)
+
+	// the request not responded by backend is replied after timeout
+	start := time.Now()
+	request(2, "AccountComponent.Fail")
+	expectError(ErrorCodeTimeout)
+	if elapsed := time.Since(start); elapsed < gate.RequestTimeout {
+		t.Fatalf("expect timeout after %v, got: %v", gate.RequestTimeout, elapsed)
+	}
+	if timeouts := gate.RequestTimeouts(); timeouts["AccountComponent.Fail"] != 1 || len(timeouts) != 1 {
+		t.Fatalf("unexpected timeout counts: %v", timeouts)
+	}
+
+	// the late response is dropped
+	if err := s.ResponseMID(2, []byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	request(3, "AccountComponent.Query")
+	expectPong()
+	if timeouts := gate.RequestTimeouts(); timeouts["AccountComponent.Query"] != 0 {
+		t.Fatalf("unexpected timeout counts: %v", timeouts)
+	}
+}
@@ -20,6 +20,8 @@
 
 package component
 
+import "github.com/acoderup/nano/session"
+
 // Component is the interface that represent a component.
 type Component interface {
 	Init()
@@ -27,3 +29,16 @@ type Component interface {
 	BeforeShutdown()
 	Shutdown()
 }
+
+// SessionMigrator is implemented by the components which keep their own state of
+// sessions, the state will be moved along with the session when the session is
+// migrated to another cluster member. The methods are not registered as handlers.
+type SessionMigrator interface {
+	// ExportSession returns the state of session kept by the component in source member
+	ExportSession(s *session.Session) ([]byte, error)
+	// ImportSession restores the state exported by the component in source member
+	ImportSession(s *session.Session, state []byte) error
+	// SessionMigrated is called in source member after the session has been restored
+	// by target member, the state of session can be released
+	SessionMigrated(s *session.Session)
+}
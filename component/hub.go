@@ -38,3 +38,12 @@ func (cs *Components) Register(c Component, options ...Option) {
 func (cs *Components) List() []CompWithOptions {
 	return cs.comps
 }
+
+// IsSingleton reports whether the component is registered with WithSingleton
+func (c CompWithOptions) IsSingleton() bool {
+	var opts options
+	for _, opt := range c.Opts {
+		opt(&opts)
+	}
+	return opts.singleton
+}
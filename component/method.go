@@ -21,6 +21,7 @@
 package component
 
 import (
+	"context"
 	"reflect"
 	"unicode"
 	"unicode/utf8"
@@ -29,9 +30,11 @@ import (
 )
 
 var (
-	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
-	typeOfBytes   = reflect.TypeOf(([]byte)(nil))
-	typeOfSession = reflect.TypeOf(session.New(nil))
+	typeOfError    = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfBytes    = reflect.TypeOf(([]byte)(nil))
+	typeOfSession  = reflect.TypeOf(session.New(nil))
+	typeOfContext  = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfMigrator = reflect.TypeOf((*SessionMigrator)(nil)).Elem()
 )
 
 func isExported(name string) bool {
@@ -48,6 +51,15 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return isExported(t.Name()) || t.PkgPath() == ""
 }
 
+// isMigratorMethod reports whether the method of typ implements SessionMigrator
+func isMigratorMethod(typ reflect.Type, name string) bool {
+	if !typ.Implements(typeOfMigrator) {
+		return false
+	}
+	_, found := typeOfMigrator.MethodByName(name)
+	return found
+}
+
 // isHandlerMethod decide a method is suitable handler method
 func isHandlerMethod(method reflect.Method) bool {
 	mt := method.Type
@@ -56,21 +68,31 @@ func isHandlerMethod(method reflect.Method) bool {
 		return false
 	}
 
-	// Method needs three ins: receiver, *Session, []byte or pointer.
-	if mt.NumIn() != 3 {
+	// Method needs three ins: receiver, *Session, []byte or pointer, or
+	// four ins: receiver, context.Context, *Session, []byte or pointer.
+	if mt.NumIn() != 3 && mt.NumIn() != 4 {
+		return false
+	}
+	if mt.NumIn() == 4 && mt.In(1) != typeOfContext {
+		return false
+	}
+	arg := mt.NumIn() - 1
+
+	// Method needs one outs: error, or two outs: reply and error
+	if mt.NumOut() != 1 && mt.NumOut() != 2 {
 		return false
 	}
 
-	// Method needs one outs: error
-	if mt.NumOut() != 1 {
+	if t1 := mt.In(arg - 1); t1.Kind() != reflect.Ptr || t1 != typeOfSession {
 		return false
 	}
 
-	if t1 := mt.In(1); t1.Kind() != reflect.Ptr || t1 != typeOfSession {
+	if (mt.In(arg).Kind() != reflect.Ptr && mt.In(arg) != typeOfBytes) || mt.Out(mt.NumOut()-1) != typeOfError {
 		return false
 	}
 
-	if (mt.In(2).Kind() != reflect.Ptr && mt.In(2) != typeOfBytes) || mt.Out(0) != typeOfError {
+	// Reply must be []byte or pointer
+	if mt.NumOut() == 2 && mt.Out(0).Kind() != reflect.Ptr && mt.Out(0) != typeOfBytes {
 		return false
 	}
 	return true
@@ -25,6 +25,7 @@ type (
 		name      string              // component name
 		nameFunc  func(string) string // rename handler name
 		schedName string              // schedName name
+		singleton bool                // run in only one node of cluster
 	}
 
 	// Option used to customize handler
@@ -52,3 +53,13 @@ func WithSchedulerName(name string) Option {
 		opt.schedName = name
 	}
 }
+
+// WithSingleton makes the component run in only one node of cluster, the owner is
+// elected by master among the nodes registered the component. The Init and AfterInit
+// hooks are called when current node becomes the owner, and BeforeShutdown and Shutdown
+// are called when the ownership is handed over to another node.
+func WithSingleton() Option {
+	return func(opt *options) {
+		opt.singleton = true
+	}
+}
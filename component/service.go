@@ -28,10 +28,12 @@ import (
 type (
 	//Handler represents a message.Message's handler's meta information.
 	Handler struct {
-		Receiver reflect.Value  // receiver of method
-		Method   reflect.Method // method stub
-		Type     reflect.Type   // arg type of method
-		IsRawArg bool           // whether the data need to unserialize
+		Receiver   reflect.Value  // receiver of method
+		Method     reflect.Method // method stub
+		Type       reflect.Type   // arg type of method
+		IsRawArg   bool           // whether the data need to unserialize
+		HasReply   bool           // whether the method returns a reply before error
+		HasContext bool           // whether the method accepts a context.Context before session
 	}
 
 	// Service implements a specific service, some of it's methods will be
@@ -42,6 +44,7 @@ type (
 		Receiver  reflect.Value       // receiver of methods for the service
 		Handlers  map[string]*Handler // registered methods
 		SchedName string              // name of scheduler variable in session data
+		Singleton bool                // whether the service runs in only one node of cluster
 		Options   options             // options
 	}
 )
@@ -63,6 +66,7 @@ func NewService(comp Component, opts []Option) *Service {
 		s.Name = reflect.Indirect(s.Receiver).Type().Name()
 	}
 	s.SchedName = s.Options.schedName
+	s.Singleton = s.Options.singleton
 
 	return s
 }
@@ -74,16 +78,23 @@ func (s *Service) suitableHandlerMethods(typ reflect.Type) map[string]*Handler {
 		method := typ.Method(m)
 		mt := method.Type
 		mn := method.Name
-		if isHandlerMethod(method) {
+		if isHandlerMethod(method) && !isMigratorMethod(typ, mn) {
+			arg := mt.NumIn() - 1
 			raw := false
-			if mt.In(2) == typeOfBytes {
+			if mt.In(arg) == typeOfBytes {
 				raw = true
 			}
 			// rewrite handler name
 			if s.Options.nameFunc != nil {
 				mn = s.Options.nameFunc(mn)
 			}
-			methods[mn] = &Handler{Method: method, Type: mt.In(2), IsRawArg: raw}
+			methods[mn] = &Handler{
+				Method:     method,
+				Type:       mt.In(arg),
+				IsRawArg:   raw,
+				HasReply:   mt.NumOut() == 2,
+				HasContext: mt.NumIn() == 4,
+			}
 		}
 	}
 	return methods
@@ -95,6 +106,8 @@ func (s *Service) suitableHandlerMethods(typ reflect.Type) map[string]*Handler {
 // - two arguments, both of exported type
 // - the first argument is *session.Session
 // - the second argument is []byte or a pointer
+// - an optional context.Context argument before *session.Session
+// - returns error, or a reply which is []byte or a pointer and error
 func (s *Service) ExtractHandler() error {
 	typeName := reflect.Indirect(s.Receiver).Type().Name()
 	if typeName == "" {
@@ -126,6 +126,49 @@ func Listen(addr string, opts ...Option) {
 	atomic.StoreInt32(&running, 0)
 }
 
+// ConnPoolStats returns the statistics of the connection pools to other cluster members
+func ConnPoolStats() []cluster.ConnPoolStats {
+	if node := runtime.CurrentNode; node != nil {
+		return node.ConnPoolStats()
+	}
+	return nil
+}
+
+// RequestTimeouts returns the count of the requests forwarded by current node which are
+// not responded in time, keyed by route
+func RequestTimeouts() map[string]uint64 {
+	if node := runtime.CurrentNode; node != nil {
+		return node.RequestTimeouts()
+	}
+	return nil
+}
+
+// CircuitBreakers returns the state of the circuit breakers of the members which current
+// node has called, keyed by the service address of member
+func CircuitBreakers() map[string]string {
+	if node := runtime.CurrentNode; node != nil {
+		return node.CircuitBreakers()
+	}
+	return nil
+}
+
+// Drain marks current node as draining, so other members will not route new sessions
+// to it, then waits for the bound sessions closed and shuts down nano. The shutdown is
+// forced after timeout, progress will be called with the count of bound sessions on
+// each check if it is not nil.
+func Drain(timeout time.Duration, progress func(bound int)) error {
+	node := runtime.CurrentNode
+	if node == nil {
+		return cluster.ErrNodeNotStarted
+	}
+	if err := node.Drain(); err != nil {
+		logger.Logger.Tracef("Drain current node failed: %v", err)
+	}
+	err := node.WaitDrained(timeout, progress)
+	Shutdown()
+	return err
+}
+
 // Shutdown send a signal to let 'nano' shutdown itself.
 func Shutdown() {
 	close(env.Die)
@@ -0,0 +1,256 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package memnet connects the nodes in current process through in-memory connections
+// instead of TCP ports, the tests of cluster start nodes on it. The addresses are only
+// the names of listeners, nothing is bound in the operating system.
+package memnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufferSize = 1 << 20
+
+var (
+	// ErrPartitioned is returned when dialing an address partitioned from the dialer
+	ErrPartitioned = errors.New("memnet: addresses are partitioned")
+	// ErrRefused is returned when dialing an address nobody listens on
+	ErrRefused = errors.New("memnet: connection refused")
+)
+
+type (
+	// link is a directed connection between two addresses
+	link struct {
+		from, to string
+	}
+
+	// Network is a set of in-memory listeners, the gRPC connections between members
+	// are served by bufconn listeners, and the connections to the pipe addresses are
+	// net.Pipe, which preserve the boundaries of the writes
+	Network struct {
+		mu         sync.Mutex
+		listeners  map[string]listener
+		pipeAddrs  map[string]bool
+		conns      map[link][]net.Conn
+		partitions map[link]bool
+	}
+
+	listener interface {
+		net.Listener
+		dial(ctx context.Context) (net.Conn, error)
+	}
+
+	// bufListener is a bufconn listener unregistered from network when closed
+	bufListener struct {
+		*bufconn.Listener
+		network *Network
+		addr    string
+	}
+
+	// pipeListener accepts the connections created by Dial
+	pipeListener struct {
+		network *Network
+		addr    string
+		conns   chan net.Conn
+		done    chan struct{}
+		once    sync.Once
+	}
+
+	memAddr string
+)
+
+// New returns an empty network
+func New() *Network {
+	return &Network{
+		listeners:  map[string]listener{},
+		pipeAddrs:  map[string]bool{},
+		conns:      map[link][]net.Conn{},
+		partitions: map[link]bool{},
+	}
+}
+
+// AcceptPipes makes the listener of addr accept net.Pipe connections, it should be
+// called before listening on addr, e.g. the client addresses of gates
+func (nw *Network) AcceptPipes(addr string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.pipeAddrs[addr] = true
+}
+
+// Listen creates the in-memory listener of addr, it can be used as the Listener option
+// of nodes. The address can be listened again after the listener closed.
+func (nw *Network) Listen(addr string) (net.Listener, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if _, found := nw.listeners[addr]; found {
+		return nil, fmt.Errorf("memnet: address %s already in use", addr)
+	}
+	var l listener
+	if nw.pipeAddrs[addr] {
+		l = &pipeListener{network: nw, addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+	} else {
+		l = &bufListener{Listener: bufconn.Listen(bufferSize), network: nw, addr: addr}
+	}
+	nw.listeners[addr] = l
+	return l, nil
+}
+
+// Dialer returns the dialer of the node listening on from, it can be used as the Dialer
+// option of nodes
+func (nw *Network) Dialer(from string) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return nw.Dial(ctx, from, addr)
+	}
+}
+
+// Dial connects from to the listener of address to
+func (nw *Network) Dial(ctx context.Context, from, to string) (net.Conn, error) {
+	nw.mu.Lock()
+	l := nw.listeners[to]
+	partitioned := nw.partitions[link{from, to}]
+	nw.mu.Unlock()
+	if partitioned {
+		return nil, ErrPartitioned
+	}
+	if l == nil {
+		return nil, ErrRefused
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	// The partition may happen while dialing
+	if nw.partitions[link{from, to}] {
+		conn.Close()
+		return nil, ErrPartitioned
+	}
+	key := link{from, to}
+	nw.conns[key] = append(nw.conns[key], conn)
+	return conn, nil
+}
+
+// Down closes the listener of addr and all connections from or to addr
+func (nw *Network) Down(addr string) {
+	nw.mu.Lock()
+	l := nw.listeners[addr]
+	delete(nw.listeners, addr)
+	for key, conns := range nw.conns {
+		if key.from == addr || key.to == addr {
+			closeConns(conns)
+			delete(nw.conns, key)
+		}
+	}
+	nw.mu.Unlock()
+	if l != nil {
+		l.Close()
+	}
+}
+
+// Partition drops the connections between a and b, and rejects the new ones until Heal
+func (nw *Network) Partition(a, b string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	for _, key := range []link{{a, b}, {b, a}} {
+		nw.partitions[key] = true
+		closeConns(nw.conns[key])
+		delete(nw.conns, key)
+	}
+}
+
+// Heal accepts the connections between a and b partitioned by Partition again
+func (nw *Network) Heal(a, b string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	delete(nw.partitions, link{a, b})
+	delete(nw.partitions, link{b, a})
+}
+
+// unregister removes the closed listener of addr
+func (nw *Network) unregister(addr string, l listener) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.listeners[addr] == l {
+		delete(nw.listeners, addr)
+	}
+}
+
+func closeConns(conns []net.Conn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (l *bufListener) dial(ctx context.Context) (net.Conn, error) {
+	return l.DialContext(ctx)
+}
+
+func (l *bufListener) Close() error {
+	l.network.unregister(l.addr, l)
+	return l.Listener.Close()
+}
+
+func (l *bufListener) Addr() net.Addr {
+	return memAddr(l.addr)
+}
+
+func (l *pipeListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, ErrRefused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.network.unregister(l.addr, l)
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return memAddr(l.addr)
+}
+
+func (a memAddr) Network() string { return "memory" }
+func (a memAddr) String() string  { return string(a) }
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"github.com/acoderup/core/logger"
 	"strings"
+	"time"
 )
 
 // Type represents the type of message, which could be Request/Notify/Response/Push
@@ -72,11 +73,13 @@ var (
 
 // Message represents a unmarshaled message or a message which to be marshaled
 type Message struct {
-	Type       Type   // message type
-	ID         uint64 // unique id, zero while notify mode
-	Route      string // route for locating service
-	Data       []byte // payload
-	compressed bool   // is message compressed
+	Type       Type              // message type
+	ID         uint64            // unique id, zero while notify mode
+	Route      string            // route for locating service
+	Data       []byte            // payload
+	Metadata   map[string]string // metadata forwarded along with message, not encoded to client
+	Deadline   time.Time         // deadline forwarded along with message, not encoded to client
+	compressed bool              // is message compressed
 }
 
 // New returns a new message instance
@@ -84,6 +87,14 @@ func New() *Message {
 	return &Message{}
 }
 
+// SetMetadata associates value with the key in message metadata
+func (m *Message) SetMetadata(key, value string) {
+	if m.Metadata == nil {
+		m.Metadata = map[string]string{}
+	}
+	m.Metadata[key] = value
+}
+
 // String, implementation of fmt.Stringer interface
 func (m *Message) String() string {
 	return fmt.Sprintf("%s %s (%dbytes)", types[m.Type], m.Route, len(m.Data))
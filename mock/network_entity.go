@@ -21,6 +21,7 @@
 package mock
 
 import (
+	"context"
 	"fmt"
 	"net"
 )
@@ -61,6 +62,17 @@ func (n *NetworkEntity) RPC(route string, v interface{}) error {
 	return nil
 }
 
+// RPCContext implements the session.NetworkEntity interface
+func (n *NetworkEntity) RPCContext(_ context.Context, route string, v interface{}) error {
+	return n.RPC(route, v)
+}
+
+// Call implements the session.NetworkEntity interface
+func (n *NetworkEntity) Call(_ context.Context, route string, v interface{}, _ interface{}) error {
+	n.rpcCall = append(n.rpcCall, message{route: route, data: v})
+	return nil
+}
+
 // Push implements the session.NetworkEntity interface
 func (n *NetworkEntity) Push(route string, v interface{}) error {
 	n.messages = append(n.messages, message{route: route, data: v})
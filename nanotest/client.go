@@ -0,0 +1,137 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package nanotest
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	headLength     = 3    // 1 byte flag and 2 bytes length
+	longHeadLength = 5    // 1 byte flag and 4 bytes length
+	longLengthFlag = 0x08 // the length of frame is 4 bytes
+)
+
+var (
+	// ErrClientClosed is returned when receiving from a closed client
+	ErrClientClosed = errors.New("nanotest: client closed")
+	// ErrReceiveTimeout is returned when nothing is received before timeout
+	ErrReceiveTimeout = errors.New("nanotest: receive timeout")
+)
+
+// Client is a fake client connected to a gate through an in-memory connection. The
+// payloads sent by the client are framed like the gate decoder expects, the gate
+// forwards every frame including its header to the Gate.Message handler of backends,
+// and the responses and pushes written by the gate are received as they are.
+type Client struct {
+	conn     net.Conn
+	received chan []byte
+	die      chan struct{}
+	once     sync.Once
+}
+
+func newClient(conn net.Conn) *Client {
+	c := &Client{conn: conn, received: make(chan []byte, 64), die: make(chan struct{})}
+	go c.read()
+	return c
+}
+
+// Frame encodes the payload as a frame of the gate protocol, the handlers receive
+// the frames sent by clients as their data
+func Frame(data []byte) []byte {
+	if len(data) <= math.MaxUint16 {
+		buf := make([]byte, headLength+len(data))
+		binary.BigEndian.PutUint16(buf[1:headLength], uint16(len(data)))
+		copy(buf[headLength:], data)
+		return buf
+	}
+	buf := make([]byte, longHeadLength+len(data))
+	buf[0] = longLengthFlag
+	binary.BigEndian.PutUint32(buf[1:longHeadLength], uint32(len(data)))
+	copy(buf[longHeadLength:], data)
+	return buf
+}
+
+// Payload returns the payload of frame encoded by Frame
+func Payload(frame []byte) []byte {
+	if len(frame) > 0 && frame[0]&longLengthFlag != 0 {
+		return frame[longHeadLength:]
+	}
+	return frame[headLength:]
+}
+
+func (c *Client) read() {
+	defer c.Close()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		select {
+		case c.received <- data:
+		case <-c.die:
+			return
+		}
+	}
+}
+
+// Send sends the payload to the gate in a frame
+func (c *Client) Send(data []byte) error {
+	_, err := c.conn.Write(Frame(data))
+	return err
+}
+
+// Receive returns the next payload written by the gate, it returns an error if nothing
+// is received in timeout or the client has been closed
+func (c *Client) Receive(timeout time.Duration) ([]byte, error) {
+	select {
+	case data := <-c.received:
+		return data, nil
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data := <-c.received:
+		return data, nil
+	case <-c.die:
+		return nil, ErrClientClosed
+	case <-timer.C:
+		return nil, ErrReceiveTimeout
+	}
+}
+
+// Close closes the connection to the gate, the session will be closed by the gate
+func (c *Client) Close() {
+	c.once.Do(func() {
+		close(c.die)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,336 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package nanotest runs a nano cluster inside a test process. The master, gates and
+// backends communicate through in-memory connections instead of TCP ports, the fake
+// clients connect to gates in memory as well, and the helpers wait for the membership
+// convergence deterministically, kill nodes or partition them from each other.
+//
+//	c := nanotest.New(t)
+//	gate := c.AddGate("gate")
+//	c.AddBackend("room", comps)
+//	c.WaitConverged()
+//	client := c.Connect(gate)
+//
+// The heartbeat interval is global in nano, New shortens it to make the failure
+// detection fast, so the tests using Cluster should not run in parallel.
+//
+// The fake clients are not built on the Connector of benchmark/io, which cannot talk to
+// the gates: it dials TCP addresses only, and speaks the pomelo packets with handshakes
+// and routed messages, while the gates decode the frames of a 1 byte flag and a 2 or 4
+// bytes length, and forward every frame to the Gate.Message handler of backends as it is.
+package nanotest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano"
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/internal/env"
+	"github.com/acoderup/nano/internal/memnet"
+	"github.com/acoderup/nano/scheduler"
+)
+
+const (
+	// DefaultHeartbeat is the heartbeat interval used by Cluster
+	DefaultHeartbeat = 100 * time.Millisecond
+	// DefaultSessionIdleTimeout is the idle timeout of the client sessions, the fake
+	// clients do not send heartbeats
+	DefaultSessionIdleTimeout = time.Minute
+	// DefaultWaitTimeout bounds the waits of Cluster
+	DefaultWaitTimeout = 10 * time.Second
+
+	waitInterval = 10 * time.Millisecond
+)
+
+var (
+	// ErrPartitioned is returned when dialing a node partitioned from the dialer
+	ErrPartitioned = memnet.ErrPartitioned
+	// ErrRefused is returned when dialing an address nobody listens on
+	ErrRefused = memnet.ErrRefused
+)
+
+var schedOnce sync.Once
+
+// Cluster is a nano cluster running in current process
+type Cluster struct {
+	tb      testing.TB
+	network *memnet.Network
+	opts    []nano.Option
+
+	mu      sync.Mutex
+	master  *cluster.Node
+	nodes   []*cluster.Node // alive nodes in starting order
+	clients map[*cluster.Node][]*Client
+}
+
+// New starts the master of a cluster, the options are applied to all nodes of the
+// cluster. The cluster is closed when the test finishes.
+func New(tb testing.TB, opts ...nano.Option) *Cluster {
+	tb.Helper()
+	schedOnce.Do(func() { go scheduler.Sched() })
+
+	heartbeat := env.Heartbeat
+	env.Heartbeat = DefaultHeartbeat
+	c := &Cluster{
+		tb:      tb,
+		network: memnet.New(),
+		opts:    opts,
+		clients: map[*cluster.Node][]*Client{},
+	}
+	tb.Cleanup(func() {
+		c.Close()
+		env.Heartbeat = heartbeat
+	})
+
+	c.start("master", func(opt *cluster.Options) {
+		opt.IsMaster = true
+	})
+	return c
+}
+
+// Master returns the master node
+func (c *Cluster) Master() *cluster.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.master
+}
+
+// AddGate starts a gate which accepts the clients connected by Connect
+func (c *Cluster) AddGate(name string, opts ...nano.Option) *cluster.Node {
+	c.tb.Helper()
+	clientAddr := name + ".client"
+	c.network.AcceptPipes(clientAddr)
+	return c.start(name, func(opt *cluster.Options) {
+		opt.ClientAddr = clientAddr
+		opt.Components = &component.Components{}
+	}, opts...)
+}
+
+// AddBackend starts a backend which provides the services of comps
+func (c *Cluster) AddBackend(name string, comps *component.Components, opts ...nano.Option) *cluster.Node {
+	c.tb.Helper()
+	return c.start(name, func(opt *cluster.Options) {
+		opt.Components = comps
+	}, opts...)
+}
+
+// start starts the node of name, the service address of the node is the name
+func (c *Cluster) start(name string, init func(opt *cluster.Options), opts ...nano.Option) *cluster.Node {
+	c.tb.Helper()
+	opt := cluster.Options{
+		Components:         &component.Components{},
+		RetryInterval:      waitInterval,
+		ConnMaxBackoff:     waitInterval, // reconnect to the restarted or healed nodes immediately
+		SessionIdleTimeout: DefaultSessionIdleTimeout,
+		Listener:           c.network.Listen,
+		Dialer:             c.network.Dialer(name),
+	}
+	if master := c.Master(); master != nil {
+		opt.AdvertiseAddr = master.ServiceAddr
+	}
+	init(&opt)
+	for _, o := range slices.Concat(c.opts, opts) {
+		o(&opt)
+	}
+
+	return c.launch(name, opt)
+}
+
+// launch starts the node of name with the options, and records it as alive
+func (c *Cluster) launch(name string, opt cluster.Options) *cluster.Node {
+	c.tb.Helper()
+	node := &cluster.Node{Options: opt, ServiceAddr: name}
+	if err := node.Startup(); err != nil {
+		c.network.Down(name)
+		c.tb.Fatalf("nanotest: start node %s failed: %v", name, err)
+	}
+	c.mu.Lock()
+	if opt.IsMaster {
+		c.master = node
+		c.nodes = slices.Insert(c.nodes, 0, node)
+	} else {
+		c.nodes = append(c.nodes, node)
+	}
+	c.mu.Unlock()
+	return node
+}
+
+// Nodes returns the nodes alive in starting order, the master is the first one
+func (c *Cluster) Nodes() []*cluster.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.nodes)
+}
+
+// Connect connects a fake client to the gate, the client is closed when the gate
+// is killed or the cluster is closed
+func (c *Cluster) Connect(gate *cluster.Node) *Client {
+	c.tb.Helper()
+	if gate.ClientAddr == "" {
+		c.tb.Fatalf("nanotest: node %s is not a gate", gate.ServiceAddr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWaitTimeout)
+	defer cancel()
+	// The client listener of gate is created asynchronously
+	conn, err := c.network.Dial(ctx, "client", gate.ClientAddr)
+	for err == ErrRefused {
+		select {
+		case <-ctx.Done():
+			c.tb.Fatalf("nanotest: connect gate %s failed: %v", gate.ServiceAddr, err)
+		case <-time.After(waitInterval):
+		}
+		conn, err = c.network.Dial(ctx, "client", gate.ClientAddr)
+	}
+	if err != nil {
+		c.tb.Fatalf("nanotest: connect gate %s failed: %v", gate.ServiceAddr, err)
+	}
+	client := newClient(conn)
+	c.mu.Lock()
+	c.clients[gate] = append(c.clients[gate], client)
+	c.mu.Unlock()
+	return client
+}
+
+// Kill stops the node abruptly like a crashed process, the connections to it are
+// dropped and the master removes it after the heartbeat timeout
+func (c *Cluster) Kill(node *cluster.Node) {
+	c.remove(node)
+	node.Kill()
+	c.network.Down(node.ServiceAddr)
+	if node.ClientAddr != "" {
+		c.network.Down(node.ClientAddr)
+	}
+}
+
+// Restart kills the node and starts it again with the same options, like a crashed
+// process restarted by its supervisor. The restarted node is returned, and replaces
+// the master if the master is restarted.
+func (c *Cluster) Restart(node *cluster.Node) *cluster.Node {
+	c.tb.Helper()
+	c.Kill(node)
+	return c.launch(node.ServiceAddr, node.Options)
+}
+
+// Shutdown stops the node gracefully, the node unregisters from the master
+func (c *Cluster) Shutdown(node *cluster.Node) {
+	c.remove(node)
+	node.Shutdown()
+	c.network.Down(node.ServiceAddr)
+	if node.ClientAddr != "" {
+		c.network.Down(node.ClientAddr)
+	}
+}
+
+func (c *Cluster) remove(node *cluster.Node) {
+	c.mu.Lock()
+	c.nodes = slices.DeleteFunc(c.nodes, func(n *cluster.Node) bool { return n == node })
+	clients := c.clients[node]
+	delete(c.clients, node)
+	c.mu.Unlock()
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// Partition drops the connections between a and b, and rejects the new connections
+// between them until Heal is called
+func (c *Cluster) Partition(a, b *cluster.Node) {
+	c.network.Partition(a.ServiceAddr, b.ServiceAddr)
+}
+
+// Heal reconnects the nodes partitioned by Partition
+func (c *Cluster) Heal(a, b *cluster.Node) {
+	c.network.Heal(a.ServiceAddr, b.ServiceAddr)
+}
+
+// WaitFor waits until cond is satisfied, the test fails after DefaultWaitTimeout
+func (c *Cluster) WaitFor(cond func() bool) {
+	c.tb.Helper()
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.tb.Fatal("nanotest: condition not satisfied before timeout")
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// WaitMembers waits until the node knows exactly the members, the node itself is
+// always considered known
+func (c *Cluster) WaitMembers(node *cluster.Node, members ...*cluster.Node) {
+	c.tb.Helper()
+	expect := map[string]bool{node.ServiceAddr: true}
+	for _, m := range members {
+		expect[m.ServiceAddr] = true
+	}
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for {
+		known := knownMembers(node)
+		if maps.Equal(known, expect) {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.tb.Fatalf("nanotest: node %s knows members %v, expect %v", node.ServiceAddr, known, expect)
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// WaitConverged waits until all alive nodes know each other and observe the same
+// membership epoch as the master
+func (c *Cluster) WaitConverged() {
+	c.tb.Helper()
+	nodes := c.Nodes()
+	for _, node := range nodes {
+		c.WaitMembers(node, nodes...)
+	}
+	master := c.Master()
+	c.WaitFor(func() bool {
+		for _, node := range nodes {
+			if node.MembershipEpoch() != master.MembershipEpoch() {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Close shuts down all alive nodes, the master is shut down last
+func (c *Cluster) Close() {
+	nodes := c.Nodes()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		c.Shutdown(nodes[i])
+	}
+}
+
+func knownMembers(node *cluster.Node) map[string]bool {
+	known := map[string]bool{node.ServiceAddr: true}
+	for _, m := range node.Members() {
+		known[m.ServiceAddr] = true
+	}
+	return known
+}
@@ -0,0 +1,115 @@
+package nanotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acoderup/nano"
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/component"
+	"github.com/acoderup/nano/session"
+)
+
+type GateComponent struct {
+	component.Base
+	name string
+}
+
+func (g *GateComponent) Message(s *session.Session, data []byte) error {
+	// the frame sent by client is forwarded with its header
+	return s.Response([]byte(g.name + ":" + string(Payload(data))))
+}
+
+func backendComponents(name string) *component.Components {
+	comps := &component.Components{}
+	comps.Register(&GateComponent{name: name}, component.WithName("Gate"))
+	return comps
+}
+
+func echo(t *testing.T, client *Client, content string) string {
+	t.Helper()
+	if err := client.Send([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := client.Receive(3 * time.Second)
+	if err != nil {
+		t.Fatalf("receive %s failed: %v", content, err)
+	}
+	return string(data)
+}
+
+func TestCluster(t *testing.T) {
+	c := New(t)
+	gate := c.AddGate("gate")
+	b1 := c.AddBackend("backend1", backendComponents("backend1"))
+	b2 := c.AddBackend("backend2", backendComponents("backend2"))
+	c.WaitConverged()
+
+	client := c.Connect(gate)
+	reply := echo(t, client, "hello")
+	bound := b1
+	if reply == "backend2:hello" {
+		bound = b2
+	} else if reply != "backend1:hello" {
+		t.Fatalf("unexpected reply: %s", reply)
+	}
+
+	// the session is rebound to the alive backend after the bound one crashed
+	c.Kill(bound)
+	c.WaitConverged()
+	alive := b1
+	if bound == b1 {
+		alive = b2
+	}
+	if reply := echo(t, client, "again"); reply != alive.ServiceAddr+":again" {
+		t.Fatalf("expect reply from %s, got: %s", alive.ServiceAddr, reply)
+	}
+
+	// the master removes the partitioned backend and it rejoins after healed
+	c.Partition(c.Master(), alive)
+	c.WaitMembers(c.Master(), gate)
+	c.Heal(c.Master(), alive)
+	c.WaitConverged()
+	if reply := echo(t, client, "healed"); reply != alive.ServiceAddr+":healed" {
+		t.Fatalf("expect reply from %s, got: %s", alive.ServiceAddr, reply)
+	}
+
+	// the clients of killed gate are closed
+	c.Kill(gate)
+	if _, err := client.Receive(time.Second); err != ErrClientClosed {
+		t.Fatalf("expect client closed, got: %v", err)
+	}
+}
+
+func TestMasterRestart(t *testing.T) {
+	var mu sync.Mutex
+	var left []string
+	listener := func(node string) nano.Option {
+		return nano.WithMembershipListener(func(e cluster.MembershipEvent) {
+			if e, ok := e.(cluster.MemberLeft); ok {
+				mu.Lock()
+				left = append(left, node+" -> "+e.Member.ServiceAddr)
+				mu.Unlock()
+			}
+		})
+	}
+	c := New(t)
+	b1 := c.AddBackend("backend1", backendComponents("backend1"), listener("backend1"))
+	b2 := c.AddBackend("backend2", backendComponents("backend2"), listener("backend2"))
+	c.WaitConverged()
+
+	// the members are kept while the restarted master rebuilds the member table
+	master := c.Restart(c.Master())
+	c.WaitMembers(master, b1, b2)
+	c.WaitConverged()
+	mu.Lock()
+	if len(left) != 0 {
+		t.Fatalf("unexpected members left: %v", left)
+	}
+	mu.Unlock()
+
+	// the members left are removed after master confirmed the member table
+	c.Kill(b2)
+	c.WaitMembers(b1, master)
+}
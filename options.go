@@ -23,6 +23,14 @@ func WithPipeline(pipeline pipeline.Pipeline) Option {
 	}
 }
 
+// WithGatePipeline sets the pipeline which processes the messages before gate forwards
+// them to backends, e.g. sets the metadata forwarded along with the messages
+func WithGatePipeline(pipeline pipeline.Pipeline) Option {
+	return func(opt *cluster.Options) {
+		opt.GatePipeline = pipeline
+	}
+}
+
 // WithCustomerRemoteServiceRoute register remote service route
 func WithCustomerRemoteServiceRoute(route cluster.CustomerRemoteServiceRoute) Option {
 	return func(opt *cluster.Options) {
@@ -30,6 +38,214 @@ func WithCustomerRemoteServiceRoute(route cluster.CustomerRemoteServiceRoute) Op
 	}
 }
 
+// WithServiceRoute sets the route strategy of the specified service, which
+// takes precedence over the customer remote service route
+func WithServiceRoute(service string, route cluster.CustomerRemoteServiceRoute) Option {
+	return func(opt *cluster.Options) {
+		if opt.ServiceRoutes == nil {
+			opt.ServiceRoutes = map[string]cluster.CustomerRemoteServiceRoute{}
+		}
+		opt.ServiceRoutes[service] = route
+	}
+}
+
+// WithRebindHandler sets the callback which will be called when the sessions bound
+// to a left member have been re-routed to another member of the service
+func WithRebindHandler(service string, handler cluster.RebindHandler) Option {
+	return func(opt *cluster.Options) {
+		if opt.RebindHandlers == nil {
+			opt.RebindHandlers = map[string]cluster.RebindHandler{}
+		}
+		opt.RebindHandlers[service] = handler
+	}
+}
+
+// WithUnaryTransport forwards the messages between cluster members by unary calls
+// instead of the member streams, which is the transport used by older versions
+func WithUnaryTransport() Option {
+	return func(opt *cluster.Options) {
+		opt.UnaryTransport = true
+	}
+}
+
+// WithConnPoolSize sets the count of connections established to each cluster member
+func WithConnPoolSize(size int) Option {
+	return func(opt *cluster.Options) {
+		opt.ConnPoolSize = size
+	}
+}
+
+// WithConnIdleTimeout sets the duration after which the connections to a cluster member
+// will be closed if they are not used, it should be longer than the heartbeat interval
+func WithConnIdleTimeout(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.ConnIdleTimeout = d
+	}
+}
+
+// WithConnMaxBackoff sets the max delay of reconnecting to a cluster member
+func WithConnMaxBackoff(d time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.ConnMaxBackoff = d
+	}
+}
+
+// WithClusterName sets the cluster name of current node, the member registers with
+// a different cluster name will be rejected by master
+func WithClusterName(name string) Option {
+	return func(opt *cluster.Options) {
+		opt.ClusterName = name
+	}
+}
+
+// WithClusterToken sets the shared secret of cluster, the calls between cluster members
+// without the same token will be rejected
+func WithClusterToken(token string) Option {
+	return func(opt *cluster.Options) {
+		opt.ClusterToken = token
+	}
+}
+
+// WithClusterTLS enables the mutual TLS between cluster members, the certificate will
+// be reloaded once the certificate or key file has been modified
+func WithClusterTLS(certificate, key, ca string) Option {
+	return func(opt *cluster.Options) {
+		opt.ClusterCertFile = certificate
+		opt.ClusterKeyFile = key
+		opt.ClusterCAFile = ca
+	}
+}
+
+// WithSessionSync synchronizes the session UID and the values of keys between gate
+// and backends, it should be enabled in all nodes of cluster
+func WithSessionSync(keys ...string) Option {
+	return func(opt *cluster.Options) {
+		opt.SyncSession = true
+		opt.SyncSessionKeys = keys
+	}
+}
+
+// WithUIDDirectory records the session bound to each UID in master, so the session
+// can be reached by cluster.PushToUID, cluster.KickUID and cluster.Multicast from any
+// node, it should be enabled in the gates and the backends bind UID
+func WithUIDDirectory() Option {
+	return func(opt *cluster.Options) {
+		opt.UIDDirectory = true
+	}
+}
+
+// WithMetadata sets the metadata of current node, which is visible to the route
+// strategies of other members, see cluster.MetadataZone for the well-known keys
+func WithMetadata(key, value string) Option {
+	return func(opt *cluster.Options) {
+		if opt.Metadata == nil {
+			opt.Metadata = map[string]string{}
+		}
+		opt.Metadata[key] = value
+	}
+}
+
+// WithLoadReporter sets the function which reports the dynamic load of current node,
+// it will be called in each heartbeat
+func WithLoadReporter(reporter func() map[string]int64) Option {
+	return func(opt *cluster.Options) {
+		opt.LoadReporter = reporter
+	}
+}
+
+// WithTopicReplay keeps the recent size messages published by current node for each
+// topic, the late subscribers can receive them by pubsub.SubscribeReplay. The messages
+// of 1024 most recently published topics are kept.
+func WithTopicReplay(size int) Option {
+	return func(opt *cluster.Options) {
+		opt.TopicReplaySize = size
+	}
+}
+
+// WithMemberTable persists the member table of master to the file, the members will be
+// reloaded as suspects after master restarted, and removed if they do not send heartbeat
+// in time. It takes effect in master only.
+func WithMemberTable(path string) Option {
+	return func(opt *cluster.Options) {
+		opt.MemberTableFile = path
+	}
+}
+
+// WithFailureDetector sets the thresholds of master detecting member failures, the member
+// will be suspected without heartbeat for suspect, and removed from cluster without
+// heartbeat for dead. The defaults are 2 and 4 heartbeat intervals.
+func WithFailureDetector(suspect, dead time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.SuspectTimeout = suspect
+		opt.DeadTimeout = dead
+	}
+}
+
+// WithPhiAccrual suspects the members by the phi accrual failure detector, which adapts
+// to the heartbeat history of each member, the member will be suspected once phi exceeds
+// threshold, e.g. 8. The dead timeout still bounds the removal of members.
+func WithPhiAccrual(threshold float64) Option {
+	return func(opt *cluster.Options) {
+		opt.PhiThreshold = threshold
+	}
+}
+
+// WithPeerProbe probes the connections to other members in each interval, members that
+// cannot be reached will be suspected locally and skipped by the route strategies until
+// they respond again
+func WithPeerProbe(interval time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.ProbeInterval = interval
+	}
+}
+
+// WithCircuitBreaker enables the circuit breakers of the members, the calls to a member
+// are rejected once the error rate of calls exceeds errorRate, e.g. 0.5, and the calls
+// slower than slowCall are counted as errors if it is not zero. The breaker allows a
+// trial call after openTimeout, default 5s, and closes if the trial call succeeds.
+// The requests forwarded by gate are counted once responded or timeout if the request
+// timeout is set by WithRequestTimeout, otherwise once sent to the member.
+func WithCircuitBreaker(errorRate float64, slowCall, openTimeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.BreakerErrorRate = errorRate
+		opt.BreakerSlowCall = slowCall
+		opt.BreakerOpenTimeout = openTimeout
+	}
+}
+
+// WithPinnedServices pins the sessions of services to the members they are bound to, the
+// sessions will not be rerouted to other members when the circuit breakers are open
+func WithPinnedServices(services ...string) Option {
+	return func(opt *cluster.Options) {
+		opt.PinnedServices = append(opt.PinnedServices, services...)
+	}
+}
+
+// WithRequestTimeout replies an error response with cluster.ErrorCodeTimeout to client if the
+// request forwarded to backend is not responded in timeout, the late response is dropped.
+// The count of timeout requests of each route can be retrieved by RequestTimeouts.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(opt *cluster.Options) {
+		opt.RequestTimeout = timeout
+	}
+}
+
+// WithMembershipListener adds a listener which receives the membership events of cluster
+// in the order they occurred
+func WithMembershipListener(listener cluster.MembershipListener) Option {
+	return func(opt *cluster.Options) {
+		opt.MembershipListeners = append(opt.MembershipListeners, listener)
+	}
+}
+
+// WithMembershipInScheduler delivers the membership events in the scheduler goroutine,
+// so the listeners can access the game state safely
+func WithMembershipInScheduler() Option {
+	return func(opt *cluster.Options) {
+		opt.ScheduleMembership = true
+	}
+}
+
 // WithAdvertiseAddr sets the advertise address option, it will be the listen address in
 // master node and an advertise address which cluster member to connect
 func WithAdvertiseAddr(addr string, retryInterval ...time.Duration) Option {
@@ -0,0 +1,77 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package pubsub provides the cluster-wide publish/subscribe topic bus, the messages
+// are delivered over the connections between members. Topics consist of segments
+// separated by dots, subscribers can use the wildcard "*" to match one segment and
+// "#" at the end to match the rest segments. The delivery is at-most-once, and the
+// recent messages can be replayed to late subscribers if nano.WithTopicReplay has
+// been specified by the publishers.
+package pubsub
+
+import (
+	"github.com/acoderup/nano/cluster"
+	"github.com/acoderup/nano/internal/runtime"
+)
+
+type (
+	// Message represents a message received from a topic
+	Message = cluster.TopicMessage
+
+	// Handler represents a callback which receives the messages of subscribed topics,
+	// it will be called in the scheduler goroutine
+	Handler = cluster.TopicHandler
+
+	// Subscription represents the subscription of a topic pattern
+	Subscription = cluster.Subscription
+)
+
+// Subscribe subscribes the topic pattern in current node
+func Subscribe(pattern string, handler Handler) (*Subscription, error) {
+	node := runtime.CurrentNode
+	if node == nil {
+		return nil, cluster.ErrNodeNotStarted
+	}
+	return node.Subscribe(pattern, handler)
+}
+
+// SubscribeReplay subscribes the topic pattern in current node, the recent messages
+// buffered by the publishers will be delivered first
+func SubscribeReplay(pattern string, handler Handler) (*Subscription, error) {
+	node := runtime.CurrentNode
+	if node == nil {
+		return nil, cluster.ErrNodeNotStarted
+	}
+	return node.SubscribeReplay(pattern, handler)
+}
+
+// Publish publishes v to the topic from current node
+func Publish(topic string, v interface{}) error {
+	node := runtime.CurrentNode
+	if node == nil {
+		return cluster.ErrNodeNotStarted
+	}
+	return node.Publish(topic, v)
+}
+
+// Match reports whether the topic matches the pattern
+func Match(pattern, topic string) bool {
+	return cluster.TopicMatch(pattern, topic)
+}
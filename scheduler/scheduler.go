@@ -21,9 +21,11 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
 	"github.com/acoderup/core/logger"
 	"github.com/acoderup/nano/internal/env"
+	"reflect"
 	"runtime/debug"
 	"sync/atomic"
 	"time"
@@ -97,3 +99,39 @@ func Close() {
 func PushTask(task Task) {
 	chTasks <- task
 }
+
+// scheduling marks the context of a task running in a scheduler
+type scheduling struct {
+	sched   LocalScheduler // nil for the global scheduler
+	running atomic.Bool
+}
+
+type schedulingKey struct{}
+
+// WithScheduling returns a copy of ctx marked as the context of current task running
+// in sched, nil for the global scheduler, and the function to call once the task returns.
+// The context should be passed to the functions called by the task, so they can check it
+// by Scheduling.
+func WithScheduling(ctx context.Context, sched LocalScheduler) (context.Context, func()) {
+	s := &scheduling{sched: sched}
+	s.running.Store(true)
+	return context.WithValue(ctx, schedulingKey{}, s), func() { s.running.Store(false) }
+}
+
+// Scheduling reports whether ctx is the context of a task still running in sched, nil
+// for the global scheduler, see WithScheduling. The task should not wait for the other
+// tasks of sched, which will never run until the waiting task returns.
+func Scheduling(ctx context.Context, sched LocalScheduler) bool {
+	s, ok := ctx.Value(schedulingKey{}).(*scheduling)
+	if !ok || !s.running.Load() {
+		return false
+	}
+	if s.sched == nil || sched == nil {
+		return s.sched == nil && sched == nil
+	}
+	// The schedulers of uncomparable types cannot be identified
+	if !reflect.TypeOf(s.sched).Comparable() || !reflect.TypeOf(sched).Comparable() {
+		return false
+	}
+	return s.sched == sched
+}
@@ -0,0 +1,67 @@
+// Copyright (c) nano Authors. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+)
+
+type funcScheduler func(Task)
+
+func (f funcScheduler) Schedule(task Task) { f(task) }
+
+type queueScheduler struct{ tasks []Task }
+
+func (q *queueScheduler) Schedule(task Task) { q.tasks = append(q.tasks, task) }
+
+func TestScheduling(t *testing.T) {
+	if Scheduling(context.Background(), nil) {
+		t.Fatal("unexpected scheduling without marked context")
+	}
+
+	ctx, done := WithScheduling(context.Background(), nil)
+	if !Scheduling(ctx, nil) {
+		t.Fatal("expect scheduling in global scheduler")
+	}
+	local := &queueScheduler{}
+	if Scheduling(ctx, local) {
+		t.Fatal("unexpected scheduling in local scheduler")
+	}
+	done()
+	if Scheduling(ctx, nil) {
+		t.Fatal("unexpected scheduling after task returned")
+	}
+
+	ctx, done = WithScheduling(context.Background(), local)
+	defer done()
+	if !Scheduling(ctx, local) || Scheduling(ctx, &queueScheduler{}) || Scheduling(ctx, nil) {
+		t.Fatal("expect scheduling only in the same local scheduler")
+	}
+
+	// the schedulers of uncomparable types are never identified
+	f := funcScheduler(func(Task) {})
+	ctx, done = WithScheduling(context.Background(), f)
+	defer done()
+	if Scheduling(ctx, f) {
+		t.Fatal("unexpected scheduling in uncomparable scheduler")
+	}
+}
@@ -51,3 +51,11 @@ func (r *Router) Find(service string) (string, bool) {
 	}
 	return v.(string), true
 }
+
+// Range calls fn sequentially for each service and the bound address, Range
+// stops the iteration if fn returns false
+func (r *Router) Range(fn func(service, address string) bool) {
+	r.routes.Range(func(k, v interface{}) bool {
+		return fn(k.(string), v.(string))
+	})
+}
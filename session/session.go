@@ -21,6 +21,7 @@
 package session
 
 import (
+	"context"
 	"errors"
 	"net"
 	"sync"
@@ -34,6 +35,8 @@ import (
 type NetworkEntity interface {
 	Push(route string, v interface{}) error
 	RPC(route string, v interface{}) error
+	RPCContext(ctx context.Context, route string, v interface{}) error
+	Call(ctx context.Context, route string, v interface{}, reply interface{}) error
 	LastMid() uint64
 	Response(v interface{}) error
 	ResponseMid(mid uint64, v interface{}) error
@@ -60,6 +63,7 @@ type Session struct {
 	router       *Router
 	ip           string
 	userAgent    string
+	hook         func(key string) // called after the UID or data has been changed
 }
 
 // New returns a new session instance
@@ -89,6 +93,25 @@ func (s *Session) RPC(route string, v interface{}) error {
 	return s.entity.RPC(route, v)
 }
 
+// RPCContext sends message to remote server, the metadata and deadline of ctx
+// will be forwarded along with the message
+func (s *Session) RPCContext(ctx context.Context, route string, v interface{}) error {
+	return s.entity.RPCContext(ctx, route, v)
+}
+
+// Call sends request to the service of route and waits for the reply, the handler of
+// route should return the reply as the first result, reply will be left untouched if
+// the handler returns error only. The handler registered in current node will be
+// dispatched to its scheduler, the global one or the LocalScheduler of service, and
+// waited for, or invoked in the calling goroutine if ctx is the context received by a
+// handler running in the same scheduler. The handler calling a service of its own
+// scheduler should pass the context it received, otherwise the call blocks until ctx
+// is done, or forever if ctx has no deadline, since the scheduler cannot run the called
+// handler before the calling one returns.
+func (s *Session) Call(ctx context.Context, route string, v interface{}, reply interface{}) error {
+	return s.entity.Call(ctx, route, v, reply)
+}
+
 // Push message to client
 func (s *Session) Push(route string, v interface{}) error {
 	return s.entity.Push(route, v)
@@ -132,9 +155,29 @@ func (s *Session) Bind(uid int64) error {
 	}
 
 	atomic.StoreInt64(&s.uid, uid)
+	s.changed("")
 	return nil
 }
 
+// SetChangeHook sets the function which will be called after the UID has been bound,
+// or the value associated with the key has been set or removed. The key is empty when
+// the UID is bound. It is used by cluster to synchronize the session data between
+// gate and backends, and the hook should not block.
+func (s *Session) SetChangeHook(hook func(key string)) {
+	s.Lock()
+	s.hook = hook
+	s.Unlock()
+}
+
+func (s *Session) changed(key string) {
+	s.RLock()
+	hook := s.hook
+	s.RUnlock()
+	if hook != nil {
+		hook(key)
+	}
+}
+
 // Close terminate current session, session related data will not be released,
 // all related data should be Clear explicitly in Session closed callback
 func (s *Session) Close() {
@@ -149,17 +192,17 @@ func (s *Session) RemoteAddr() net.Addr {
 // Remove delete data associated with the key from session storage
 func (s *Session) Remove(key string) {
 	s.Lock()
-	defer s.Unlock()
-
 	delete(s.data, key)
+	s.Unlock()
+	s.changed(key)
 }
 
 // Set associates value with the key in session storage
 func (s *Session) Set(key string, value interface{}) {
 	s.Lock()
-	defer s.Unlock()
-
 	s.data[key] = value
+	s.Unlock()
+	s.changed(key)
 }
 
 // HasKey decides whether a key has associated value
@@ -1,6 +1,10 @@
 package session
 
-import "testing"
+import (
+	"context"
+	"reflect"
+	"testing"
+)
 
 func TestNewSession(t *testing.T) {
 	s := New(nil)
@@ -173,3 +177,44 @@ func TestSession_Restore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRouter_Range(t *testing.T) {
+	r := newRouter()
+	r.Bind("Room", "127.0.0.1:10000")
+	r.Bind("Chat", "127.0.0.1:10001")
+
+	routes := map[string]string{}
+	r.Range(func(service, address string) bool {
+		routes[service] = address
+		return true
+	})
+	if len(routes) != 2 || routes["Room"] != "127.0.0.1:10000" || routes["Chat"] != "127.0.0.1:10001" {
+		t.Fatalf("unexpected routes: %v", routes)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := WithMetadata(context.Background(), map[string]string{"trace-id": "1", "locale": "en"})
+	ctx = WithMetadata(ctx, map[string]string{"trace-id": "2"})
+	if MetadataValue(ctx, "trace-id") != "2" || MetadataValue(ctx, "locale") != "en" {
+		t.Fatalf("unexpected metadata: %v", Metadata(ctx))
+	}
+	if Metadata(context.Background()) != nil {
+		t.Fail()
+	}
+}
+
+func TestChangeHook(t *testing.T) {
+	s := New(nil)
+	var keys []string
+	s.SetChangeHook(func(key string) { keys = append(keys, key) })
+	s.Set("room", 1)
+	s.Remove("room")
+	if err := s.Bind(0); err == nil {
+		t.Fatal("expect illegal uid")
+	}
+	s.Bind(1)
+	if !reflect.DeepEqual(keys, []string{"room", "room", ""}) {
+		t.Fatalf("unexpected changes: %v", keys)
+	}
+}